@@ -68,6 +68,8 @@ type Client interface {
 	Keygen() (*ecdsa.PrivateKey, error)
 	// Derive the cryptographic public key and on-chain address from the private key
 	Derive(privateKey *ecdsa.PrivateKey) (*ecdsa.PublicKey, *common.Address, error)
+	// DeriveAddress derives the on-chain address from a compressed (33 bytes) or uncompressed (65 bytes) public key
+	DeriveAddress(publicKey []byte) (*common.Address, error)
 	// Sign an arbitrary message with the private key
 	Sign(message []byte, privateKey *ecdsa.PrivateKey) ([]byte, error)
 	// Verify the signature of an arbitrary message
@@ -192,6 +194,15 @@ func (tx *Transaction) Hash() (common.Hash, error) {
 	return gethTx.Hash(), nil
 }
 
+// SigningHash is the EIP155 hash that must be signed to produce the tx signature
+func (tx *Transaction) SigningHash() (common.Hash, error) {
+	gethTx, err := tx.AsGethTransaction()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return types.NewEIP155Signer(tx.ChainId).Hash(gethTx), nil
+}
+
 func (tx *Transaction) Serialize() ([]byte, error) {
 	gethTx, err := tx.AsGethTransaction()
 	if err != nil {
@@ -207,6 +218,9 @@ func (tx *Transaction) Deserialize(data []byte, chainId *big.Int) error {
 	if err = rlp.DecodeBytes(data, gethTx); err != nil {
 		return err
 	}
+	if gethTx.To() == nil {
+		return errors.New("can't deserialize contract creation transactions")
+	}
 	tx.TxMetadata = &TxMetadata{}
 	tx.Nonce = gethTx.Nonce()
 	tx.GasPrice = gethTx.GasPrice()
@@ -21,6 +21,7 @@ import (
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/core/types"
 	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/rlp"
 	. "github.com/onsi/gomega"
 )
 
@@ -91,4 +92,53 @@ func TestTransaction(t *testing.T) {
 		Ω(desrializedTx).Should(Equal(*signedTx))
 	})
 
+	t.Run("Deserialize contract creation", func(t *testing.T) {
+		RegisterTestingT(t)
+		chainId := big.NewInt(42220)
+		gethTx, err := types.SignTx(types.NewContractCreation(3, big.NewInt(0), 100000, big.NewInt(111), []byte{1, 2, 3, 4}), types.NewEIP155Signer(chainId), privKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		rawTx, err := rlp.EncodeToBytes(gethTx)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var tx Transaction
+		Ω(tx.Deserialize(rawTx, chainId)).ShouldNot(Succeed())
+	})
+
+}
+
+func TestDeriveAddress(t *testing.T) {
+	RegisterTestingT(t)
+
+	client := NewClient()
+	privKey, err := crypto.ToECDSA(common.Hex2Bytes("0c06d31f68640188d8baacdf2805aadf4d887b95a9f6e330b3cfcf6063ac010d"))
+	Ω(err).ShouldNot(HaveOccurred())
+
+	_, expectedAddr, err := client.Derive(privKey)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	addr, err := client.DeriveAddress(crypto.CompressPubkey(&privKey.PublicKey))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(addr).Should(Equal(expectedAddr))
+
+	addr, err = client.DeriveAddress(crypto.FromECDSAPub(&privKey.PublicKey))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(addr).Should(Equal(expectedAddr))
+
+	_, err = client.DeriveAddress([]byte{1, 2, 3})
+	Ω(err).Should(HaveOccurred())
+}
+
+func TestParseMethodAndArgs(t *testing.T) {
+	RegisterTestingT(t)
+	client := NewClient()
+
+	method, args, err := client.ParseMethodAndArgs(nil)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(method).Should(BeNil())
+	Ω(args).Should(BeNil())
+
+	for _, data := range [][]byte{{1}, {1, 2, 3}, {1, 2, 3, 4}} {
+		_, _, err = client.ParseMethodAndArgs(data)
+		Ω(err).Should(Equal(ErrUnknownMethod))
+	}
 }
@@ -16,12 +16,12 @@ package airgap
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
 
 	"github.com/celo-org/celo-blockchain/accounts"
 	"github.com/celo-org/celo-blockchain/accounts/abi"
 	"github.com/celo-org/celo-blockchain/common"
-	"github.com/celo-org/celo-blockchain/core/types"
 	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/kliento/contracts"
 	"github.com/celo-org/kliento/registry"
@@ -50,6 +50,25 @@ func (c *clientImpl) Derive(privateKey *ecdsa.PrivateKey) (*ecdsa.PublicKey, *co
 	return publicKeyECDSA, &address, nil
 }
 
+// DeriveAddress derives the on-chain address from a compressed (33 bytes) or uncompressed (65 bytes) public key
+func (c *clientImpl) DeriveAddress(publicKey []byte) (*common.Address, error) {
+	var publicKeyECDSA *ecdsa.PublicKey
+	var err error
+	switch len(publicKey) {
+	case 33:
+		publicKeyECDSA, err = crypto.DecompressPubkey(publicKey)
+	case 65:
+		publicKeyECDSA, err = crypto.UnmarshalPubkey(publicKey)
+	default:
+		err = fmt.Errorf("invalid public key length %d", len(publicKey))
+	}
+	if err != nil {
+		return nil, err
+	}
+	address := crypto.PubkeyToAddress(*publicKeyECDSA)
+	return &address, nil
+}
+
 // Sign signs an arbitrary message with the private key the returned signature
 // as 64 bytes long and in the [R || S] format.
 func (c *clientImpl) Sign(message []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
@@ -77,13 +96,11 @@ func (c *clientImpl) ConstructTxFromMetadata(tm *TxMetadata) (*Transaction, erro
 
 // SignTx signs an unsignedTx using the private key and returns a signedTx that can be submitted to the node.
 func (c *clientImpl) SignTx(tx *Transaction, privateKey *ecdsa.PrivateKey) (*Transaction, error) {
-	signer := types.NewEIP155Signer(tx.ChainId)
-	gethTx, err := tx.AsGethTransaction()
+	h, err := tx.SigningHash()
 	if err != nil {
 		return nil, err
 	}
 
-	h := signer.Hash(gethTx)
 	sig, err := crypto.Sign(h[:], privateKey)
 	if err != nil {
 		return nil, err
@@ -112,22 +129,37 @@ func (c *clientImpl) GenerateProofOfPossessionSignature(privateKey *ecdsa.Privat
 	return sig, err
 }
 
-var abiParsers = map[string]func() (*abi.ABI, error){
-	ReleaseGold:                            contracts.ParseReleaseGoldABI,
-	registry.AccountsContractID.String():   contracts.ParseAccountsABI,
-	registry.LockedGoldContractID.String(): contracts.ParseLockedGoldABI,
-	registry.ElectionContractID.String():   contracts.ParseElectionABI,
+type abiParser struct {
+	contract string
+	parse    func() (*abi.ABI, error)
+}
+
+// abiParsers are tried in order. Core contracts come before ReleaseGold so that
+// selectors shared by both (e.g. withdraw(uint256)) always resolve the same way.
+var abiParsers = []abiParser{
+	{registry.AccountsContractID.String(), contracts.ParseAccountsABI},
+	{registry.LockedGoldContractID.String(), contracts.ParseLockedGoldABI},
+	{registry.ElectionContractID.String(), contracts.ParseElectionABI},
+	{registry.StableTokenContractID.String(), contracts.ParseStableTokenABI},
+	{ReleaseGold, contracts.ParseReleaseGoldABI},
 }
 
+// ErrUnknownMethod is returned for calldata that doesn't call a known method
+var ErrUnknownMethod = errors.New("data does not match any abi parsers")
+
 func (c *clientImpl) ParseMethodAndArgs(data []byte) (*CeloMethod, []interface{}, error) {
 	if len(data) == 0 {
 		return nil, nil, nil
 	}
+	if len(data) < 4 {
+		// Too short for a method selector
+		return nil, nil, ErrUnknownMethod
+	}
 
 	methodId, methodData := data[:4], data[4:]
 
-	for contractName, abiParser := range abiParsers {
-		abi, err := abiParser()
+	for _, parser := range abiParsers {
+		abi, err := parser.parse()
 		if err != nil {
 			continue
 		}
@@ -142,14 +174,17 @@ func (c *clientImpl) ParseMethodAndArgs(data []byte) (*CeloMethod, []interface{}
 			return nil, nil, err
 		}
 
-		method, err := MethodFromString(fmt.Sprintf("%s.%s", contractName, abiMethod.Name))
+		method, err := MethodFromString(fmt.Sprintf("%s.%s", parser.contract, abiMethod.Name))
 		return method, args, err
 	}
 
-	return nil, nil, fmt.Errorf("data does not match any abi parsers")
+	return nil, nil, ErrUnknownMethod
 }
 
 func (c *clientImpl) ParseTxArgs(metadata *TxMetadata) (*TxArgs, error) {
+	if metadata == nil {
+		return nil, errors.New("missing tx metadata")
+	}
 	method, args, err := c.ParseMethodAndArgs(metadata.Data)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse method and args from %s with %s", metadata.Data, err.Error())
@@ -0,0 +1,162 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/eth/tracers"
+	"github.com/celo-org/kliento/celotokens"
+	"github.com/celo-org/kliento/client/debug"
+	"github.com/celo-org/rosetta/db"
+)
+
+// BlockTransfers holds the internal transfer operations of the txs of a block, by tx hash
+type BlockTransfers map[common.Hash][]Operation
+
+type blockTraceResult struct {
+	Result *debug.TransferTracerResponse `json:"result"`
+	Error  string                        `json:"error"`
+}
+
+// TraceBlockTransfers runs the transfer tracer once over the whole block and splits the result per tx.
+// The block is traced by hash, so a reorg can't swap it for another block at the same height.
+// txHashes must be the block's txs in order. Txs whose trace failed are left out of the result,
+// so TxTransfers falls back to tracing them individually.
+func (tr *Tracer) TraceBlockTransfers(blockHash common.Hash, blockNumber *big.Int, txHashes []common.Hash) (BlockTransfers, error) {
+	transfers := make(BlockTransfers, len(txHashes))
+	if len(txHashes) == 0 {
+		return transfers, nil
+	}
+
+	var results []blockTraceResult
+	timeout := tr.traceTimeout.String()
+	cfg := &tracers.TraceConfig{Tracer: &debug.TransferTracer, Timeout: &timeout}
+	if err := tr.cc.Rpc.CallContext(tr.ctx, &results, "debug_traceBlockByHash", blockHash, cfg); err != nil {
+		return nil, fmt.Errorf("can't run celo-rpc block-tracer: %w", err)
+	}
+	return splitBlockTrace(blockNumber, txHashes, results)
+}
+
+func splitBlockTrace(blockNumber *big.Int, txHashes []common.Hash, results []blockTraceResult) (BlockTransfers, error) {
+	if len(results) != len(txHashes) {
+		return nil, fmt.Errorf("block %s trace has %d results for %d txs", blockNumber, len(results), len(txHashes))
+	}
+
+	transfers := make(BlockTransfers, len(txHashes))
+	for i, result := range results {
+		if result.Error != "" || result.Result == nil {
+			continue
+		}
+		transfers[txHashes[i]] = InternalTransfersToOperations(result.Result.Transfers)
+	}
+	return transfers, nil
+}
+
+// WithBlockTransfers makes TxTransfers use the given block trace instead of tracing each tx
+func (tr *Tracer) WithBlockTransfers(transfers BlockTransfers) *Tracer {
+	tr.blockTransfers = transfers
+	return tr
+}
+
+// TraceBlock computes the operations of every tx of the block, by tx hash.
+// Transfers come from a single block trace, falling back to per tx traces if it fails.
+func (tr *Tracer) TraceBlock(block *types.Block) (map[common.Hash][]Operation, error) {
+	txHashes := make([]common.Hash, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		txHashes[i] = tx.Hash()
+	}
+
+	transfers, err := tr.TraceBlockTransfers(block.Hash(), block.Number(), txHashes)
+	if err != nil {
+		tr.logger.Warn("Block trace failed, tracing transactions individually", "blockNumber", block.Number(), "err", err)
+	} else {
+		tr.WithBlockTransfers(transfers)
+	}
+
+	blockOps := make(map[common.Hash][]Operation, len(txHashes))
+	for _, tx := range block.Transactions() {
+		receipt, err := tr.cc.Eth.TransactionReceipt(tr.ctx, tx.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("can't get receipt for %s: %w", tx.Hash().Hex(), err)
+		}
+
+		ops, err := tr.TraceTransaction(block.Header(), tx, receipt)
+		if err != nil {
+			return nil, err
+		}
+		blockOps[tx.Hash()] = ops
+	}
+	return blockOps, nil
+}
+
+// AccountOperations returns the account index entries of the operations of a tx
+func AccountOperations(txHash common.Hash, ops []Operation) []db.AccountOperation {
+	entries := make([]db.AccountOperation, 0)
+	for _, op := range ops {
+		currency := op.Currency
+		if currency == "" {
+			currency = celotokens.CELO
+		}
+		for _, change := range op.Changes {
+			subAccount := string(change.Account.SubAccount.Identifier)
+			if change.Account.SubAccount.Identifier == AccMain {
+				subAccount = ""
+			}
+			entries = append(entries, db.AccountOperation{
+				TxHash:     txHash,
+				Address:    change.Account.Address,
+				SubAccount: subAccount,
+				Type:       op.Type.String(),
+				Successful: op.Successful,
+				Currency:   string(currency),
+			})
+		}
+	}
+	return entries
+}
+
+// OperationsVersion is the version of the operations computed by the analyzer. It's increased whenever their types,
+// amounts or encoding change, so that the operations stored by a previous version are traced again and re-indexed.
+const OperationsVersion = 1
+
+// EncodeOperations serializes operations to be stored
+func EncodeOperations(ops []Operation) ([]byte, error) {
+	return json.Marshal(ops)
+}
+
+// DecodeOperations deserializes operations encoded with EncodeOperations
+func DecodeOperations(data []byte) ([]Operation, error) {
+	var ops []Operation
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, err
+	}
+
+	// Sub account metadata only holds addresses (see NewVotingAccount, NewSignerAccount)
+	for i := range ops {
+		for j := range ops[i].Changes {
+			for key, value := range ops[i].Changes[j].Account.SubAccount.Metadata {
+				if hex, ok := value.(string); ok && common.IsHexAddress(hex) {
+					ops[i].Changes[j].Account.SubAccount.Metadata[key] = common.HexToAddress(hex)
+				}
+			}
+		}
+	}
+	return ops, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/kliento/celotokens"
+	"github.com/celo-org/rosetta/db"
+	. "github.com/onsi/gomega"
+)
+
+func TestSplitBlockTrace(t *testing.T) {
+	RegisterTestingT(t)
+
+	// debug_traceBlockByHash response with the transfer tracer: one result per tx, in order
+	response := `[
+		{"result": {"transfers": [{"from": "0x0000000000000000000000000000000000001111", "to": "0x0000000000000000000000000000000000002222", "value": "0xa", "status": "success"}]}},
+		{"error": "execution timeout"},
+		{"result": {"transfers": []}}
+	]`
+	var results []blockTraceResult
+	Ω(json.Unmarshal([]byte(response), &results)).Should(Succeed())
+
+	txHashes := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")}
+
+	t.Run("Splits per tx", func(t *testing.T) {
+		RegisterTestingT(t)
+		transfers, err := splitBlockTrace(big.NewInt(100), txHashes, results)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(transfers).Should(HaveLen(2))
+		Ω(transfers[txHashes[0]]).Should(Equal([]Operation{*NewTransfer(address1, address2, amount1, true)}))
+		Ω(transfers).ShouldNot(HaveKey(txHashes[1]))
+		Ω(transfers[txHashes[2]]).Should(BeEmpty())
+	})
+
+	t.Run("Mismatched tx count", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, err := splitBlockTrace(big.NewInt(100), txHashes[:2], results)
+		Ω(err).Should(HaveOccurred())
+	})
+}
+
+func TestEncodeOperations(t *testing.T) {
+	RegisterTestingT(t)
+
+	ops := []Operation{
+		*NewTransfer(address1, address2, amount1, true),
+		*NewVote(address1, address3, amount2),
+		*NewStableTokenTransfer(celotokens.CEUR, address2, address4, amount1),
+	}
+
+	data, err := EncodeOperations(ops)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	decoded, err := DecodeOperations(data)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(decoded).Should(Equal(ops))
+}
+
+func TestAccountOperations(t *testing.T) {
+	RegisterTestingT(t)
+
+	txHash := common.HexToHash("0x01")
+	ops := []Operation{
+		*NewLockGold(address1, address3, amount1),
+		*NewStableTokenTransfer(celotokens.CEUR, address2, address4, amount1),
+	}
+
+	Ω(AccountOperations(txHash, ops)).Should(Equal([]db.AccountOperation{
+		{TxHash: txHash, Address: address1, SubAccount: "", Type: OpLockGold.String(), Successful: true, Currency: "CELO"},
+		{TxHash: txHash, Address: address3, SubAccount: "", Type: OpLockGold.String(), Successful: true, Currency: "CELO"},
+		{TxHash: txHash, Address: address1, SubAccount: string(AccLockedGoldNonVoting), Type: OpLockGold.String(), Successful: true, Currency: "CELO"},
+		{TxHash: txHash, Address: address2, SubAccount: "", Type: OpTransfer.String(), Successful: true, Currency: "cEUR"},
+		{TxHash: txHash, Address: address4, SubAccount: "", Type: OpTransfer.String(), Successful: true, Currency: "cEUR"},
+	}))
+}
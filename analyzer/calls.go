@@ -0,0 +1,199 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/kliento/registry"
+	"github.com/celo-org/rosetta/airgap"
+)
+
+// OperationsFromCall predicts the operations a core contract call will produce once it is
+// executed, using the same factories the tracer uses for the matching events.
+// Calls whose effect can't be known from the calldata alone (e.g. withdraw(index)) produce
+// no operations. Plain CELO transfers (method == nil) are returned as a single transfer.
+//
+// contractMap must contain the LockedGold address for ReleaseGold.lockGold to be predicted.
+func OperationsFromCall(from, to common.Address, value *big.Int, method *airgap.CeloMethod, args []interface{}, contractMap map[string]common.Address) []Operation {
+	if method == nil {
+		if value == nil || value.Sign() == 0 {
+			return nil
+		}
+		return []Operation{*NewTransfer(from, to, value, true)}
+	}
+
+	addressArg := func(i int) (common.Address, bool) {
+		if i >= len(args) {
+			return common.ZeroAddress, false
+		}
+		addr, ok := args[i].(common.Address)
+		return addr, ok
+	}
+	bigIntArg := func(i int) (*big.Int, bool) {
+		if i >= len(args) {
+			return nil, false
+		}
+		val, ok := args[i].(*big.Int)
+		return val, ok
+	}
+
+	var op *Operation
+	switch method {
+	// Accounts
+	case airgap.CreateAccount:
+		op = NewCreateAccount(from)
+	case airgap.ReleaseGoldCreateAccount:
+		op = NewCreateAccount(to)
+	case airgap.AuthorizeVoteSigner, airgap.AuthorizeAttestationSigner, airgap.AuthorizeValidatorSigner:
+		if signer, ok := addressArg(0); ok {
+			op = NewAuthorizeSigner(from, signer, authorizeOpFor(method))
+		}
+	case airgap.ReleaseGoldAuthorizeVoteSigner, airgap.ReleaseGoldAuthorizeAttestationSigner, airgap.ReleaseGoldAuthorizeValidatorSigner:
+		if signer, ok := addressArg(0); ok {
+			op = NewAuthorizeSigner(to, signer, authorizeOpFor(method))
+		}
+
+	// LockedGold
+	case airgap.LockGold:
+		if value != nil && value.Sign() > 0 {
+			op = NewLockGold(from, to, value)
+		}
+	case airgap.ReleaseGoldLockGold:
+		lockedGoldAddr, ok := contractMap[registry.LockedGoldContractID.String()]
+		if amount, isBigInt := bigIntArg(0); ok && isBigInt && amount.Sign() > 0 {
+			op = NewLockGold(to, lockedGoldAddr, amount)
+		}
+	case airgap.UnlockGold:
+		if amount, ok := bigIntArg(0); ok {
+			op = NewUnlockGold(from, amount)
+		}
+	case airgap.ReleaseGoldUnlockGold:
+		if amount, ok := bigIntArg(0); ok {
+			op = NewUnlockGold(to, amount)
+		}
+	case airgap.RelockGold:
+		if amount, ok := bigIntArg(1); ok {
+			op = NewRelockGold(from, amount)
+		}
+	case airgap.ReleaseGoldRelockGold:
+		if amount, ok := bigIntArg(1); ok {
+			op = NewRelockGold(to, amount)
+		}
+
+	// Election
+	case airgap.Vote:
+		group, okGroup := addressArg(0)
+		if amount, ok := bigIntArg(1); ok && okGroup {
+			op = NewVote(from, group, amount)
+		}
+	case airgap.ActivateVotes:
+		if group, ok := addressArg(0); ok {
+			op = NewActiveVotes(from, group, nil)
+		}
+	case airgap.RevokePendingVotes, airgap.ReleaseGoldRevokePendingVotes:
+		account := from
+		if method == airgap.ReleaseGoldRevokePendingVotes {
+			account = to
+		}
+		group, okGroup := addressArg(0)
+		if amount, ok := bigIntArg(1); ok && okGroup {
+			op = NewRevokePendingVotes(account, group, amount)
+		}
+	case airgap.RevokeActiveVotes, airgap.ReleaseGoldRevokeActiveVotes:
+		account := from
+		if method == airgap.ReleaseGoldRevokeActiveVotes {
+			account = to
+		}
+		group, okGroup := addressArg(0)
+		if amount, ok := bigIntArg(1); ok && okGroup {
+			op = NewRevokeActiveVotes(account, group, amount)
+		}
+
+	// ReleaseGold
+	case airgap.ReleaseGoldWithdraw:
+		// The released CELO is sent to the beneficiary, who is the caller
+		if amount, ok := bigIntArg(0); ok && amount.Sign() > 0 {
+			op = NewTransfer(to, from, amount, true)
+		}
+	}
+
+	if op == nil {
+		return nil
+	}
+	return []Operation{*op}
+}
+
+func authorizeOpFor(method *airgap.CeloMethod) OperationType {
+	switch method.Name {
+	case airgap.AuthorizeVoteSigner.Name:
+		return OpAuthorizeVoteSigner
+	case airgap.AuthorizeAttestationSigner.Name:
+		return OpAuthorizeAttestationSigner
+	default:
+		return OpAuthorizeValidatorSigner
+	}
+}
+
+// PredictTransaction predicts the operations of a mempool transaction as if it was
+// included in the block that follows lastHeader. Method and args are the decoded calldata
+// (nil if the calldata is empty or doesn't belong to a known core contract).
+func (tr *Tracer) PredictTransaction(lastHeader *types.Header, tx *types.Transaction, from common.Address, method *airgap.CeloMethod, args []interface{}) ([]Operation, error) {
+	ops := make([]Operation, 0)
+
+	gasOp, err := tr.PendingTxGasDetails(lastHeader, tx, from)
+	if err != nil {
+		return nil, err
+	}
+	if gasOp != nil {
+		ops = append(ops, *gasOp)
+	}
+
+	if tx.To() == nil {
+		// Contract creation: the new address is only known after execution
+		return ops, nil
+	}
+
+	contractMap, err := tr.db.RegistryAddressesStartOf(tr.ctx, lastHeader.Number, endOfBlockTxIndex, registry.LockedGoldContractID.String(), registry.ElectionContractID.String(), registry.AccountsContractID.String())
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching registry addresses: %w", err)
+	}
+
+	method = ResolveReleaseGoldMethod(method, *tx.To(), contractMap)
+	ops = append(ops, OperationsFromCall(from, *tx.To(), tx.Value(), method, args, contractMap)...)
+	return ops, nil
+}
+
+// ResolveReleaseGoldMethod reinterprets a core contract method as its ReleaseGold namesake when
+// the call is not sent to the core contract's registry address.
+// Core contracts and ReleaseGold share some selectors (e.g. withdraw(uint256)), so a call
+// to any other address can only be a ReleaseGold instance. Methods of contracts missing from
+// contractMap are returned as they are.
+func ResolveReleaseGoldMethod(method *airgap.CeloMethod, to common.Address, contractMap map[string]common.Address) *airgap.CeloMethod {
+	if method == nil || method.Contract == airgap.ReleaseGold {
+		return method
+	}
+	if addr, ok := contractMap[method.Contract]; !ok || addr == to {
+		return method
+	}
+	releaseGoldMethod, err := airgap.MethodFromString(fmt.Sprintf("%s.%s", airgap.ReleaseGold, method.Name))
+	if err != nil {
+		return nil
+	}
+	return releaseGoldMethod
+}
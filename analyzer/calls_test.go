@@ -0,0 +1,82 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/kliento/registry"
+	"github.com/celo-org/rosetta/airgap"
+	. "github.com/onsi/gomega"
+)
+
+func TestOperationsFromCall(t *testing.T) {
+	RegisterTestingT(t)
+
+	contractMap := map[string]common.Address{
+		registry.LockedGoldContractID.String(): address3,
+	}
+
+	t.Run("Plain transfer", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops := OperationsFromCall(address1, address2, amount1, nil, nil, contractMap)
+		Ω(ops).Should(Equal([]Operation{*NewTransfer(address1, address2, amount1, true)}))
+	})
+
+	t.Run("Zero value call to unknown contract", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops := OperationsFromCall(address1, address2, big.NewInt(0), nil, nil, contractMap)
+		Ω(ops).Should(BeEmpty())
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops := OperationsFromCall(address1, address3, amount1, airgap.LockGold, []interface{}{}, contractMap)
+		Ω(ops).Should(Equal([]Operation{*NewLockGold(address1, address3, amount1)}))
+	})
+
+	t.Run("ReleaseGold Lock", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops := OperationsFromCall(address1, address2, nil, airgap.ReleaseGoldLockGold, []interface{}{amount1}, contractMap)
+		Ω(ops).Should(Equal([]Operation{*NewLockGold(address2, address3, amount1)}))
+	})
+
+	t.Run("ReleaseGold Lock without LockedGold address", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops := OperationsFromCall(address1, address2, nil, airgap.ReleaseGoldLockGold, []interface{}{amount1}, nil)
+		Ω(ops).Should(BeEmpty())
+	})
+
+	t.Run("Vote", func(t *testing.T) {
+		RegisterTestingT(t)
+		args := []interface{}{address4, amount2, common.ZeroAddress, common.ZeroAddress}
+		ops := OperationsFromCall(address1, address2, nil, airgap.Vote, args, contractMap)
+		Ω(ops).Should(Equal([]Operation{*NewVote(address1, address4, amount2)}))
+	})
+
+	t.Run("ReleaseGold Withdraw", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops := OperationsFromCall(address1, address2, nil, airgap.ReleaseGoldWithdraw, []interface{}{amount1}, contractMap)
+		Ω(ops).Should(Equal([]Operation{*NewTransfer(address2, address1, amount1, true)}))
+	})
+
+	t.Run("Malformed args", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops := OperationsFromCall(address1, address2, nil, airgap.UnlockGold, []interface{}{"not an amount"}, contractMap)
+		Ω(ops).Should(BeEmpty())
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"reflect"
 
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/kliento/celotokens"
 	"github.com/celo-org/kliento/client/debug"
 )
 
@@ -35,6 +36,7 @@ const (
 	AccReleaseGoldVested           SubAccountType = "ReleaseGoldVested"
 	AccReleaseGoldUnvestedLocked   SubAccountType = "ReleaseGoldUnvestedLocked"
 	AccReleaseGoldUnvestedUnLocked SubAccountType = "ReleaseGoldUnvestedUnlocked"
+	AccGovernanceRefundedDeposit   SubAccountType = "GovernanceRefundedDeposit"
 )
 
 type SubAccount struct {
@@ -66,12 +68,35 @@ const (
 	OpRevokeActiveVotes          OperationType = "revokeActiveVotes"
 	OpSlash                      OperationType = "slash"
 	OpEpochRewards               OperationType = "epochRewards"
+	OpEpochVoterRewards          OperationType = "epochVoterRewards"
+	OpEpochCommunityFund         OperationType = "epochCommunityFund"
+	OpEpochCarbonFund            OperationType = "epochCarbonFund"
+	OpEpochValidatorPayments     OperationType = "epochValidatorPayments"
+	OpEpochGroupPayments         OperationType = "epochGroupPayments"
+	OpEpochReserveBacking        OperationType = "epochReserveBacking"
+	OpFeeDistribution            OperationType = "feeDistribution"
+	OpFeeBurn                    OperationType = "feeBurn"
+	OpRegisterValidator          OperationType = "registerValidator"
+	OpDeregisterValidator        OperationType = "deregisterValidator"
+	OpAffiliate                  OperationType = "affiliate"
+	OpDeaffiliate                OperationType = "deaffiliate"
+	OpRegisterValidatorGroup     OperationType = "registerValidatorGroup"
+	OpDeregisterValidatorGroup   OperationType = "deregisterValidatorGroup"
+	OpAddMember                  OperationType = "addMember"
+	OpRemoveMember               OperationType = "removeMember"
+	OpQueueCommissionUpdate      OperationType = "queueCommissionUpdate"
+	OpUpdateCommission           OperationType = "updateCommission"
+	OpPropose                    OperationType = "propose"
+	OpRefundDeposit              OperationType = "refundDeposit"
+	OpWithdrawDeposit            OperationType = "withdrawDeposit"
+	OpUpvoteProposal             OperationType = "upvoteProposal"
+	OpVoteProposal               OperationType = "voteProposal"
 )
 
 func (ot OperationType) String() string { return string(ot) }
 
 func (ot OperationType) requiresTransfer() bool {
-	return ot == OpLockGold || ot == OpWithdrawGold || ot == OpSlash
+	return ot == OpLockGold || ot == OpWithdrawGold || ot == OpSlash || ot == OpPropose
 }
 
 var AllOperationTypes = []OperationType{
@@ -91,6 +116,29 @@ var AllOperationTypes = []OperationType{
 	OpRevokeActiveVotes,
 	OpSlash,
 	OpEpochRewards,
+	OpEpochVoterRewards,
+	OpEpochCommunityFund,
+	OpEpochCarbonFund,
+	OpEpochValidatorPayments,
+	OpEpochGroupPayments,
+	OpEpochReserveBacking,
+	OpFeeDistribution,
+	OpFeeBurn,
+	OpRegisterValidator,
+	OpDeregisterValidator,
+	OpAffiliate,
+	OpDeaffiliate,
+	OpRegisterValidatorGroup,
+	OpDeregisterValidatorGroup,
+	OpAddMember,
+	OpRemoveMember,
+	OpQueueCommissionUpdate,
+	OpUpdateCommission,
+	OpPropose,
+	OpRefundDeposit,
+	OpWithdrawDeposit,
+	OpUpvoteProposal,
+	OpVoteProposal,
 }
 
 func AllOperationTypesString() []string {
@@ -110,6 +158,10 @@ type Operation struct {
 	Type       OperationType
 	Changes    []BalanceChange
 	Successful bool
+	// Currency of the balance changes, empty means CELO
+	Currency celotokens.CeloToken
+	// Metadata describes operations that don't (only) change balances, e.g. a group's commission
+	Metadata map[string]interface{} `json:",omitempty"`
 }
 
 // ---------------------------------------------------------------------------------------------------
@@ -159,6 +211,17 @@ func NewEpochRewards(changes map[common.Address]*big.Int) *Operation {
 	}
 }
 
+// NewEpochMints creates an epoch rewards operation of the given type, for the mints of currency to each
+// account (empty currency means CELO). See ComputeEpochRewards for the operation types.
+func NewEpochMints(opType OperationType, currency celotokens.CeloToken, changes []BalanceChange) *Operation {
+	return &Operation{
+		Type:       opType,
+		Changes:    changes,
+		Successful: true,
+		Currency:   currency,
+	}
+}
+
 func NewFee(changes map[common.Address]*big.Int) *Operation {
 	return &Operation{
 		Type:       OpFee,
@@ -175,6 +238,49 @@ func NewTransfer(from, to common.Address, value *big.Int, successful bool) *Oper
 	}
 }
 
+// NewStableTokenTransfer creates the operation for an ERC-20 Transfer event of a stable token.
+// Mints (from the zero address) and burns (to the zero address) only change the holder's balance.
+func NewStableTokenTransfer(token celotokens.CeloToken, from, to common.Address, value *big.Int) *Operation {
+	var changes []BalanceChange
+	if from != common.ZeroAddress {
+		changes = append(changes, BalanceChange{Account: NewAccount(from, AccMain), Amount: negate(value)})
+	}
+	if to != common.ZeroAddress {
+		changes = append(changes, BalanceChange{Account: NewAccount(to, AccMain), Amount: value})
+	}
+	return &Operation{
+		Type:       OpTransfer,
+		Successful: true,
+		Changes:    changes,
+		Currency:   token,
+	}
+}
+
+// BurnAddress is where GoldToken.burn sends the burnt CELO
+var BurnAddress = common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+
+// ClassifyFeeHandlerTransfers retypes the successful transfers out of the FeeHandler, which after Gingerbread
+// holds the base fees credited by the fee operations: transfers to BurnAddress become OpFeeBurn and transfers
+// to the fee beneficiary (the carbon fund) become OpFeeDistribution. Other operations are left untouched.
+func ClassifyFeeHandlerTransfers(ops []Operation, feeHandler, beneficiary common.Address) {
+	for i := range ops {
+		op := &ops[i]
+		if op.Type != OpTransfer || !op.Successful || len(op.Changes) != 2 {
+			continue
+		}
+		from, to := op.Changes[0], op.Changes[1]
+		if from.Account.Address != feeHandler || from.Amount.Sign() >= 0 {
+			continue
+		}
+		switch to.Account.Address {
+		case BurnAddress:
+			op.Type = OpFeeBurn
+		case beneficiary:
+			op.Type = OpFeeDistribution
+		}
+	}
+}
+
 func NewCreateAccount(from common.Address) *Operation {
 	return &Operation{
 		Type:       OpCreateAccount,
@@ -291,13 +397,197 @@ func NewRelockGold(addr common.Address, value *big.Int) *Operation {
 	}
 }
 
+// Validators operations don't change balances, but registering a validator or a group, and adding members
+// to a group, raise the amount of locked CELO the account can't unlock. The requirement of the account at
+// the end of the block, in wei, is reported as "lockedGoldRequirement"; it outlives a deregistration or a
+// removal for the duration set in the Validators contract.
+//
+// Ex. registerValidatorGroup()
+//
+//	groupAccMain    (no amount)    {lockedGoldRequirement: 10000000000000000000000, commission: 100000000000000000000000}
+func NewRegistration(opType OperationType, account common.Address, lockedGoldRequirement *big.Int) *Operation {
+	op := &Operation{
+		Type:       opType,
+		Successful: true,
+		Changes: []BalanceChange{
+			{Account: NewAccount(account, AccMain)},
+		},
+		Metadata: map[string]interface{}{},
+	}
+	if lockedGoldRequirement != nil {
+		op.Metadata["lockedGoldRequirement"] = lockedGoldRequirement.String()
+	}
+	return op
+}
+
+// NewAffiliation creates the OpAffiliate or OpDeaffiliate operation of a validator with a group
+func NewAffiliation(opType OperationType, validator, group common.Address) *Operation {
+	return &Operation{
+		Type:       opType,
+		Successful: true,
+		Changes: []BalanceChange{
+			{Account: NewAccount(validator, AccMain)},
+		},
+		Metadata: map[string]interface{}{
+			"group": group.Hex(),
+		},
+	}
+}
+
+// NewMembership creates the OpAddMember or OpRemoveMember operation of a validator in a group, with the
+// group's lockedGoldRequirement (see NewRegistration), which depends on its number of members.
+// Operation metadata is shared by every account, so the requirement is keyed as the group's.
+func NewMembership(opType OperationType, group, validator common.Address, groupLockedGoldRequirement *big.Int) *Operation {
+	op := &Operation{
+		Type:       opType,
+		Successful: true,
+		Changes: []BalanceChange{
+			{Account: NewAccount(group, AccMain)},
+			{Account: NewAccount(validator, AccMain)},
+		},
+		Metadata: map[string]interface{}{},
+	}
+	if groupLockedGoldRequirement != nil {
+		op.Metadata["groupLockedGoldRequirement"] = groupLockedGoldRequirement.String()
+	}
+	return op
+}
+
+// NewCommissionUpdate creates the OpQueueCommissionUpdate or OpUpdateCommission operation of a group.
+// The commission is a fixidity fraction (1e24 is 100%); activationBlock is only set when queued.
+func NewCommissionUpdate(opType OperationType, group common.Address, commission, activationBlock *big.Int) *Operation {
+	op := &Operation{
+		Type:       opType,
+		Successful: true,
+		Changes: []BalanceChange{
+			{Account: NewAccount(group, AccMain)},
+		},
+		Metadata: map[string]interface{}{
+			"commission": commission.String(),
+		},
+	}
+	if activationBlock != nil {
+		op.Metadata["activationBlock"] = activationBlock.String()
+	}
+	return op
+}
+
+// A Governance proposal locks the proposer's deposit in the Governance contract. Once the proposal is dequeued
+// the deposit is refunded to the proposer's GovernanceRefundedDeposit sub-account, from which withdraw() sends
+// it back to the proposer; the deposit of a proposal that expires in the queue stays in the community fund.
+// The proposal operations carry the "proposalId" in their metadata.
+//
+// Ex. propose(deposit=100 CELO)
+// Transfer Operation:
+//
+//	proposerAccMain         -100
+//	governanceAccMain        100
+//
+// Governance Operation (created from `ProposalQueued(proposalId, proposer, _, 100, _)` event):
+//
+//	proposerAccMain         -100    {proposalId}
+//	governanceAccMain        100    {proposalId}
+func NewPropose(proposer, governanceAddr common.Address, proposalId, deposit *big.Int) *Operation {
+	return &Operation{
+		Type:       OpPropose,
+		Successful: true,
+		Changes:    getTransferChanges(proposer, governanceAddr, deposit),
+		Metadata:   map[string]interface{}{"proposalId": proposalId.String()},
+	}
+}
+
+// Ex. dequeue of a proposal with a deposit of 100 CELO
+//
+//	proposerAccGovernanceRefundedDeposit    100    {proposalId}
+func NewRefundDeposit(proposer common.Address, proposalId, deposit *big.Int) *Operation {
+	return &Operation{
+		Type:       OpRefundDeposit,
+		Successful: true,
+		Changes: []BalanceChange{
+			{Account: NewAccount(proposer, AccGovernanceRefundedDeposit), Amount: deposit},
+		},
+		Metadata: map[string]interface{}{"proposalId": proposalId.String()},
+	}
+}
+
+// Ex. withdraw() of 100 CELO of refunded deposits, from any number of proposals
+//
+//	governanceAccMain                      -100
+//	toAccMain                               100
+//	toAccGovernanceRefundedDeposit         -100
+func NewWithdrawDeposit(addr, governanceAddr common.Address, value *big.Int) *Operation {
+	return &Operation{
+		Type:       OpWithdrawDeposit,
+		Successful: true,
+		Changes: append(
+			getTransferChanges(governanceAddr, addr, value),
+			BalanceChange{Account: NewAccount(addr, AccGovernanceRefundedDeposit), Amount: negate(value)},
+		),
+	}
+}
+
+// NewUpvoteProposal creates the operation of an account upvoting a queued proposal with its locked CELO
+func NewUpvoteProposal(account common.Address, proposalId, upvotes *big.Int) *Operation {
+	return &Operation{
+		Type:       OpUpvoteProposal,
+		Successful: true,
+		Changes: []BalanceChange{
+			{Account: NewAccount(account, AccMain)},
+		},
+		Metadata: map[string]interface{}{
+			"proposalId": proposalId.String(),
+			"upvotes":    upvotes.String(),
+		},
+	}
+}
+
+// NewVoteProposal creates the operation of an account voting on a proposal in referendum with its locked CELO
+func NewVoteProposal(account common.Address, proposalId, yesVotes, noVotes, abstainVotes *big.Int) *Operation {
+	return &Operation{
+		Type:       OpVoteProposal,
+		Successful: true,
+		Changes: []BalanceChange{
+			{Account: NewAccount(account, AccMain)},
+		},
+		Metadata: map[string]interface{}{
+			"proposalId":   proposalId.String(),
+			"yesVotes":     yesVotes.String(),
+			"noVotes":      noVotes.String(),
+			"abstainVotes": abstainVotes.String(),
+		},
+	}
+}
+
+// ClassifyDepositWithdrawal turns the transfer out of the Governance contract of a successful withdraw() call
+// into an OpWithdrawDeposit. Only the recipient of that transfer, the caller, had refunded deposits.
+func ClassifyDepositWithdrawal(ops []Operation, governanceAddr common.Address) {
+	for i := range ops {
+		op := &ops[i]
+		if op.Type != OpTransfer || !op.Successful || op.Currency != "" || len(op.Changes) != 2 {
+			continue
+		}
+		if from, to := op.Changes[0], op.Changes[1]; from.Account.Address == governanceAddr && from.Amount.Sign() < 0 {
+			*op = *NewWithdrawDeposit(to.Account.Address, governanceAddr, to.Amount)
+			return
+		}
+	}
+}
+
+// Votes for a group are first pending, in CELO, and become active votes once activated in a later epoch.
+// Active votes are held as units of the group's active votes, which grow with the epoch voter rewards,
+// so the CELO value of units can differ by rounding from the activated or revoked value.
+//
+// Ex. vote(100 CELO)
+//
+//	fromAccLockedNonVoting              -100
+//	fromAccLockedVotingPending(group)    100
 func NewVote(addr common.Address, group common.Address, value *big.Int) *Operation {
 	return &Operation{
 		Type:       OpVote,
 		Successful: true,
 		Changes: []BalanceChange{
 			{Account: NewAccount(addr, AccLockedGoldNonVoting), Amount: negate(value)},
-			{Account: NewVotingAccount(addr, AccLockedGoldVotingPending, group), Amount: nil},
+			{Account: NewVotingAccount(addr, AccLockedGoldVotingPending, group), Amount: value},
 		},
 	}
 }
@@ -307,8 +597,8 @@ func NewActiveVotes(addr common.Address, group common.Address, value *big.Int) *
 		Type:       OpActiveVotes,
 		Successful: true,
 		Changes: []BalanceChange{
-			{Account: NewVotingAccount(addr, AccLockedGoldVotingPending, group), Amount: nil},
-			{Account: NewVotingAccount(addr, AccLockedGoldVotingActive, group), Amount: nil},
+			{Account: NewVotingAccount(addr, AccLockedGoldVotingPending, group), Amount: negate(value)},
+			{Account: NewVotingAccount(addr, AccLockedGoldVotingActive, group), Amount: value},
 		},
 	}
 }
@@ -318,7 +608,7 @@ func NewRevokePendingVotes(addr common.Address, group common.Address, value *big
 		Type:       OpRevokePendingVotes,
 		Successful: true,
 		Changes: []BalanceChange{
-			{Account: NewVotingAccount(addr, AccLockedGoldVotingPending, group), Amount: nil},
+			{Account: NewVotingAccount(addr, AccLockedGoldVotingPending, group), Amount: negate(value)},
 			{Account: NewAccount(addr, AccLockedGoldNonVoting), Amount: value},
 		},
 	}
@@ -329,12 +619,28 @@ func NewRevokeActiveVotes(addr common.Address, group common.Address, value *big.
 		Type:       OpRevokeActiveVotes,
 		Successful: true,
 		Changes: []BalanceChange{
-			{Account: NewVotingAccount(addr, AccLockedGoldVotingActive, group), Amount: nil},
+			{Account: NewVotingAccount(addr, AccLockedGoldVotingActive, group), Amount: negate(value)},
 			{Account: NewAccount(addr, AccLockedGoldNonVoting), Amount: value},
 		},
 	}
 }
 
+// ActiveVotesValue converts units of a group's active votes to CELO, as the Election contract does
+func ActiveVotesValue(units, totalUnits, totalVotes *big.Int) *big.Int {
+	if totalUnits.Sign() == 0 {
+		return big.NewInt(0)
+	}
+	value := new(big.Int).Mul(units, totalVotes)
+	return value.Div(value, totalUnits)
+}
+
+// ActiveVotesReward is the growth of the CELO value of a voter's units when the group's active votes,
+// totalVotes after the rewards, grew by the voters' reward
+func ActiveVotesReward(units, totalUnits, totalVotes, reward *big.Int) *big.Int {
+	before := ActiveVotesValue(units, totalUnits, new(big.Int).Sub(totalVotes, reward))
+	return new(big.Int).Sub(ActiveVotesValue(units, totalUnits, totalVotes), before)
+}
+
 // ---------------------------------------------------------------------------------------------------
 // Helpers
 // ---------------------------------------------------------------------------------------------------
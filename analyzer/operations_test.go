@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/kliento/celotokens"
 	"github.com/celo-org/kliento/client/debug"
 	. "github.com/onsi/gomega"
 	gs "github.com/onsi/gomega/gstruct"
@@ -58,6 +59,8 @@ func MatchTransferOp(transfer debug.Transfer) gtypes.GomegaMatcher {
 		"Type":       Equal(OpTransfer),
 		"Successful": Equal(transfer.Status.String() == debug.TransferStatusSuccess.String()),
 		"Changes":    MatchTransferBalanceChanges(transfer),
+		"Currency":   BeEmpty(),
+		"Metadata":   BeNil(),
 	})
 }
 
@@ -150,3 +153,117 @@ func TestReconcileLogOpWithTransfers(t *testing.T) {
 		Ω(ReconcileLogOpsWithTransfers(logOps, transferOps)).Should(ConsistOf(getReconciledOps(logOps)))
 	})
 }
+
+func TestNewStableTokenTransfer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("Transfer", func(t *testing.T) {
+		RegisterTestingT(t)
+		op := NewStableTokenTransfer(celotokens.CEUR, address1, address2, amount1)
+		Ω(op.Currency).Should(Equal(celotokens.CEUR))
+		Ω(op.Changes).Should(ConsistOf(GetTransferBalanceChangeMatchers(address1, address2, amount1)...))
+	})
+
+	t.Run("Mint", func(t *testing.T) {
+		RegisterTestingT(t)
+		op := NewStableTokenTransfer(celotokens.CUSD, common.ZeroAddress, address2, amount1)
+		Ω(op.Changes).Should(ConsistOf(MatchBalanceChange(address2, amount1, AccMain)))
+	})
+
+	t.Run("Burn", func(t *testing.T) {
+		RegisterTestingT(t)
+		op := NewStableTokenTransfer(celotokens.CUSD, address1, common.ZeroAddress, amount1)
+		Ω(op.Changes).Should(ConsistOf(MatchBalanceChange(address1, new(big.Int).Neg(amount1), AccMain)))
+	})
+}
+
+func TestClassifyFeeHandlerTransfers(t *testing.T) {
+	RegisterTestingT(t)
+
+	feeHandler, beneficiary := address1, address2
+	ops := []Operation{
+		*NewFee(map[common.Address]*big.Int{address3: new(big.Int).Neg(amount2), feeHandler: amount2}),
+		*NewTransfer(feeHandler, BurnAddress, amount1, true),
+		*NewTransfer(feeHandler, beneficiary, amount1, true),
+		*NewStableTokenTransfer(celotokens.CUSD, feeHandler, beneficiary, amount1),
+		*NewTransfer(feeHandler, address3, amount1, true),
+		*NewTransfer(feeHandler, BurnAddress, amount1, false),
+		*NewTransfer(beneficiary, BurnAddress, amount1, true),
+	}
+	ClassifyFeeHandlerTransfers(ops, feeHandler, beneficiary)
+
+	types := make([]OperationType, len(ops))
+	for i, op := range ops {
+		types[i] = op.Type
+	}
+	Ω(types).Should(Equal([]OperationType{OpFee, OpFeeBurn, OpFeeDistribution, OpFeeDistribution, OpTransfer, OpTransfer, OpTransfer}))
+	Ω(ops[1].Changes).Should(ConsistOf(GetTransferBalanceChangeMatchers(feeHandler, BurnAddress, amount1)...))
+}
+
+func TestValidatorsOperations(t *testing.T) {
+	RegisterTestingT(t)
+
+	group, validator := address1, address2
+
+	op := NewRegistration(OpRegisterValidator, validator, big.NewInt(10000))
+	Ω(op.Changes).Should(ConsistOf(gs.MatchAllFields(gs.Fields{"Account": MatchAccount(validator, AccMain), "Amount": BeNil()})))
+	Ω(op.Metadata).Should(Equal(map[string]interface{}{"lockedGoldRequirement": "10000"}))
+
+	op = NewMembership(OpRemoveMember, group, validator, big.NewInt(20000))
+	Ω(op.Type).Should(Equal(OpRemoveMember))
+	Ω(op.Changes).Should(HaveLen(2))
+	Ω(op.Changes[0].Account).Should(MatchAccount(group, AccMain))
+	Ω(op.Changes[1].Account).Should(MatchAccount(validator, AccMain))
+	Ω(op.Metadata).Should(Equal(map[string]interface{}{"groupLockedGoldRequirement": "20000"}))
+
+	op = NewAffiliation(OpAffiliate, validator, group)
+	Ω(op.Metadata).Should(Equal(map[string]interface{}{"group": group.Hex()}))
+
+	op = NewCommissionUpdate(OpQueueCommissionUpdate, group, big.NewInt(100), big.NewInt(1000))
+	Ω(op.Metadata).Should(Equal(map[string]interface{}{"commission": "100", "activationBlock": "1000"}))
+	op = NewCommissionUpdate(OpUpdateCommission, group, big.NewInt(100), nil)
+	Ω(op.Metadata).Should(Equal(map[string]interface{}{"commission": "100"}))
+}
+
+func TestGovernanceDepositOperations(t *testing.T) {
+	RegisterTestingT(t)
+
+	proposer, governance := address1, address2
+	proposalId := big.NewInt(7)
+
+	t.Run("Propose reconciles with the deposit transfer", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		logOps := []Operation{*NewRefundDeposit(address3, big.NewInt(6), amount2), *NewPropose(proposer, governance, proposalId, amount1)}
+		transferOps := InternalTransfersToOperations([]debug.Transfer{NewInternalTransfer(proposer, governance, amount1, success)})
+		ops, err := ReconcileLogOpsWithTransfers(logOps, transferOps)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ops).Should(HaveLen(2))
+		Ω(ops[0].Changes).Should(ConsistOf(MatchBalanceChange(address3, amount2, AccGovernanceRefundedDeposit)))
+		Ω(ops[0].Metadata).Should(Equal(map[string]interface{}{"proposalId": "6"}))
+		Ω(ops[1].Type).Should(Equal(OpPropose))
+		Ω(ops[1].Changes).Should(ConsistOf(GetTransferBalanceChangeMatchers(proposer, governance, amount1)...))
+		Ω(ops[1].Metadata).Should(Equal(map[string]interface{}{"proposalId": "7"}))
+	})
+
+	t.Run("Withdraw", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		ops := InternalTransfersToOperations([]debug.Transfer{
+			NewInternalTransfer(governance, proposer, amount1, fail),
+			NewInternalTransfer(governance, proposer, amount2, success),
+		})
+		ClassifyDepositWithdrawal(ops, governance)
+
+		Ω(ops[0].Type).Should(Equal(OpTransfer))
+		Ω(ops[1].Type).Should(Equal(OpWithdrawDeposit))
+		Ω(ops[1].Changes).Should(ConsistOf(append(
+			GetTransferBalanceChangeMatchers(governance, proposer, amount2),
+			MatchBalanceChange(proposer, new(big.Int).Neg(amount2), AccGovernanceRefundedDeposit),
+		)...))
+	})
+
+	op := NewVoteProposal(proposer, proposalId, amount1, big.NewInt(0), amount2)
+	Ω(op.Metadata).Should(Equal(map[string]interface{}{"proposalId": "7", "yesVotes": "10", "noVotes": "0", "abstainVotes": "20"}))
+}
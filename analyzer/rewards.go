@@ -16,98 +16,372 @@ package analyzer
 
 import (
 	"context"
+	"fmt"
 	"math/big"
+	"strings"
 
+	ethereum "github.com/celo-org/celo-blockchain"
 	"github.com/celo-org/celo-blockchain/accounts/abi/bind"
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/kliento/celotokens"
 	"github.com/celo-org/kliento/client"
 	"github.com/celo-org/kliento/contracts"
+	"github.com/celo-org/kliento/registry"
 	"github.com/celo-org/rosetta/db"
+	"github.com/celo-org/rosetta/internal/utils"
+	"golang.org/x/sync/errgroup"
 )
 
-type rewardsContext struct {
-	ctx    context.Context
-	cc     *client.CeloClient
-	db     db.RosettaDBReader
-	header *types.Header
+// epochRewardsAddresses are the recipients and emitters of the epoch rewards, at the start of the epoch rewards tx
+type epochRewardsAddresses struct {
+	GoldToken           common.Address
+	StableToken         common.Address
+	Validators          common.Address
+	Election            common.Address
+	LockedGold          common.Address
+	Governance          common.Address
+	Reserve             common.Address
+	CarbonOffsetPartner common.Address
 }
 
-func ComputeEpochRewards(ctx context.Context, cc *client.CeloClient, db db.RosettaDBReader, header *types.Header) (*Operation, error) {
-	rctx := &rewardsContext{
-		ctx:    ctx,
-		cc:     cc,
-		db:     db,
-		header: header,
-	}
+// voterUnitsCalls bounds the concurrent calls for the active vote units of a group's voters
+const voterUnitsCalls = 16
+
+var epochRewardsContracts = []string{
+	registry.GoldTokenContractID.String(),
+	registry.StableTokenContractID.String(),
+	registry.ValidatorsContractID.String(),
+	registry.ElectionContractID.String(),
+	registry.LockedGoldContractID.String(),
+	registry.GovernanceContractID.String(),
+	registry.ReserveContractID.String(),
+	registry.SortedOraclesContractID.String(),
+}
 
-	rewards := make(map[common.Address]*big.Int)
+// ComputeEpochRewards returns the operations of the epoch rewards distributed in the last block of an epoch,
+// by category:
+//
+//	epochVoterRewards       CELO minted to the LockedGold contract, and the growth of the voters' active votes
+//	epochCommunityFund      CELO minted to the Governance contract (or to the Reserve, when it is low)
+//	epochCarbonFund         CELO minted to the carbon offsetting partner
+//	epochValidatorPayments  cUSD minted to the validators (and their payment beneficiaries)
+//	epochGroupPayments      cUSD minted to the validator groups
+//	epochReserveBacking     CELO minted to the Reserve to back the cUSD payments
+//	epochRewards            Any other mint of the epoch rewards
+//
+// The voters' active votes growth needs every group voter: it fails with db.ErrGroupVotersIncomplete until
+// the voters of the blocks processed before they were tracked are backfilled.
+func ComputeEpochRewards(ctx context.Context, cc *client.CeloClient, db_ db.RosettaDBReader, header *types.Header) ([]Operation, error) {
 	// Epoch rewards are distributed in the last tx of each block.
-	txIndex, err := rctx.cc.Eth.TransactionCount(rctx.ctx, rctx.header.Hash())
+	txIndex, err := cc.Eth.TransactionCount(ctx, header.Hash())
 	if err != nil {
 		return nil, err
 	}
 
-	// Ensure that we fetch the goldToken contract event from the current
-	goldToken, err := rctx.getGoldToken(txIndex)
+	contractMap, err := db_.RegistryAddressesStartOf(ctx, header.Number, txIndex, epochRewardsContracts...)
 	if err != nil {
 		return nil, err
 	}
-	if goldToken == nil {
+	if contractMap[registry.GoldTokenContractID.String()] == common.ZeroAddress {
 		// we don't have the GoldToken address =>
-		// We assume rewards are active AFTER migration. So, we return an empty map
-		return NewEpochRewards(rewards), nil
+		// We assume rewards are active AFTER migration. So, there are no rewards
+		return []Operation{}, nil
 	}
 
-	if err := rctx.computeRewards(rewards, goldToken, txIndex); err != nil {
+	carbonOffsetPartner, err := db_.CarbonOffsetPartnerStartOf(ctx, header.Number, txIndex)
+	if err != nil {
 		return nil, err
 	}
 
-	// NOTE: Validator & Validator Groups rewards are in CUSD. We don't cover them for now
+	addresses := &epochRewardsAddresses{
+		GoldToken:           contractMap[registry.GoldTokenContractID.String()],
+		StableToken:         contractMap[registry.StableTokenContractID.String()],
+		Validators:          contractMap[registry.ValidatorsContractID.String()],
+		Election:            contractMap[registry.ElectionContractID.String()],
+		LockedGold:          contractMap[registry.LockedGoldContractID.String()],
+		Governance:          contractMap[registry.GovernanceContractID.String()],
+		Reserve:             contractMap[registry.ReserveContractID.String()],
+		CarbonOffsetPartner: carbonOffsetPartner,
+	}
 
-	return NewEpochRewards(rewards), nil
-}
+	emitters := []common.Address{addresses.GoldToken}
+	if addresses.StableToken != common.ZeroAddress {
+		emitters = append(emitters, addresses.StableToken)
+	}
+	if addresses.Validators != common.ZeroAddress {
+		emitters = append(emitters, addresses.Validators)
+	}
+	if addresses.Election != common.ZeroAddress {
+		emitters = append(emitters, addresses.Election)
+	}
 
-func (rctx *rewardsContext) blockNumber() *big.Int {
-	return rctx.header.Number
-}
+	logs, err := cc.Eth.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: header.Number,
+		ToBlock:   header.Number,
+		Addresses: emitters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	epochLogs := make([]types.Log, 0, len(logs))
+	for _, eventLog := range logs {
+		if eventLog.TxIndex == txIndex {
+			epochLogs = append(epochLogs, eventLog)
+		}
+	}
 
-func (rctx *rewardsContext) computeRewards(rewardsMap map[common.Address]*big.Int, token *contracts.GoldToken, txIndex uint) error {
-	blockNumber := rctx.blockNumber().Uint64()
+	election, err := contracts.NewElection(addresses.Election, cc.Eth)
+	if err != nil {
+		return nil, fmt.Errorf("can't initialize Election contract: %w", err)
+	}
+	callOpts := &bind.CallOpts{Context: ctx, BlockNumber: header.Number}
 
-	iter, err := token.FilterTransfer(&bind.FilterOpts{
-		Start:   blockNumber,
-		End:     &blockNumber,
-		Context: rctx.ctx,
-	}, []common.Address{common.ZeroAddress}, nil)
+	// celo-blockchain converts the payments with the rate of the node's current block, approximated by the parent's
+	// state: the conversion only tells whether a backing was minted at all. Like celo-blockchain's GetExchangeRate,
+	// it falls back to 1:1 when the rate isn't available.
+	sortedOracles, err := contracts.NewSortedOracles(contractMap[registry.SortedOraclesContractID.String()], cc.Eth)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("can't initialize SortedOracles contract: %w", err)
+	}
+	reserveBacking := func(payments *big.Int) *big.Int {
+		if contractMap[registry.SortedOraclesContractID.String()] == common.ZeroAddress {
+			return payments
+		}
+		numerator, denominator, err := sortedOracles.MedianRate(&bind.CallOpts{Context: ctx, BlockNumber: utils.Dec(header.Number)}, addresses.StableToken)
+		if err != nil {
+			return payments
+		}
+		backing, err := ConvertToCelo(payments, numerator, denominator)
+		if err != nil {
+			return payments
+		}
+		return backing
 	}
 
-	for iter.Next() {
-		if iter.Event.Raw.TxIndex == txIndex {
-			rewardsMap[iter.Event.To] = iter.Event.Value
+	// The state at the end of the block is after the rewards, as they are distributed in the last tx
+	groupVoterRewards := func(group common.Address, reward *big.Int) ([]BalanceChange, error) {
+		voters, err := db_.GroupVoters(ctx, header.Number, group)
+		if err != nil {
+			return nil, err
 		}
+		totalVotes, err := election.GetActiveVotesForGroup(callOpts, group)
+		if err != nil {
+			return nil, err
+		}
+		totalUnits, err := election.GetActiveVoteUnitsForGroup(callOpts, group)
+		if err != nil {
+			return nil, err
+		}
+
+		// A group can have thousands of voters, their units are read with at most voterUnitsCalls concurrent calls
+		voterUnits := make([]*big.Int, len(voters))
+		calls, callsCtx := errgroup.WithContext(ctx)
+		calls.SetLimit(voterUnitsCalls)
+		for i, voter := range voters {
+			i, voter := i, voter
+			calls.Go(func() error {
+				units, err := election.GetActiveVoteUnitsForGroupByAccount(&bind.CallOpts{Context: callsCtx, BlockNumber: header.Number}, group, voter)
+				voterUnits[i] = units
+				return err
+			})
+		}
+		if err := calls.Wait(); err != nil {
+			return nil, err
+		}
+
+		changes := make([]BalanceChange, 0, len(voters))
+		for i, voter := range voters {
+			if voterReward := ActiveVotesReward(voterUnits[i], totalUnits, totalVotes, reward); voterReward.Sign() > 0 {
+				changes = append(changes, BalanceChange{Account: NewVotingAccount(voter, AccLockedGoldVotingActive, group), Amount: voterReward})
+			}
+		}
+		return changes, nil
 	}
 
-	return nil
+	return epochRewardsOperations(epochLogs, addresses, groupVoterRewards, reserveBacking)
 }
 
-func (rctx *rewardsContext) getGoldToken(txIndex uint) (*contracts.GoldToken, error) {
-	address, err := rctx.db.RegistryAddressStartOf(rctx.ctx, rctx.blockNumber(), txIndex, "GoldToken")
-	if err != nil && err != db.ErrContractNotFound {
-		return nil, err
+// isUnknownEvent returns whether the error is for an event missing from the bindings, e.g. added by a contract upgrade
+func isUnknownEvent(err error) bool {
+	return strings.HasPrefix(err.Error(), "no event with id")
+}
+
+// epochMints sums the mints to each account, keeping the order of the first mint
+type epochMints struct {
+	order   []common.Address
+	amounts map[common.Address]*big.Int
+}
+
+func (em *epochMints) add(to common.Address, value *big.Int) {
+	if em.amounts == nil {
+		em.amounts = make(map[common.Address]*big.Int)
 	}
-	if address == common.ZeroAddress || err == db.ErrContractNotFound {
-		// we don't have the address  =>
-		// We assume rewards are active AFTER migration. So, we return nil
-		return nil, nil
+	if amount, ok := em.amounts[to]; ok {
+		em.amounts[to] = new(big.Int).Add(amount, value)
+		return
 	}
+	em.order = append(em.order, to)
+	em.amounts[to] = value
+}
+
+func (em *epochMints) changes() []BalanceChange {
+	changes := make([]BalanceChange, len(em.order))
+	for i, addr := range em.order {
+		changes[i] = BalanceChange{Account: NewAccount(addr, AccMain), Amount: em.amounts[addr]}
+	}
+	return changes
+}
 
-	goldToken, err := contracts.NewGoldToken(address, rctx.cc.Eth)
+// epochRewardsOperations classifies the mints in the logs of the epoch rewards tx, which are in the order of
+// celo-blockchain's distribution: the stable token payments of each validator, followed by its
+// ValidatorEpochPaymentDistributed event; then CELO to the Reserve to back the payments, to the community fund,
+// to the voters (with an EpochRewardsDistributedToVoters event per group, whose voters' active votes grow as
+// returned by groupVoterRewards) and to the carbon offsetting partner (see distributeEpochRewards in
+// celo-blockchain's consensus/istanbul/backend/pos.go).
+// Before Gingerbread the community fund is minted to the Reserve when it is low, after the backing: the first Reserve
+// mint is the backing, unless none was minted because the payments converted to CELO by reserveBacking round to 0.
+func epochRewardsOperations(logs []types.Log, addresses *epochRewardsAddresses, groupVoterRewards func(group common.Address, reward *big.Int) ([]BalanceChange, error), reserveBacking func(payments *big.Int) *big.Int) ([]Operation, error) {
+	// Parsing doesn't use the backend
+	goldToken, err := contracts.NewGoldToken(addresses.GoldToken, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("can't initialize GoldToken contract: %w", err)
+	}
+	stableToken, err := contracts.NewStableToken(addresses.StableToken, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't initialize StableToken contract: %w", err)
+	}
+	validators, err := contracts.NewValidators(addresses.Validators, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't initialize Validators contract: %w", err)
+	}
+	election, err := contracts.NewElection(addresses.Election, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't initialize Election contract: %w", err)
+	}
+
+	var voterRewards, communityFund, carbonFund, validatorPayments, groupPayments, backing, otherMints epochMints
+	var activeVotesRewards []BalanceChange
+	var reserveMints []*big.Int
+	var pendingPayments []*contracts.StableTokenTransfer
+	totalPayments := big.NewInt(0)
+
+	for _, eventLog := range logs {
+		switch eventLog.Address {
+		case addresses.GoldToken:
+			eventName, eventRaw, ok, err := goldToken.TryParseLog(eventLog)
+			if err != nil && !isUnknownEvent(err) {
+				return nil, fmt.Errorf("can't parse GoldToken event: %w", err)
+			}
+			if err != nil || !ok || eventName != "Transfer" {
+				continue
+			}
+			event := eventRaw.(*contracts.GoldTokenTransfer)
+			if event.From != common.ZeroAddress || event.Value.Sign() == 0 {
+				continue
+			}
+
+			switch event.To {
+			case addresses.LockedGold:
+				voterRewards.add(event.To, event.Value)
+			case addresses.Governance:
+				communityFund.add(event.To, event.Value)
+			case addresses.CarbonOffsetPartner:
+				carbonFund.add(event.To, event.Value)
+			case addresses.Reserve:
+				reserveMints = append(reserveMints, event.Value)
+			default:
+				otherMints.add(event.To, event.Value)
+			}
+
+		case addresses.StableToken:
+			eventName, eventRaw, ok, err := stableToken.TryParseLog(eventLog)
+			if err != nil && !isUnknownEvent(err) {
+				return nil, fmt.Errorf("can't parse StableToken event: %w", err)
+			}
+			if err != nil || !ok || eventName != "Transfer" {
+				continue
+			}
+			event := eventRaw.(*contracts.StableTokenTransfer)
+			if event.From == common.ZeroAddress && event.Value.Sign() > 0 {
+				pendingPayments = append(pendingPayments, event)
+				totalPayments.Add(totalPayments, event.Value)
+			}
+
+		case addresses.Validators:
+			eventName, eventRaw, ok, err := validators.TryParseLog(eventLog)
+			if err != nil && !isUnknownEvent(err) {
+				return nil, fmt.Errorf("can't parse Validators event: %w", err)
+			}
+			if err != nil || !ok || eventName != "ValidatorEpochPaymentDistributed" {
+				continue
+			}
+			event := eventRaw.(*contracts.ValidatorsValidatorEpochPaymentDistributed)
+
+			// The payments minted since the previous event are the group's and the validator's (or its beneficiary's)
+			groupPaid := false
+			for _, payment := range pendingPayments {
+				if !groupPaid && payment.To == event.Group && payment.Value.Cmp(event.GroupPayment) == 0 {
+					groupPayments.add(payment.To, payment.Value)
+					groupPaid = true
+				} else {
+					validatorPayments.add(payment.To, payment.Value)
+				}
+			}
+			pendingPayments = nil
+
+		case addresses.Election:
+			eventName, eventRaw, ok, err := election.TryParseLog(eventLog)
+			if err != nil && !isUnknownEvent(err) {
+				return nil, fmt.Errorf("can't parse Election event: %w", err)
+			}
+			if err != nil || !ok || eventName != "EpochRewardsDistributedToVoters" {
+				continue
+			}
+			event := eventRaw.(*contracts.ElectionEpochRewardsDistributedToVoters)
+
+			changes, err := groupVoterRewards(event.Group, event.Value)
+			if err != nil {
+				return nil, fmt.Errorf("can't compute the voter rewards of group %s: %w", event.Group.Hex(), err)
+			}
+			activeVotesRewards = append(activeVotesRewards, changes...)
+		}
+	}
+	// Only validator payments are minted in stable tokens
+	for _, payment := range pendingPayments {
+		validatorPayments.add(payment.To, payment.Value)
+	}
+
+	// The payments are backed first (zero mints emit no event), then the community fund goes to the Reserve if it is low
+	backingMinted := totalPayments.Sign() > 0 && reserveBacking(totalPayments).Sign() > 0
+	for i, value := range reserveMints {
+		if i == 0 && backingMinted {
+			backing.add(addresses.Reserve, value)
+		} else {
+			communityFund.add(addresses.Reserve, value)
+		}
 	}
 
-	return goldToken, nil
+	ops := make([]Operation, 0)
+	if len(voterRewards.order) > 0 || len(activeVotesRewards) > 0 {
+		ops = append(ops, *NewEpochMints(OpEpochVoterRewards, "", append(voterRewards.changes(), activeVotesRewards...)))
+	}
+	for _, category := range []struct {
+		opType   OperationType
+		currency celotokens.CeloToken
+		mints    *epochMints
+	}{
+		{OpEpochCommunityFund, "", &communityFund},
+		{OpEpochCarbonFund, "", &carbonFund},
+		{OpEpochValidatorPayments, celotokens.CUSD, &validatorPayments},
+		{OpEpochGroupPayments, celotokens.CUSD, &groupPayments},
+		{OpEpochReserveBacking, "", &backing},
+		{OpEpochRewards, "", &otherMints},
+	} {
+		if len(category.mints.order) > 0 {
+			ops = append(ops, *NewEpochMints(category.opType, category.currency, category.mints.changes()))
+		}
+	}
+	return ops, nil
 }
@@ -0,0 +1,215 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/accounts/abi"
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/kliento/celotokens"
+	"github.com/celo-org/kliento/contracts"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func newEventLog(t *testing.T, contractABI *abi.ABI, address common.Address, eventName string, indexed []common.Address, values ...interface{}) types.Log {
+	event := contractABI.Events[eventName]
+	data, err := event.Inputs.NonIndexed().Pack(values...)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	topics := []common.Hash{event.ID}
+	for _, addr := range indexed {
+		topics = append(topics, common.BytesToHash(addr.Bytes()))
+	}
+	return types.Log{Address: address, Topics: topics, Data: data}
+}
+
+func TestEpochRewardsOperations(t *testing.T) {
+	RegisterTestingT(t)
+
+	goldTokenABI, err := contracts.ParseGoldTokenABI()
+	Ω(err).ShouldNot(HaveOccurred())
+	stableTokenABI, err := contracts.ParseStableTokenABI()
+	Ω(err).ShouldNot(HaveOccurred())
+	validatorsABI, err := contracts.ParseValidatorsABI()
+	Ω(err).ShouldNot(HaveOccurred())
+	electionABI, err := contracts.ParseElectionABI()
+	Ω(err).ShouldNot(HaveOccurred())
+
+	addresses := &epochRewardsAddresses{
+		GoldToken:           common.HexToAddress("0x01"),
+		StableToken:         common.HexToAddress("0x02"),
+		Validators:          common.HexToAddress("0x03"),
+		Election:            common.HexToAddress("0x08"),
+		LockedGold:          common.HexToAddress("0x04"),
+		Governance:          common.HexToAddress("0x05"),
+		Reserve:             common.HexToAddress("0x06"),
+		CarbonOffsetPartner: common.HexToAddress("0x07"),
+	}
+	validator1, validator2, beneficiary := common.HexToAddress("0x11"), common.HexToAddress("0x12"), common.HexToAddress("0x13")
+	group := common.HexToAddress("0x21")
+
+	celoMint := func(to common.Address, value int64) types.Log {
+		return newEventLog(t, goldTokenABI, addresses.GoldToken, "Transfer", []common.Address{common.ZeroAddress, to}, big.NewInt(value))
+	}
+	cUSDMint := func(to common.Address, value int64) types.Log {
+		return newEventLog(t, stableTokenABI, addresses.StableToken, "Transfer", []common.Address{common.ZeroAddress, to}, big.NewInt(value))
+	}
+	paymentDistributed := func(validator common.Address, validatorPayment int64, groupPayment int64) types.Log {
+		return newEventLog(t, validatorsABI, addresses.Validators, "ValidatorEpochPaymentDistributed", []common.Address{validator, group}, big.NewInt(validatorPayment), big.NewInt(groupPayment))
+	}
+
+	voter := common.HexToAddress("0x31")
+	votersRewarded := func(group common.Address, reward *big.Int) ([]BalanceChange, error) {
+		return []BalanceChange{{Account: NewVotingAccount(voter, AccLockedGoldVotingActive, group), Amount: reward}}, nil
+	}
+	// 1 CELO is worth 2 cUSD
+	reserveBacking := func(payments *big.Int) *big.Int {
+		backing, err := ConvertToCelo(payments, big.NewInt(2), big.NewInt(1))
+		Ω(err).ShouldNot(HaveOccurred())
+		return backing
+	}
+	rewardsDistributed := func(value int64) types.Log {
+		return newEventLog(t, electionABI, addresses.Election, "EpochRewardsDistributedToVoters", []common.Address{group}, big.NewInt(value))
+	}
+
+	matchOp := func(opType OperationType, currency celotokens.CeloToken, changes ...interface{}) interface{} {
+		return gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"Type":     Equal(opType),
+			"Currency": Equal(currency),
+			"Changes":  ConsistOf(changes...),
+		})
+	}
+
+	t.Run("All categories", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops, err := epochRewardsOperations([]types.Log{
+			cUSDMint(group, 10),
+			cUSDMint(validator1, 90),
+			paymentDistributed(validator1, 90, 10),
+			cUSDMint(group, 10),
+			cUSDMint(validator2, 60),
+			cUSDMint(beneficiary, 30),
+			paymentDistributed(validator2, 60, 10),
+			celoMint(addresses.Reserve, 100),
+			celoMint(addresses.Governance, 200),
+			rewardsDistributed(1000),
+			celoMint(addresses.LockedGold, 1000),
+			celoMint(addresses.CarbonOffsetPartner, 5),
+		}, addresses, votersRewarded, reserveBacking)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ops).Should(ConsistOf(
+			matchOp(OpEpochVoterRewards, "",
+				MatchBalanceChange(addresses.LockedGold, big.NewInt(1000), AccMain),
+				MatchBalanceChange(voter, big.NewInt(1000), AccLockedGoldVotingActive),
+			),
+			matchOp(OpEpochCommunityFund, "", MatchBalanceChange(addresses.Governance, big.NewInt(200), AccMain)),
+			matchOp(OpEpochCarbonFund, "", MatchBalanceChange(addresses.CarbonOffsetPartner, big.NewInt(5), AccMain)),
+			matchOp(OpEpochValidatorPayments, celotokens.CUSD,
+				MatchBalanceChange(validator1, big.NewInt(90), AccMain),
+				MatchBalanceChange(validator2, big.NewInt(60), AccMain),
+				MatchBalanceChange(beneficiary, big.NewInt(30), AccMain),
+			),
+			matchOp(OpEpochGroupPayments, celotokens.CUSD, MatchBalanceChange(group, big.NewInt(20), AccMain)),
+			matchOp(OpEpochReserveBacking, "", MatchBalanceChange(addresses.Reserve, big.NewInt(100), AccMain)),
+		))
+	})
+
+	t.Run("Community fund to a low reserve", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops, err := epochRewardsOperations([]types.Log{
+			cUSDMint(group, 10),
+			cUSDMint(validator1, 90),
+			paymentDistributed(validator1, 90, 10),
+			celoMint(addresses.Reserve, 50),
+			celoMint(addresses.Reserve, 200),
+			celoMint(addresses.LockedGold, 1000),
+		}, addresses, votersRewarded, reserveBacking)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ops).Should(ContainElement(matchOp(OpEpochReserveBacking, "", MatchBalanceChange(addresses.Reserve, big.NewInt(50), AccMain))))
+		Ω(ops).Should(ContainElement(matchOp(OpEpochCommunityFund, "", MatchBalanceChange(addresses.Reserve, big.NewInt(200), AccMain))))
+	})
+
+	t.Run("Backing of another amount", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops, err := epochRewardsOperations([]types.Log{
+			cUSDMint(group, 10),
+			cUSDMint(validator1, 90),
+			paymentDistributed(validator1, 90, 10),
+			celoMint(addresses.Reserve, 51),
+			celoMint(addresses.Reserve, 200),
+		}, addresses, votersRewarded, reserveBacking)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ops).Should(ContainElement(matchOp(OpEpochReserveBacking, "", MatchBalanceChange(addresses.Reserve, big.NewInt(51), AccMain))))
+		Ω(ops).Should(ContainElement(matchOp(OpEpochCommunityFund, "", MatchBalanceChange(addresses.Reserve, big.NewInt(200), AccMain))))
+	})
+
+	t.Run("Backing rounded to zero", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops, err := epochRewardsOperations([]types.Log{
+			cUSDMint(validator1, 1),
+			paymentDistributed(validator1, 1, 0),
+			celoMint(addresses.Reserve, 200),
+		}, addresses, votersRewarded, reserveBacking)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ops).Should(ConsistOf(
+			matchOp(OpEpochCommunityFund, "", MatchBalanceChange(addresses.Reserve, big.NewInt(200), AccMain)),
+			matchOp(OpEpochValidatorPayments, celotokens.CUSD, MatchBalanceChange(validator1, big.NewInt(1), AccMain)),
+		))
+	})
+
+	t.Run("No payments", func(t *testing.T) {
+		RegisterTestingT(t)
+		ops, err := epochRewardsOperations([]types.Log{
+			celoMint(addresses.Reserve, 200),
+			celoMint(addresses.LockedGold, 1000),
+			celoMint(common.HexToAddress("0x99"), 1),
+		}, addresses, votersRewarded, reserveBacking)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ops).Should(ConsistOf(
+			matchOp(OpEpochVoterRewards, "", MatchBalanceChange(addresses.LockedGold, big.NewInt(1000), AccMain)),
+			matchOp(OpEpochCommunityFund, "", MatchBalanceChange(addresses.Reserve, big.NewInt(200), AccMain)),
+			matchOp(OpEpochRewards, "", MatchBalanceChange(common.HexToAddress("0x99"), big.NewInt(1), AccMain)),
+		))
+	})
+}
+
+func TestActiveVotesReward(t *testing.T) {
+	RegisterTestingT(t)
+
+	// 3 voters with 1, 2 and 4 of the 7 units of a group with 700 CELO of active votes, rewarded with 70 CELO
+	totalUnits, totalVotes, reward := big.NewInt(7), big.NewInt(770), big.NewInt(70)
+	Ω(ActiveVotesValue(big.NewInt(2), totalUnits, totalVotes).Int64()).Should(Equal(int64(220)))
+
+	sum := big.NewInt(0)
+	for _, units := range []int64{1, 2, 4} {
+		voterReward := ActiveVotesReward(big.NewInt(units), totalUnits, totalVotes, reward)
+		Ω(voterReward.Int64()).Should(Equal(10 * units))
+		sum.Add(sum, voterReward)
+	}
+	Ω(sum.Cmp(reward)).Should(BeZero())
+
+	// Rounded down as the Election contract does
+	Ω(ActiveVotesReward(big.NewInt(1), big.NewInt(3), big.NewInt(11), big.NewInt(1)).Sign()).Should(BeZero())
+	Ω(ActiveVotesValue(big.NewInt(1), big.NewInt(0), big.NewInt(0)).Sign()).Should(BeZero())
+}
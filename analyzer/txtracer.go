@@ -15,16 +15,21 @@
 package analyzer
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"math/big"
 	"strings"
 	"time"
 
+	"github.com/celo-org/celo-blockchain/accounts/abi/bind"
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/eth/tracers"
 	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/kliento/celotokens"
 	"github.com/celo-org/kliento/client"
 	"github.com/celo-org/kliento/client/debug"
 	"github.com/celo-org/kliento/contracts"
@@ -40,6 +45,8 @@ type Tracer struct {
 	logger       log.Logger
 	traceTimeout time.Duration
 	gingerbread  bool
+	// blockTransfers is the result of a block trace, if available (see TraceBlockTransfers)
+	blockTransfers BlockTransfers
 }
 
 func NewTracer(ctx context.Context, cc *client.CeloClient, db db.RosettaDBReader, traceTimeout time.Duration, gingerbread bool) *Tracer {
@@ -66,16 +73,16 @@ func (tr *Tracer) GetRegistryAddresses(receipt *types.Receipt, contractNames ...
 func (tr *Tracer) TraceTransaction(blockHeader *types.Header, tx *types.Transaction, receipt *types.Receipt) ([]Operation, error) {
 	ops := make([]Operation, 0)
 
-	if tx.FeeCurrency() == nil { // nil implies cGLD
-		gasOp, err := tr.TxGasDetails(blockHeader, tx, receipt)
-		if err != nil {
-			return nil, err
-		}
+	gasOp, err := tr.TxGasDetails(blockHeader, tx, receipt)
+	if err != nil {
+		return nil, err
+	}
+	if gasOp != nil {
 		ops = append(ops, *gasOp)
 	}
 
 	if receipt.Status == types.ReceiptStatusSuccessful {
-		contractMap, err := tr.GetRegistryAddresses(receipt, registry.ReserveContractID.String(), registry.LockedGoldContractID.String(), registry.ElectionContractID.String(), registry.GovernanceContractID.String(), registry.AccountsContractID.String())
+		contractMap, err := tr.GetRegistryAddresses(receipt, registry.ReserveContractID.String(), registry.LockedGoldContractID.String(), registry.ElectionContractID.String(), registry.GovernanceContractID.String(), registry.AccountsContractID.String(), registry.ValidatorsContractID.String())
 		if err != nil {
 			return nil, err
 		}
@@ -94,48 +101,241 @@ func (tr *Tracer) TraceTransaction(blockHeader *types.Header, tx *types.Transact
 		if err != nil {
 			return nil, err
 		}
+		if governanceAddr, ok := contractMap[registry.GovernanceContractID.String()]; ok && isWithdrawDepositCall(tx, governanceAddr) {
+			ClassifyDepositWithdrawal(reconciledOps, governanceAddr)
+		}
 
 		ops = append(ops, reconciledOps...)
+
+		stableTokenOps, err := tr.TxStableTokenTransfers(receipt)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, stableTokenOps...)
+
+		if tr.gingerbread {
+			if err := tr.classifyFeeHandlerTransfers(receipt, ops); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return ops, nil
 }
 
+// classifyFeeHandlerTransfers types the FeeHandler's burns and distributions of the base fees among ops
+func (tr *Tracer) classifyFeeHandlerTransfers(receipt *types.Receipt, ops []Operation) error {
+	feeHandlerAddr, err := tr.db.RegistryAddressStartOf(tr.ctx, receipt.BlockNumber, receipt.TransactionIndex, registry.FeeHandlerContractID.String())
+	if err == db.ErrContractNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("can't get FeeHandler address: %w", err)
+	}
+
+	paysOut := false
+	for _, op := range ops {
+		if op.Type == OpTransfer && op.Successful && len(op.Changes) == 2 && op.Changes[0].Account.Address == feeHandlerAddr {
+			paysOut = true
+			break
+		}
+	}
+	if !paysOut {
+		return nil
+	}
+
+	feeHandler, err := contracts.NewFeeHandler(feeHandlerAddr, tr.cc.Eth)
+	if err != nil {
+		return fmt.Errorf("can't initialize FeeHandler contract: %w", err)
+	}
+	beneficiary, err := feeHandler.FeeBeneficiary(&bind.CallOpts{BlockNumber: receipt.BlockNumber, Context: tr.ctx})
+	if err != nil {
+		return fmt.Errorf("can't get FeeHandler beneficiary: %w", err)
+	}
+
+	ClassifyFeeHandlerTransfers(ops, feeHandlerAddr, beneficiary)
+	return nil
+}
+
+// withdrawDepositMethodID is the selector of Governance.withdraw(), which emits no event
+var withdrawDepositMethodID = crypto.Keccak256([]byte("withdraw()"))[:4]
+
+// isWithdrawDepositCall reports whether tx calls withdraw() on the Governance contract. Withdrawals by
+// contracts calling Governance are not detected, their transfer stays an OpTransfer.
+func isWithdrawDepositCall(tx *types.Transaction, governanceAddr common.Address) bool {
+	return tx.To() != nil && *tx.To() == governanceAddr && bytes.Equal(tx.Data(), withdrawDepositMethodID)
+}
+
+// TxGasDetails returns the fee operation of a tx, in the currency the fee was paid with.
+// It returns nil if the fee currency is not a supported stable token.
 func (tr *Tracer) TxGasDetails(blockHeader *types.Header, tx *types.Transaction, receipt *types.Receipt) (*Operation, error) {
-	balanceChanges := NewBalanceSet()
+	gpm, feeHandler, currency, err := tr.baseFeeParams(blockHeader, receipt.BlockNumber, tx.FeeCurrency())
+	if err != nil || gpm == nil {
+		return nil, err
+	}
+
+	// TODO find a better way to do this?
+	from, err := tr.cc.Eth.TransactionSender(tr.ctx, tx, receipt.BlockHash, receipt.TransactionIndex)
+	if err != nil {
+		return nil, fmt.Errorf("can't get transaction sender: %w", err)
+	}
 
-	var gpm *big.Int
-	var feeHandler string
+	// We want to get state AFTER the tx, since gas fees are processed by the end of the TX
+	return tr.gasDetails(tx, from, &blockHeader.Coinbase, gpm, receipt.GasUsed, receipt.BlockNumber, receipt.TransactionIndex+1, feeHandler, currency)
+}
 
+// PendingTxGasDetails predicts the fee operation of a mempool transaction, assuming
+// it is included in the block after lastHeader and consumes its whole gas limit.
+// The proposer of that block isn't known yet, so the tip is not credited to anyone.
+func (tr *Tracer) PendingTxGasDetails(lastHeader *types.Header, tx *types.Transaction, from common.Address) (*Operation, error) {
+	gpm, feeHandler, currency, err := tr.baseFeeParams(lastHeader, utils.Inc(lastHeader.Number), tx.FeeCurrency())
+	if err != nil || gpm == nil {
+		return nil, err
+	}
+	return tr.gasDetails(tx, from, nil, gpm, tx.Gas(), lastHeader.Number, endOfBlockTxIndex, feeHandler, currency)
+}
+
+// endOfBlockTxIndex is used to query registry state after every tx of a block
+const endOfBlockTxIndex = math.MaxInt32
+
+// baseFeeParams returns the gas price minimum FOR blockNumber in the given fee currency (nil means CELO),
+// the registry id of the contract that receives the base fee and the token of the fee currency.
+// The gas price minimum is nil if the fee currency is not a supported stable token.
+func (tr *Tracer) baseFeeParams(blockHeader *types.Header, blockNumber *big.Int, feeCurrency *common.Address) (*big.Int, string, celotokens.CeloToken, error) {
+	feeHandler := registry.GovernanceContractID.String()
 	if tr.gingerbread {
-		// BaseFee is used directly because we only track balance changes from CELO gas fees
-		gpm = blockHeader.BaseFee
 		feeHandler = registry.FeeHandlerContractID.String()
-	} else {
-		var err error
-		gpm, err = tr.db.GasPriceMinimumFor(tr.ctx, receipt.BlockNumber)
+	}
+
+	if feeCurrency != nil {
+		token, ok, err := tr.stableTokenFor(*feeCurrency, blockNumber)
+		if err != nil || !ok {
+			return nil, "", "", err
+		}
+		gpm, err := tr.feeCurrencyBaseFee(blockHeader, blockNumber, *feeCurrency)
 		if err != nil {
-			return nil, fmt.Errorf("can't get gasPriceMinimun: %w", err)
+			return nil, "", "", err
 		}
-		feeHandler = registry.GovernanceContractID.String()
+		return gpm, feeHandler, token, nil
+	}
+
+	if tr.gingerbread {
+		// BaseFee is used directly because we only track balance changes from CELO gas fees
+		return blockHeader.BaseFee, feeHandler, "", nil
 	}
 
-	gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
-	baseTxFee := new(big.Int).Mul(gpm, gasUsed)
+	gpm, err := tr.db.GasPriceMinimumFor(tr.ctx, blockNumber)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("can't get gasPriceMinimun: %w", err)
+	}
+	return gpm, feeHandler, "", nil
+}
+
+// stableTokenFor finds which stable token is deployed at feeCurrency by the start of blockNumber
+func (tr *Tracer) stableTokenFor(feeCurrency common.Address, blockNumber *big.Int) (celotokens.CeloToken, bool, error) {
+	for _, token := range StableTokens {
+		registryID, err := celotokens.GetRegistryID(token)
+		if err != nil {
+			return "", false, err
+		}
+		addr, err := tr.db.RegistryAddressStartOf(tr.ctx, utils.Dec(blockNumber), endOfBlockTxIndex, registryID.String())
+		if err == db.ErrContractNotFound {
+			continue
+		} else if err != nil {
+			return "", false, fmt.Errorf("can't get %s address: %w", registryID, err)
+		}
+		if addr == feeCurrency {
+			return token, true, nil
+		}
+	}
+	tr.logger.Warn("Ignoring fee paid in unsupported currency", "feeCurrency", feeCurrency.Hex(), "block", blockNumber)
+	return "", false, nil
+}
+
+// feeCurrencyBaseFee returns the base fee of blockNumber expressed in feeCurrency, using the state of the parent block.
+// Before Gingerbread it's the GasPriceMinimum for the currency (from the db when tracked), afterwards the header's
+// base fee converted with the oracles' median rate.
+func (tr *Tracer) feeCurrencyBaseFee(blockHeader *types.Header, blockNumber *big.Int, feeCurrency common.Address) (*big.Int, error) {
+	parent := utils.Dec(blockNumber)
+	opts := &bind.CallOpts{BlockNumber: parent, Context: tr.ctx}
+
+	if !tr.gingerbread {
+		gpm, err := tr.db.GasPriceMinimumForCurrency(tr.ctx, blockNumber, feeCurrency)
+		if err == nil {
+			return gpm, nil
+		} else if err != db.ErrGasPriceMinimumNotFound {
+			return nil, fmt.Errorf("can't get gasPriceMinimum for %s: %w", feeCurrency.Hex(), err)
+		}
+
+		// Not tracked by the monitor yet, ask the node
+		gpmAddress, err := tr.db.RegistryAddressStartOf(tr.ctx, parent, endOfBlockTxIndex, registry.GasPriceMinimumContractID.String())
+		if err != nil {
+			return nil, fmt.Errorf("can't get GasPriceMinimum address: %w", err)
+		}
+		gpmContract, err := contracts.NewGasPriceMinimum(gpmAddress, tr.cc.Eth)
+		if err != nil {
+			return nil, fmt.Errorf("can't initialize GasPriceMinimum contract: %w", err)
+		}
+		gpm, err = gpmContract.GetGasPriceMinimum(opts, feeCurrency)
+		if err != nil {
+			return nil, fmt.Errorf("can't get gasPriceMinimum for %s: %w", feeCurrency.Hex(), err)
+		}
+		return gpm, nil
+	}
+
+	sortedOraclesAddress, err := tr.db.RegistryAddressStartOf(tr.ctx, parent, endOfBlockTxIndex, registry.SortedOraclesContractID.String())
+	if err != nil {
+		return nil, fmt.Errorf("can't get SortedOracles address: %w", err)
+	}
+	sortedOracles, err := contracts.NewSortedOracles(sortedOraclesAddress, tr.cc.Eth)
+	if err != nil {
+		return nil, fmt.Errorf("can't initialize SortedOracles contract: %w", err)
+	}
+	numerator, denominator, err := sortedOracles.MedianRate(opts, feeCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("can't get medianRate for %s: %w", feeCurrency.Hex(), err)
+	}
+	return ConvertToCurrency(blockHeader.BaseFee, numerator, denominator)
+}
+
+// ConvertToCurrency converts a CELO amount with a rate of numerator/denominator currency units per CELO
+func ConvertToCurrency(celoAmount, numerator, denominator *big.Int) (*big.Int, error) {
+	if denominator == nil || denominator.Sign() == 0 {
+		return nil, fmt.Errorf("no exchange rate available")
+	}
+	converted := new(big.Int).Mul(celoAmount, numerator)
+	return converted.Div(converted, denominator), nil
+}
+
+// ConvertToCelo converts a currency amount with a rate of numerator/denominator currency units per CELO, as
+// celo-blockchain's ExchangeRate.ToBase does
+func ConvertToCelo(currencyAmount, numerator, denominator *big.Int) (*big.Int, error) {
+	if numerator == nil || numerator.Sign() == 0 {
+		return nil, fmt.Errorf("no exchange rate available")
+	}
+	converted := new(big.Int).Mul(currencyAmount, denominator)
+	return converted.Div(converted, numerator), nil
+}
+
+func (tr *Tracer) gasDetails(tx *types.Transaction, from common.Address, coinbase *common.Address, gpm *big.Int, gasUsed uint64, block *big.Int, txIndex uint, feeHandler string, currency celotokens.CeloToken) (*Operation, error) {
+	balanceChanges := NewBalanceSet()
+
+	gasUsedBig := new(big.Int).SetUint64(gasUsed)
+	baseTxFee := new(big.Int).Mul(gpm, gasUsedBig)
 	effectiveTip, err := tx.EffectiveGasTip(gpm)
 	if err != nil {
 		return nil, fmt.Errorf("error computing EffectiveGasTip: %w", err)
 	}
 
 	// Convert tip to wei
-	effectiveTip.Mul(effectiveTip, gasUsed)
+	effectiveTip.Mul(effectiveTip, gasUsedBig)
 
 	runningTotalTxFee := new(big.Int).Set(effectiveTip)
 	// The "tip" goes to the coinbase address
-	balanceChanges.Add(blockHeader.Coinbase, effectiveTip)
+	if coinbase != nil {
+		balanceChanges.Add(*coinbase, effectiveTip)
+	}
 
-	// We want to get state AFTER the tx, since gas fees are processed by the end of the TX
-	feeHandlerAddress, err := tr.db.RegistryAddressStartOf(tr.ctx, receipt.BlockNumber, receipt.TransactionIndex+1, feeHandler)
+	feeHandlerAddress, err := tr.db.RegistryAddressStartOf(tr.ctx, block, txIndex, feeHandler)
 	if err == nil {
 		// User is charged baseFee iff community fund exists
 		balanceChanges.Add(feeHandlerAddress, baseTxFee)
@@ -149,13 +349,10 @@ func (tr *Tracer) TxGasDetails(blockHeader *types.Header, tx *types.Transaction,
 		runningTotalTxFee.Add(runningTotalTxFee, tx.GatewayFee())
 	}
 
-	// TODO find a better way to do this?
-	from, err := tr.cc.Eth.TransactionSender(tr.ctx, tx, receipt.BlockHash, receipt.TransactionIndex)
-	if err != nil {
-		return nil, fmt.Errorf("can't get transaction sender: %w", err)
-	}
 	balanceChanges.Add(from, new(big.Int).Neg(runningTotalTxFee))
-	return NewFee(balanceChanges.ToMap()), nil
+	op := NewFee(balanceChanges.ToMap())
+	op.Currency = currency
+	return op, nil
 }
 
 func (tr *Tracer) TxTransfers(tx *types.Transaction, receipt *types.Receipt) ([]Operation, error) {
@@ -163,6 +360,10 @@ func (tr *Tracer) TxTransfers(tx *types.Transaction, receipt *types.Receipt) ([]
 		return nil, nil
 	}
 
+	if ops, ok := tr.blockTransfers[tx.Hash()]; ok {
+		return ops, nil
+	}
+
 	res := debug.TransferTracerResponse{}
 	timeout := tr.traceTimeout.String()
 	cfg := &tracers.TraceConfig{Tracer: &debug.TransferTracer, Timeout: &timeout}
@@ -173,6 +374,74 @@ func (tr *Tracer) TxTransfers(tx *types.Transaction, receipt *types.Receipt) ([]
 	return InternalTransfersToOperations(res.Transfers), nil
 }
 
+// StableTokens are the tokens whose ERC-20 transfers are reported as operations
+var StableTokens = []celotokens.CeloToken{celotokens.CUSD, celotokens.CEUR, celotokens.CREAL}
+
+// TxStableTokenTransfers creates an operation for each stable token Transfer event of the tx
+func (tr *Tracer) TxStableTokenTransfers(receipt *types.Receipt) ([]Operation, error) {
+	if receipt.Status == types.ReceiptStatusFailed {
+		return nil, nil
+	}
+
+	contractNames := make([]string, len(StableTokens))
+	for i, token := range StableTokens {
+		registryID, err := celotokens.GetRegistryID(token)
+		if err != nil {
+			return nil, err
+		}
+		contractNames[i] = registryID.String()
+	}
+
+	contractMap, err := tr.GetRegistryAddresses(receipt, contractNames...)
+	if err != nil {
+		return nil, err
+	}
+
+	tokensByAddress := make(map[common.Address]celotokens.CeloToken, len(contractMap))
+	for i, token := range StableTokens {
+		if addr, ok := contractMap[contractNames[i]]; ok {
+			tokensByAddress[addr] = token
+		}
+	}
+	if len(tokensByAddress) == 0 {
+		// Stable tokens not deployed => no transfers
+		return nil, nil
+	}
+
+	// Any StableToken binding can parse the logs, they all share the ERC-20 events
+	stableToken, err := contracts.NewStableToken(common.ZeroAddress, tr.cc.Eth)
+	if err != nil {
+		return nil, fmt.Errorf("can't initialize StableToken contract: %w", err)
+	}
+
+	ops := make([]Operation, 0)
+	for _, eventLog := range utils.RemoveProxyLogs(receipt.Logs) {
+		token, ok := tokensByAddress[eventLog.Address]
+		if !ok {
+			continue
+		}
+
+		eventName, eventRaw, ok, err := stableToken.TryParseLog(*eventLog)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "no event with id") {
+				tr.logger.Warn("Ignoring unknown StableToken event: %w", err)
+				continue
+			} else {
+				return nil, fmt.Errorf("can't parse StableToken event: %w", err)
+			}
+		}
+		if !ok || eventName != "Transfer" {
+			continue
+		}
+
+		event := eventRaw.(*contracts.StableTokenTransfer)
+		if event.Value.Sign() > 0 && event.From != event.To {
+			ops = append(ops, *NewStableTokenTransfer(token, event.From, event.To, event.Value))
+		}
+	}
+	return ops, nil
+}
+
 func (tr *Tracer) TxOpsFromLogs(tx *types.Transaction, receipt *types.Receipt, contractMap map[string]common.Address) ([]Operation, error) {
 	if receipt.Status == types.ReceiptStatusFailed {
 		return nil, nil
@@ -208,8 +477,30 @@ func (tr *Tracer) TxOpsFromLogs(tx *types.Transaction, receipt *types.Receipt, c
 		return nil, fmt.Errorf("can't initialize Election contract: %w", err)
 	}
 
-	// We only need governace for slashing and you can't slash if there's no governance contract, so we ignore if not found
-	governanceAddr, _ := contractMap[registry.GovernanceContractID.String()]
+	// We only need governace for slashing and proposals, which can't happen if there's no governance contract, so we ignore if not found
+	var governance *contracts.Governance
+	governanceAddr, ok := contractMap[registry.GovernanceContractID.String()]
+	if ok {
+		if governance, err = contracts.NewGovernance(governanceAddr, tr.cc.Eth); err != nil {
+			return nil, fmt.Errorf("can't initialize Governance contract: %w", err)
+		}
+	}
+
+	// Validators is optional too, its operations don't move any CELO
+	var validators *contracts.Validators
+	validatorsAddr, ok := contractMap[registry.ValidatorsContractID.String()]
+	if ok {
+		if validators, err = contracts.NewValidators(validatorsAddr, tr.cc.Eth); err != nil {
+			return nil, fmt.Errorf("can't initialize Validators contract: %w", err)
+		}
+	}
+	lockedGoldRequirement := func(account common.Address) (*big.Int, error) {
+		requirement, err := validators.GetAccountLockedGoldRequirement(&bind.CallOpts{BlockNumber: receipt.BlockNumber, Context: tr.ctx}, account)
+		if err != nil {
+			return nil, fmt.Errorf("can't get locked gold requirement of %s: %w", account.Hex(), err)
+		}
+		return requirement, nil
+	}
 
 	logs := utils.RemoveProxyLogs(receipt.Logs)
 
@@ -331,6 +622,138 @@ func (tr *Tracer) TxOpsFromLogs(tx *types.Transaction, receipt *types.Receipt, c
 				transfers = append(transfers, *NewSlash(event.Slashed, event.Reporter, governanceAddr, lockedGoldAddr, event.Penalty, event.Reward))
 
 			}
+
+		} else if governance != nil && eventLog.Address == governanceAddr {
+			eventName, eventRaw, ok, err := governance.TryParseLog(*eventLog)
+			if err != nil {
+				if strings.HasPrefix(err.Error(), "no event with id") {
+					tr.logger.Warn("Ignoring unknown Governance event", "err", err)
+					continue
+				} else {
+					return nil, fmt.Errorf("can't parse Governance event: %w", err)
+				}
+			}
+			if !ok {
+				continue
+			}
+
+			// Governance:
+
+			switch eventName {
+			case "ProposalQueued":
+				// propose() [ProposalQueued + transfer] => proposer:main->governance:main
+				event := eventRaw.(*contracts.GovernanceProposalQueued)
+				// Edge case: without a deposit there isn't a matching transfer
+				if event.Deposit.Sign() > 0 {
+					transfers = append(transfers, *NewPropose(event.Proposer, governanceAddr, event.ProposalId, event.Deposit))
+				}
+			case "ProposalDequeued":
+				// dequeueProposalsIfReady() [ProposalDequeued] => proposer:governanceRefundedDeposit
+				event := eventRaw.(*contracts.GovernanceProposalDequeued)
+				proposer, deposit, _, _, _, _, _, err := governance.GetProposal(&bind.CallOpts{BlockNumber: receipt.BlockNumber, Context: tr.ctx}, event.ProposalId)
+				if err != nil {
+					return nil, fmt.Errorf("can't get proposal %s: %w", event.ProposalId, err)
+				}
+				if proposer == common.ZeroAddress {
+					tr.logger.Warn("Ignoring refund of a proposal deleted in the same block", "proposalId", event.ProposalId, "block", receipt.BlockNumber)
+					continue
+				}
+				if deposit.Sign() > 0 {
+					transfers = append(transfers, *NewRefundDeposit(proposer, event.ProposalId, deposit))
+				}
+			case "ProposalUpvoted":
+				event := eventRaw.(*contracts.GovernanceProposalUpvoted)
+				transfers = append(transfers, *NewUpvoteProposal(event.Account, event.ProposalId, event.Upvotes))
+			case "ProposalVoted":
+				// Before votes could be split, the whole weight votes one value: 1 Abstain, 2 No, 3 Yes
+				event := eventRaw.(*contracts.GovernanceProposalVoted)
+				votes := []*big.Int{big.NewInt(0), big.NewInt(0), big.NewInt(0)}
+				if value := event.Value.Int64(); value >= 1 && value <= 3 {
+					votes[3-value] = event.Weight
+				}
+				transfers = append(transfers, *NewVoteProposal(event.Account, event.ProposalId, votes[0], votes[1], votes[2]))
+			case "ProposalVotedV2":
+				event := eventRaw.(*contracts.GovernanceProposalVotedV2)
+				transfers = append(transfers, *NewVoteProposal(event.Account, event.ProposalId, event.YesVotes, event.NoVotes, event.AbstainVotes))
+			}
+
+		} else if validators != nil && eventLog.Address == validatorsAddr {
+			eventName, eventRaw, ok, err := validators.TryParseLog(*eventLog)
+			if err != nil {
+				if strings.HasPrefix(err.Error(), "no event with id") {
+					tr.logger.Warn("Ignoring unknown Validators event", "err", err)
+					continue
+				} else {
+					return nil, fmt.Errorf("can't parse Validators event: %w", err)
+				}
+			}
+			if !ok {
+				continue
+			}
+
+			// Validators:
+
+			switch eventName {
+			case "ValidatorRegistered":
+				event := eventRaw.(*contracts.ValidatorsValidatorRegistered)
+				requirement, err := lockedGoldRequirement(event.Validator)
+				if err != nil {
+					return nil, err
+				}
+				transfers = append(transfers, *NewRegistration(OpRegisterValidator, event.Validator, requirement))
+			case "ValidatorDeregistered":
+				event := eventRaw.(*contracts.ValidatorsValidatorDeregistered)
+				requirement, err := lockedGoldRequirement(event.Validator)
+				if err != nil {
+					return nil, err
+				}
+				transfers = append(transfers, *NewRegistration(OpDeregisterValidator, event.Validator, requirement))
+			case "ValidatorGroupRegistered":
+				event := eventRaw.(*contracts.ValidatorsValidatorGroupRegistered)
+				requirement, err := lockedGoldRequirement(event.Group)
+				if err != nil {
+					return nil, err
+				}
+				op := NewRegistration(OpRegisterValidatorGroup, event.Group, requirement)
+				op.Metadata["commission"] = event.Commission.String()
+				transfers = append(transfers, *op)
+			case "ValidatorGroupDeregistered":
+				event := eventRaw.(*contracts.ValidatorsValidatorGroupDeregistered)
+				requirement, err := lockedGoldRequirement(event.Group)
+				if err != nil {
+					return nil, err
+				}
+				transfers = append(transfers, *NewRegistration(OpDeregisterValidatorGroup, event.Group, requirement))
+
+			case "ValidatorAffiliated":
+				event := eventRaw.(*contracts.ValidatorsValidatorAffiliated)
+				transfers = append(transfers, *NewAffiliation(OpAffiliate, event.Validator, event.Group))
+			case "ValidatorDeaffiliated":
+				event := eventRaw.(*contracts.ValidatorsValidatorDeaffiliated)
+				transfers = append(transfers, *NewAffiliation(OpDeaffiliate, event.Validator, event.Group))
+
+			case "ValidatorGroupMemberAdded":
+				event := eventRaw.(*contracts.ValidatorsValidatorGroupMemberAdded)
+				requirement, err := lockedGoldRequirement(event.Group)
+				if err != nil {
+					return nil, err
+				}
+				transfers = append(transfers, *NewMembership(OpAddMember, event.Group, event.Validator, requirement))
+			case "ValidatorGroupMemberRemoved":
+				event := eventRaw.(*contracts.ValidatorsValidatorGroupMemberRemoved)
+				requirement, err := lockedGoldRequirement(event.Group)
+				if err != nil {
+					return nil, err
+				}
+				transfers = append(transfers, *NewMembership(OpRemoveMember, event.Group, event.Validator, requirement))
+
+			case "ValidatorGroupCommissionUpdateQueued":
+				event := eventRaw.(*contracts.ValidatorsValidatorGroupCommissionUpdateQueued)
+				transfers = append(transfers, *NewCommissionUpdate(OpQueueCommissionUpdate, event.Group, event.Commission, event.ActivationBlock))
+			case "ValidatorGroupCommissionUpdated":
+				event := eventRaw.(*contracts.ValidatorsValidatorGroupCommissionUpdated)
+				transfers = append(transfers, *NewCommissionUpdate(OpUpdateCommission, event.Group, event.Commission, nil))
+			}
 		}
 
 	}
@@ -0,0 +1,301 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/celo-blockchain/rpc"
+	"github.com/celo-org/kliento/celotokens"
+	"github.com/celo-org/kliento/client"
+	"github.com/celo-org/kliento/registry"
+	"github.com/celo-org/rosetta/db"
+	. "github.com/onsi/gomega"
+)
+
+var (
+	cUSDAddress       = common.HexToAddress("0x765DE816845861e75A25fCA122bb6898B8B1282a")
+	cEURAddress       = common.HexToAddress("0xD8763CBa276a3738E6DE85b4b3bF5FDed6D6cA73")
+	governanceAddress = common.HexToAddress("0xD533Ca259b330c7A88f74E000a3FaEa2d63B7972")
+	gpmAddress        = common.HexToAddress("0xDfca3a8d7699D8bAfe656823AD60C17cb8270ECC")
+	oraclesAddress    = common.HexToAddress("0xefB84935239dAcdecF7c5bA76d8dE40b077B7b33")
+	feeHandlerAddress = common.HexToAddress("0xcD437749E43A154C07F3553504c68fBfD56B8778")
+)
+
+func newFeeCurrencyTracer(t *testing.T) *Tracer {
+	ctx := context.Background()
+	celoDb, err := db.NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	err = celoDb.ApplyChanges(ctx, &db.BlockChangeSet{
+		BlockNumber: big.NewInt(10),
+		RegistryChanges: []db.RegistryChange{
+			{TxIndex: 0, Contract: registry.GovernanceContractID.String(), NewAddress: governanceAddress},
+			{TxIndex: 1, Contract: registry.StableTokenContractID.String(), NewAddress: cUSDAddress},
+			{TxIndex: 2, Contract: registry.StableTokenEURContractID.String(), NewAddress: cEURAddress},
+			{TxIndex: 3, Contract: registry.GasPriceMinimumContractID.String(), NewAddress: gpmAddress},
+			{TxIndex: 4, Contract: registry.SortedOraclesContractID.String(), NewAddress: oraclesAddress},
+		},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return &Tracer{ctx: ctx, db: celoDb, logger: log.New()}
+}
+
+func TestStableTokenFor(t *testing.T) {
+	RegisterTestingT(t)
+	tr := newFeeCurrencyTracer(t)
+
+	token, ok, err := tr.stableTokenFor(cEURAddress, big.NewInt(11))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(ok).Should(BeTrue())
+	Ω(token).Should(Equal(celotokens.CEUR))
+
+	token, ok, err = tr.stableTokenFor(cUSDAddress, big.NewInt(11))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(ok).Should(BeTrue())
+	Ω(token).Should(Equal(celotokens.CUSD))
+
+	// Not registered yet at the start of block 10
+	_, ok, err = tr.stableTokenFor(cEURAddress, big.NewInt(10))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(ok).Should(BeFalse())
+
+	_, ok, err = tr.stableTokenFor(address1, big.NewInt(11))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(ok).Should(BeFalse())
+}
+
+// feeCurrencyFixture is a tx paying fees in a stable token, as returned by the node's JSON-RPC API, along with
+// the contract calls made at the parent block to find its base fee (see testdata/fee_currency_*.json)
+type feeCurrencyFixture struct {
+	Header      json.RawMessage `json:"header"`
+	Transaction json.RawMessage `json:"transaction"`
+	Receipt     json.RawMessage `json:"receipt"`
+	Calls       []fixtureCall   `json:"calls"`
+}
+
+type fixtureCall struct {
+	To     common.Address `json:"to"`
+	Data   hexutil.Bytes  `json:"data"`
+	Block  hexutil.Big    `json:"block"`
+	Result hexutil.Bytes  `json:"result"`
+}
+
+// fixtureNode serves a feeCurrencyFixture through the eth namespace
+type fixtureNode struct {
+	fixture feeCurrencyFixture
+	header  struct {
+		Number hexutil.Big `json:"number"`
+	}
+	tx struct {
+		Hash common.Hash `json:"hash"`
+	}
+}
+
+func (n *fixtureNode) GetBlockByNumber(number hexutil.Big, fullTxs bool) (json.RawMessage, error) {
+	if number.ToInt().Cmp(n.header.Number.ToInt()) != 0 {
+		return nil, nil
+	}
+	return n.fixture.Header, nil
+}
+
+func (n *fixtureNode) GetTransactionByHash(hash common.Hash) (json.RawMessage, error) {
+	if hash != n.tx.Hash {
+		return nil, nil
+	}
+	return n.fixture.Transaction, nil
+}
+
+func (n *fixtureNode) GetTransactionReceipt(hash common.Hash) (json.RawMessage, error) {
+	if hash != n.tx.Hash {
+		return nil, nil
+	}
+	return n.fixture.Receipt, nil
+}
+
+func (n *fixtureNode) Call(args struct {
+	To   common.Address `json:"to"`
+	Data hexutil.Bytes  `json:"data"`
+}, block hexutil.Big) (hexutil.Bytes, error) {
+	for _, call := range n.fixture.Calls {
+		if call.To == args.To && bytes.Equal(call.Data, args.Data) && call.Block.ToInt().Cmp(block.ToInt()) == 0 {
+			return call.Result, nil
+		}
+	}
+	return nil, fmt.Errorf("no call to %s with data %s at block %d in the fixture", args.To.Hex(), args.Data, block.ToInt())
+}
+
+func newFixtureNode(t *testing.T, name string) *fixtureNode {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name+".json"))
+	Ω(err).ShouldNot(HaveOccurred())
+
+	node := &fixtureNode{}
+	Ω(json.Unmarshal(data, &node.fixture)).Should(Succeed())
+	Ω(json.Unmarshal(node.fixture.Header, &node.header)).Should(Succeed())
+	Ω(json.Unmarshal(node.fixture.Transaction, &node.tx)).Should(Succeed())
+	return node
+}
+
+func TestFeeCurrencyGasDetails(t *testing.T) {
+	sender1 := common.HexToAddress("0x2c7536E3605D9C16a7a3D7b1898e529396a65c23")
+	sender2 := common.HexToAddress("0xFE3B557E8Fb62b89F4916B721be55cEb828dBd73")
+	coinbase := common.HexToAddress("0xE1C6E4D6E5e9f6Cf0F8DC5B5bCe1E9ebd1C1aE27")
+
+	tests := []struct {
+		name        string
+		fixture     string
+		gingerbread bool
+		currency    celotokens.CeloToken
+		from        common.Address
+		feeHandler  common.Address
+		tip         *big.Int
+		baseFee     *big.Int
+	}{
+		{
+			// Legacy tx, gpm of 250000000 tracked in the db
+			name:       "cUSD before Gingerbread",
+			fixture:    "fee_currency_cusd_pre_gingerbread",
+			currency:   celotokens.CUSD,
+			from:       sender1,
+			feeHandler: governanceAddress,
+			tip:        big.NewInt(13500000000000),
+			baseFee:    big.NewInt(13500000000000),
+		},
+		{
+			// Legacy tx, gpm of 450000000 not tracked in the db, asked to GasPriceMinimum
+			name:       "cEUR before Gingerbread",
+			fixture:    "fee_currency_ceur_pre_gingerbread",
+			currency:   celotokens.CEUR,
+			from:       sender2,
+			feeHandler: governanceAddress,
+			tip:        big.NewInt(7159950000000),
+			baseFee:    big.NewInt(21479850000000),
+		},
+		{
+			// CeloDynamicFeeTx, base fee of 5 gwei at 0.45 cEUR per CELO
+			name:        "cEUR after Gingerbread",
+			fixture:     "fee_currency_ceur_gingerbread",
+			gingerbread: true,
+			currency:    celotokens.CEUR,
+			from:        sender2,
+			feeHandler:  feeHandlerAddress,
+			tip:         big.NewInt(23866500000000),
+			baseFee:     big.NewInt(107399250000000),
+		},
+		{
+			// CeloDynamicFeeTxV2, base fee of 5 gwei at 0.52 cUSD per CELO, tip capped by maxFeePerGas
+			name:        "cUSD after Gingerbread",
+			fixture:     "fee_currency_cusd_gingerbread",
+			gingerbread: true,
+			currency:    celotokens.CUSD,
+			from:        sender1,
+			feeHandler:  feeHandlerAddress,
+			tip:         big.NewInt(75600000000000),
+			baseFee:     big.NewInt(140400000000000),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RegisterTestingT(t)
+			tr := newFeeCurrencyTracer(t)
+			tr.gingerbread = tt.gingerbread
+
+			celoDb := tr.db.(db.RosettaDB)
+			Ω(celoDb.ApplyChanges(tr.ctx, &db.BlockChangeSet{
+				BlockNumber:              big.NewInt(14999999),
+				CurrencyGasPriceMinimums: map[common.Address]*big.Int{cUSDAddress: big.NewInt(250000000)},
+			})).Should(Succeed())
+			Ω(celoDb.ApplyChanges(tr.ctx, &db.BlockChangeSet{
+				BlockNumber:     big.NewInt(21616000),
+				RegistryChanges: []db.RegistryChange{{TxIndex: 0, Contract: registry.FeeHandlerContractID.String(), NewAddress: feeHandlerAddress}},
+			})).Should(Succeed())
+			Ω(celoDb.ApplyChanges(tr.ctx, &db.BlockChangeSet{BlockNumber: big.NewInt(21700000)})).Should(Succeed())
+
+			node := newFixtureNode(t, tt.fixture)
+			server := rpc.NewServer()
+			defer server.Stop()
+			Ω(server.RegisterName("eth", node)).Should(Succeed())
+			tr.cc = client.NewCeloClient(rpc.DialInProc(server))
+			defer tr.cc.Close()
+
+			tx, _, err := tr.cc.Eth.TransactionByHash(tr.ctx, node.tx.Hash)
+			Ω(err).ShouldNot(HaveOccurred())
+			receipt, err := tr.cc.Eth.TransactionReceipt(tr.ctx, node.tx.Hash)
+			Ω(err).ShouldNot(HaveOccurred())
+			header, err := tr.cc.Eth.HeaderByNumber(tr.ctx, receipt.BlockNumber)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			op, err := tr.TxGasDetails(header, tx, receipt)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(op.Type).Should(Equal(OpFee))
+			Ω(op.Currency).Should(Equal(tt.currency))
+			Ω(op.Changes).Should(HaveLen(3))
+			Ω(op.Changes).Should(ContainElement(BalanceChange{Account: NewAccount(coinbase, AccMain), Amount: tt.tip}))
+			Ω(op.Changes).Should(ContainElement(BalanceChange{Account: NewAccount(tt.feeHandler, AccMain), Amount: tt.baseFee}))
+			total := new(big.Int).Add(tt.tip, tt.baseFee)
+			Ω(op.Changes).Should(ContainElement(BalanceChange{Account: NewAccount(tt.from, AccMain), Amount: total.Neg(total)}))
+		})
+	}
+}
+
+func TestConvertToCurrency(t *testing.T) {
+	RegisterTestingT(t)
+
+	converted, err := ConvertToCurrency(big.NewInt(1000), big.NewInt(3), big.NewInt(2))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(converted).Should(Equal(big.NewInt(1500)))
+
+	_, err = ConvertToCurrency(big.NewInt(1000), big.NewInt(3), big.NewInt(0))
+	Ω(err).Should(HaveOccurred())
+}
+
+func TestConvertToCelo(t *testing.T) {
+	RegisterTestingT(t)
+
+	converted, err := ConvertToCelo(big.NewInt(1000), big.NewInt(3), big.NewInt(2))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(converted).Should(Equal(big.NewInt(666)))
+
+	_, err = ConvertToCelo(big.NewInt(1000), big.NewInt(0), big.NewInt(2))
+	Ω(err).Should(HaveOccurred())
+}
+
+func TestFeeCurrencyBaseFeeFromDb(t *testing.T) {
+	RegisterTestingT(t)
+	tr := newFeeCurrencyTracer(t)
+
+	err := tr.db.(db.RosettaDB).ApplyChanges(tr.ctx, &db.BlockChangeSet{
+		BlockNumber:              big.NewInt(11),
+		CurrencyGasPriceMinimums: map[common.Address]*big.Int{cEURAddress: big.NewInt(450000000)},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	gpm, err := tr.feeCurrencyBaseFee(nil, big.NewInt(12), cEURAddress)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(gpm).Should(Equal(big.NewInt(450000000)))
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2020 Celo Org
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/kliento/client"
+	"github.com/celo-org/rosetta/analyzer"
+	"github.com/celo-org/rosetta/cmd/internal/utils"
+	"github.com/celo-org/rosetta/db"
+	"github.com/celo-org/rosetta/internal/signals"
+	"github.com/celo-org/rosetta/service"
+	"github.com/celo-org/rosetta/service/geth"
+	"github.com/celo-org/rosetta/service/monitor"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// backfillCmd represents the backfill command
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Store the operations of already synced blocks in rosetta.db",
+	Long: `Traces the blocks in the given range and stores their operations in rosetta.db, skipping the blocks already stored
+by this version (the operations stored by previous versions are replaced).
+Requires a running rosetta (or celo node) on the same datadir, and the range to be already synced by the monitor.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		viper.SetEnvPrefix("ROSETTA")
+		viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+		viper.AutomaticEnv()
+
+		return viper.BindPFlags(cmd.Flags())
+	},
+	Run: runBackfillCmd,
+}
+
+func init() {
+	flagSet := backfillCmd.Flags()
+
+	flagSet.String("datadir", "", "datadir to use")
+	utils.ExitOnError(backfillCmd.MarkFlagDirname("datadir"))
+
+	flagSet.String("geth.ipcpath", "geth.ipc", "Path to the geth ipc file. Under <datadir>/celo if path is relative")
+	flagSet.String("geth.genesis", "", "(Optional) path to the genesis.json, for use with custom chains")
+	flagSet.String("geth.network", "", "Network to use, either 'mainnet', 'alfajores', or 'baklava'")
+
+	flagSet.Uint64("backfill.from", 1, "First block to backfill")
+	flagSet.Uint64("backfill.to", 0, "Last block to backfill (default last block synced by the monitor)")
+	flagSet.Uint("backfill.workers", 4, "Number of blocks traced concurrently")
+	flagSet.Duration("backfill.traceTimeout", 120*time.Second, "Timeout sent to the blockchain node for trace requests")
+}
+
+func runBackfillCmd(cmd *cobra.Command, args []string) {
+	datadir := getDatadir(cmd)
+
+	chainParams, err := geth.ChainParametersFor(viper.GetString("geth.network"), viper.GetString("geth.genesis"))
+	if err != nil {
+		printUsageAndExit(cmd, err.Error())
+	}
+
+	if viper.GetUint("backfill.workers") < 1 {
+		printUsageAndExit(cmd, "'backfill.workers' must be at least 1")
+	}
+
+	gethOpts := geth.GethOpts{Datadir: filepath.Join(datadir, "celo"), IpcPath: viper.GetString("geth.ipcpath")}
+	cc, err := client.Dial(gethOpts.IpcFile())
+	utils.ExitOnError(err)
+
+	celoStore, err := db.NewSqliteDb(filepath.Join(datadir, "rosetta.db"))
+	utils.ExitOnError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-signals.WatchForExitSignals()
+		cancel()
+	}()
+
+	from := new(big.Int).SetUint64(viper.GetUint64("backfill.from"))
+	to := new(big.Int).SetUint64(viper.GetUint64("backfill.to"))
+	lastPersisted, err := celoStore.LastPersistedBlock(ctx)
+	utils.ExitOnError(err)
+	if to.Sign() == 0 || to.Cmp(lastPersisted) > 0 {
+		to = lastPersisted
+	}
+
+	log.Info("Backfilling operations", "from", from, "to", to)
+	utils.ExitOnError(backfill(ctx, cc, celoStore, chainParams, from, to))
+	log.Info("Backfill finished", "from", from, "to", to)
+}
+
+func backfill(ctx context.Context, cc *client.CeloClient, celoStore db.RosettaDB, chainParams *service.ChainParameters, from, to *big.Int) error {
+	group, ctx := errgroup.WithContext(ctx)
+	group.SetLimit(int(viper.GetUint("backfill.workers")))
+	traceTimeout := viper.GetDuration("backfill.traceTimeout")
+
+	for block := new(big.Int).Set(from); block.Cmp(to) <= 0; block = new(big.Int).Add(block, big.NewInt(1)) {
+		if _, err := celoStore.IndexedBlockHash(ctx, block, analyzer.OperationsVersion); err == nil {
+			continue
+		} else if err != db.ErrOperationsNotFound {
+			return err
+		}
+
+		block := block
+		group.Go(func() error {
+			if err := monitor.IndexBlockOperations(ctx, cc, celoStore, chainParams, traceTimeout, block); err != nil {
+				return fmt.Errorf("can't backfill block %s: %w", block, err)
+			}
+			if block.Uint64()%1000 == 0 {
+				log.Info("Backfilled block", "block", block)
+			}
+			return nil
+		})
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return group.Wait()
+}
@@ -126,7 +126,11 @@ func printBlockContext(rosettabBlock *types.Block) {
 				amount = op.Amount.Value
 				currency = op.Amount.Currency.Symbol
 			}
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", acc, amount, op.Type, currency, op.Status)
+			status := ""
+			if op.Status != nil {
+				status = *op.Status
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", acc, amount, op.Type, currency, status)
 		}
 		w.Flush()
 	}
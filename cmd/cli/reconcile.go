@@ -57,7 +57,7 @@ func runReconciler(cmd *cobra.Command, args []string) {
 	fetcher, network, _ := getFetcher()
 
 	getBalance := func(acc *types.AccountIdentifier, block *types.BlockIdentifier) (*big.Int, error) {
-		_, amounts, _, _, fetcherErr := fetcher.AccountBalance(ctx, network, acc, types.ConstructPartialBlockIdentifier(block))
+		_, amounts, _, fetcherErr := fetcher.AccountBalance(ctx, network, acc, types.ConstructPartialBlockIdentifier(block), []*types.Currency{rpc.CeloGold})
 		if fetcherErr != nil {
 			return nil, fetcherErr.Err
 		}
@@ -148,7 +148,7 @@ func reconcileRange(blocks []*types.Block, checkDifferences func(id string, chan
 
 		for _, tx := range block.Transactions {
 			for _, op := range tx.Operations {
-				if op.Amount != nil && op.Amount.Currency.Symbol == rpc.CeloGold.Symbol && op.Status == string(rpc.OperationSuccess) {
+				if op.Amount != nil && op.Amount.Currency.Symbol == rpc.CeloGold.Symbol && op.Status != nil && *op.Status == string(rpc.OperationSuccess) {
 					val, _ := new(big.Int).SetString(op.Amount.Value, 10)
 					blockChanges.Add(op.Account, val)
 					rangeChanges.Add(op.Account, val)
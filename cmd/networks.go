@@ -0,0 +1,151 @@
+/*
+Copyright © 2020 Celo Org
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/kliento/client"
+	"github.com/celo-org/rosetta/db"
+	"github.com/celo-org/rosetta/service/monitor"
+	"github.com/celo-org/rosetta/service/rpc"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// NetworkConfig is an entry of the --networks file: a network served from an already running node
+type NetworkConfig struct {
+	// Network is one of 'mainnet', 'alfajores' or 'baklava', or empty to detect it from the node's chain id
+	Network string `json:"network"`
+	// Genesis is the path to the genesis.json of a custom chain
+	Genesis string `json:"genesis"`
+	// NodeURL is the IPC path, or HTTP or WS url, of the node
+	NodeURL string `json:"nodeUrl"`
+	// Datadir is the directory of the network's rosetta.db
+	Datadir string `json:"datadir"`
+}
+
+func readNetworkConfigs(path string) ([]NetworkConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []NetworkConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %w", path, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no networks in %s", path)
+	}
+
+	for i, config := range configs {
+		if config.Network != "" && config.Genesis != "" {
+			return nil, fmt.Errorf("network %d: must provide at most one of 'network' or 'genesis'", i)
+		}
+		if config.NodeURL == "" {
+			return nil, fmt.Errorf("network %d: missing 'nodeUrl'", i)
+		}
+		if config.Datadir == "" {
+			return nil, fmt.Errorf("network %d: missing 'datadir'", i)
+		}
+	}
+	return configs, nil
+}
+
+// runMultiNetworkServices runs a monitor per network, and a single rpc service for all of them.
+// Every network is connected and validated before any service starts, and closed once they all stop.
+func runMultiNetworkServices(ctx context.Context, configs []NetworkConfig, rpcConfig *rpc.RosettaServerConfig) error {
+	var closers []func()
+	defer func() {
+		for _, closer := range closers {
+			closer()
+		}
+	}()
+
+	networks := make([]*rpc.Network, len(configs))
+	stores := make([]db.RosettaDB, len(configs))
+	for i, config := range configs {
+		cc, err := client.DialContext(ctx, config.NodeURL)
+		if err != nil {
+			return fmt.Errorf("can't connect to node %s: %w", config.NodeURL, err)
+		}
+		closers = append(closers, cc.Close)
+
+		chainParams, err := nodeChainParameters(ctx, cc, config.Network, config.Genesis)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", config.NodeURL, err)
+		}
+
+		if err := os.MkdirAll(config.Datadir, 0700); err != nil {
+			return err
+		}
+		celoStore, err := db.NewSqliteDb(filepath.Join(config.Datadir, "rosetta.db"))
+		if err != nil {
+			return fmt.Errorf("can't open rosetta.db: %w", err)
+		}
+		datadir := config.Datadir
+		closers = append(closers, func() {
+			if err := celoStore.Close(); err != nil {
+				log.Warn("Can't close rosetta.db", "datadir", datadir, "err", err)
+			}
+		})
+
+		log.Info("Serving network", "chainId", chainParams.ChainId, "epochSize", chainParams.EpochSize, "node", config.NodeURL)
+		networks[i] = &rpc.Network{Client: cc, DB: celoStore, ChainParams: chainParams}
+		stores[i] = celoStore
+	}
+
+	rpcService, err := rpc.NewMultiNetworkRosettaServer(networks, rpcConfig)
+	if err != nil {
+		return fmt.Errorf("can't create rpc server: %w", err)
+	}
+
+	grp, ctx := errgroup.WithContext(ctx)
+	for i, network := range networks {
+		network, celoStore := network, stores[i]
+
+		grp.Go(func() error {
+			err := monitor.NewMonitorService(
+				network.Client,
+				celoStore,
+				network.ChainParams,
+				false,
+				viper.GetBool("monitor.indexOps"),
+				rpcConfig.RequestTimeout,
+				nil,
+			).Start(ctx)
+			if err != nil {
+				return fmt.Errorf("error running monitor service for chain %s: %w", network.ChainParams.ChainId, err)
+			}
+			return nil
+		})
+	}
+
+	grp.Go(func() error {
+		if err := rpcService.Start(ctx); err != nil {
+			return fmt.Errorf("error running rpc service : %w", err)
+		}
+		return nil
+	})
+	return grp.Wait()
+}
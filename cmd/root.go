@@ -48,6 +48,7 @@ func Execute() {
 func init() {
 	RootCmd.AddCommand(cli.CliCmd)
 	RootCmd.AddCommand(serveCmd)
+	RootCmd.AddCommand(backfillCmd)
 }
 
 func exitOnMissingConfig(cmd *cobra.Command, configKey string) {
@@ -27,7 +27,6 @@ import (
 	"github.com/celo-org/kliento/client"
 	"github.com/celo-org/rosetta/cmd/internal/utils"
 	"github.com/celo-org/rosetta/db"
-	"github.com/celo-org/rosetta/internal/fileutils"
 	"github.com/celo-org/rosetta/internal/signals"
 	"github.com/celo-org/rosetta/service"
 	"github.com/celo-org/rosetta/service/geth"
@@ -55,17 +54,30 @@ var serveCmd = &cobra.Command{
 
 type ConfigPaths string
 
+// Modes of the run command
+const (
+	onlineMode  = "online"
+	offlineMode = "offline"
+)
+
 func init() {
 	flagSet := serveCmd.Flags()
 
 	// Common Flags
 	flagSet.String("datadir", "", "datadir to use")
 	utils.ExitOnError(serveCmd.MarkFlagDirname("datadir"))
+	flagSet.String("networks", "", "Path to a JSON file listing the networks to serve from already running nodes, as [{network, genesis, nodeUrl, datadir}] (replaces datadir and geth.* flags)")
+	utils.ExitOnError(serveCmd.MarkFlagFilename("networks", "json"))
+	flagSet.String("node.url", "", "(Optional) IPC path, or HTTP or WS url, of an already running node to use instead of starting geth (geth.* flags other than geth.network and geth.genesis are ignored)")
+	flagSet.String("mode", onlineMode, "Either 'online', or 'offline' to serve only the construction endpoints that need no node (only geth.network or geth.genesis are used)")
 
 	// RPC Service Flags
 	flagSet.Uint("rpc.port", 8080, "Listening port for http server")
 	flagSet.String("rpc.address", "", "Listening address for http server")
 	flagSet.Duration("rpc.reqTimeout", 120*time.Second, "Timeout for requests to this service, this also controls the timeout sent to the blockchain node for trace transaction requests")
+	flagSet.Bool("rpc.inlineTxs", false, "Return traced transactions in /block responses instead of only their identifiers (overridable per request with ?inline_transactions=)")
+	flagSet.Uint("rpc.traceWorkers", 8, "Max number of concurrent transaction traces when inlining transactions in /block")
+	flagSet.Uint64("rpc.syncThreshold", 5, "Max number of blocks the node and the indexer can lag behind and still be reported as synced in /network/status")
 
 	// Geth Service Flags
 	flagSet.String("geth.binary", "", "Path to the celo-blockchain binary")
@@ -100,6 +112,7 @@ func init() {
 
 	// Monitor Service Flags
 	flagSet.Bool("monitor.initcontracts", false, "Set to true to properly initialize contract state, i.e. when running MyCelo testnets")
+	flagSet.Bool("monitor.indexOps", false, "Trace each new block and store its operations in rosetta.db, use the backfill command for older blocks")
 }
 
 func getDatadir(cmd *cobra.Command) string {
@@ -151,15 +164,21 @@ func readGethOption(cmd *cobra.Command, datadir string) *geth.GethOpts {
 }
 
 func runRunCmd(cmd *cobra.Command, args []string) {
-	datadir := getDatadir(cmd)
-	gethOpts := readGethOption(cmd, datadir)
-	sqlitePath := filepath.Join(datadir, "rosetta.db")
+	mode := viper.GetString("mode")
+	if mode != onlineMode && mode != offlineMode {
+		printUsageAndExit(cmd, fmt.Sprintf("Invalid mode '%s', must be '%s' or '%s'", mode, onlineMode, offlineMode))
+	}
 
 	rpcConfig :=
 		&rpc.RosettaServerConfig{
 			Interface:      viper.GetString("rpc.address"),
 			Port:           viper.GetUint("rpc.port"),
 			RequestTimeout: viper.GetDuration("rpc.reqTimeout"),
+
+			InlineBlockTransactions: viper.GetBool("rpc.inlineTxs"),
+			BlockTraceWorkers:       viper.GetUint("rpc.traceWorkers"),
+			SyncThreshold:           viper.GetUint64("rpc.syncThreshold"),
+			Offline:                 mode == offlineMode,
 		}
 
 	// TODO - create context that encapsulate Stop on Signal behaviour
@@ -172,12 +191,135 @@ func runRunCmd(cmd *cobra.Command, args []string) {
 		stopServices()
 	}()
 
+	if networksPath := viper.GetString("networks"); networksPath != "" {
+		if rpcConfig.Offline {
+			printUsageAndExit(cmd, "Can't use 'networks' in offline mode")
+		}
+		configs, err := readNetworkConfigs(networksPath)
+		if err != nil {
+			printUsageAndExit(cmd, err.Error())
+		}
+		if err := runMultiNetworkServices(srvCtx, configs, rpcConfig); err != nil && err != context.Canceled {
+			log.Error("Rosetta run failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if rpcConfig.Offline {
+		if err := runOfflineServices(srvCtx, readChainParameters(cmd), rpcConfig); err != nil {
+			log.Error("Rosetta run failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	datadir := getDatadir(cmd)
+	sqlitePath := filepath.Join(datadir, "rosetta.db")
+
+	if nodeUrl := viper.GetString("node.url"); nodeUrl != "" {
+		network, genesisPath := viper.GetString("geth.network"), viper.GetString("geth.genesis")
+		if genesisPath != "" && network != "" {
+			printUsageAndExit(cmd, "Must provide at most one of 'geth.genesis' or 'geth.network'")
+		}
+		if err := runNodeServices(srvCtx, sqlitePath, nodeUrl, network, genesisPath, rpcConfig); err != nil {
+			log.Error("Rosetta run failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	gethOpts := readGethOption(cmd, datadir)
 	if err := runAllServices(srvCtx, sqlitePath, gethOpts, rpcConfig); err != nil {
 		log.Error("Rosetta run failed", "err", err)
 		os.Exit(1)
 	}
 }
 
+// readChainParameters reads the chain parameters of geth.network or geth.genesis, without any node
+func readChainParameters(cmd *cobra.Command) *service.ChainParameters {
+	network, genesisPath := viper.GetString("geth.network"), viper.GetString("geth.genesis")
+	if genesisPath == "" && network == "" {
+		printUsageAndExit(cmd, "Missing config option for 'geth.genesis' or 'geth.network'")
+	} else if genesisPath != "" && network != "" {
+		printUsageAndExit(cmd, "Must provide exactly one of 'geth.genesis' or 'geth.network'")
+	}
+
+	chainParams, err := geth.ChainParametersFor(network, genesisPath)
+	if err != nil {
+		printUsageAndExit(cmd, err.Error())
+	}
+	return chainParams
+}
+
+// runOfflineServices runs only the rpc service, without node nor rosetta.db
+func runOfflineServices(ctx context.Context, chainParams *service.ChainParameters, rpcConfig *rpc.RosettaServerConfig) error {
+	log.Info("Running in offline mode", "chainId", chainParams.ChainId)
+
+	rpcService, err := rpc.NewRosettaServer(nil, nil, rpcConfig, chainParams)
+	if err != nil {
+		return fmt.Errorf("can't create rpc server: %w", err)
+	}
+
+	if err := rpcService.Start(ctx); err != nil {
+		return fmt.Errorf("error running rpc service : %w", err)
+	}
+	return nil
+}
+
+// nodeChainParameters returns the chain parameters of the given network or genesis file, checking they match
+// the node's chain id, or else of the known network with the node's chain id
+func nodeChainParameters(ctx context.Context, cc *client.CeloClient, network string, genesisPath string) (*service.ChainParameters, error) {
+	nodeChainId, err := cc.Eth.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't get the node's chain id: %w", err)
+	}
+
+	if network == "" && genesisPath == "" {
+		return geth.ChainParametersForChainId(nodeChainId)
+	}
+
+	chainParams, err := geth.ChainParametersFor(network, genesisPath)
+	if err != nil {
+		return nil, err
+	}
+	if nodeChainId.Cmp(chainParams.ChainId) != 0 {
+		return nil, fmt.Errorf("node has chain id %s, expected %s", nodeChainId, chainParams.ChainId)
+	}
+	return chainParams, nil
+}
+
+// runNodeServices runs the rpc and monitor services against an already running node
+func runNodeServices(ctx context.Context, sqlitePath string, nodeUrl string, network string, genesisPath string, rpcConfig *rpc.RosettaServerConfig) error {
+	cc, err := client.DialContext(ctx, nodeUrl)
+	if err != nil {
+		return fmt.Errorf("can't connect to node %s: %w", nodeUrl, err)
+	}
+	defer cc.Close()
+
+	chainParams, err := nodeChainParameters(ctx, cc, network, genesisPath)
+	if err != nil {
+		return err
+	}
+	log.Info("Detected Chain Parameters", "chainId", chainParams.ChainId, "epochSize", chainParams.EpochSize, "node", nodeUrl)
+
+	celoStore, err := db.NewSqliteDb(sqlitePath)
+	if err != nil {
+		return fmt.Errorf("can't open rosetta.db: %w", err)
+	}
+	defer closeStore(celoStore)
+
+	ec := service.NewErrorCollector()
+	grp, ctx := errgroup.WithContext(ctx)
+	if err := startRosettaServices(ctx, grp, ec, cc, celoStore, chainParams, rpcConfig, nil); err != nil {
+		return err
+	}
+	// We gather errors in the error collector, so no need to check the error group error.
+	//nolint:errcheck
+	grp.Wait()
+	return ec.Error()
+}
+
 func runAllServices(ctx context.Context, sqlitePath string, gethOpts *geth.GethOpts, rpcConfig *rpc.RosettaServerConfig) error {
 
 	gethSrv := geth.NewGethService(gethOpts)
@@ -190,6 +332,7 @@ func runAllServices(ctx context.Context, sqlitePath string, gethOpts *geth.GethO
 	if err != nil {
 		return fmt.Errorf("can't open rosetta.db: %w", err)
 	}
+	defer closeStore(celoStore)
 
 	ec := service.NewErrorCollector()
 	grp, ctx := errgroup.WithContext(ctx)
@@ -203,23 +346,15 @@ func runAllServices(ctx context.Context, sqlitePath string, gethOpts *geth.GethO
 		return nil
 	})
 
+	// Geth is restarted if it exits, so wait until it is first ready, i.e. it answers and has peers
 	ctxWithTimeout, stop := context.WithTimeout(ctx, 5*time.Minute)
 	defer stop()
-loop:
-	for {
-		select {
-		case <-time.After(500 * time.Millisecond):
-			if fileutils.FileExists(gethSrv.IpcFilePath()) {
-				break loop // break out of the for loop
-			}
-		case <-ctxWithTimeout.Done():
-			err := ctxWithTimeout.Err()
-			switch {
-			case err == context.Canceled:
-				return fmt.Errorf("Geth service died before deadline, check the log %s", gethOpts.LogFile())
-			case err == context.DeadlineExceeded:
-				return fmt.Errorf("Geth service did not create ipc file within deadline, check the log: %s", gethOpts.LogFile())
-			}
+	if err := gethSrv.Health().WaitUp(ctxWithTimeout); err != nil {
+		switch {
+		case err == context.Canceled:
+			return fmt.Errorf("Geth service died before deadline, check the log %s", gethOpts.LogFile())
+		case err == context.DeadlineExceeded:
+			return fmt.Errorf("Geth service was not ready within deadline, check the log: %s", gethOpts.LogFile())
 		}
 	}
 
@@ -230,7 +365,28 @@ loop:
 	if err != nil {
 		return fmt.Errorf("can't connect to geth: %w", err)
 	}
+	defer cc.Close()
 
+	if err := startRosettaServices(ctx, grp, ec, cc, celoStore, chainParams, rpcConfig, gethSrv.Health()); err != nil {
+		return err
+	}
+	// We gather errors in the error collector, so no need to check the error group error.
+	//nolint:errcheck
+	grp.Wait()
+	return ec.Error()
+}
+
+// closeStore closes rosetta.db once the services using it are stopped
+func closeStore(celoStore interface{ Close() error }) {
+	if err := celoStore.Close(); err != nil {
+		log.Warn("Can't close rosetta.db", "err", err)
+	}
+}
+
+// startRosettaServices starts the rpc and monitor services in the error group, pausing them while the
+// node is down if its health is given
+func startRosettaServices(ctx context.Context, grp *errgroup.Group, ec *service.ErrorCollector, cc *client.CeloClient, celoStore db.RosettaDB, chainParams *service.ChainParameters, rpcConfig *rpc.RosettaServerConfig, nodeHealth *service.NodeHealth) error {
+	rpcConfig.NodeHealth = nodeHealth
 	rpcService, err := rpc.NewRosettaServer(cc, celoStore, rpcConfig, chainParams)
 	if err != nil {
 		return fmt.Errorf("can't create rpc server: %w", err)
@@ -247,11 +403,14 @@ loop:
 	})
 
 	grp.Go(func() error {
-		err = monitor.NewMonitorService(
+		err := monitor.NewMonitorService(
 			cc,
 			celoStore,
-			chainParams.IsGingerbread,
+			chainParams,
 			viper.GetBool("monitor.initcontracts"),
+			viper.GetBool("monitor.indexOps"),
+			rpcConfig.RequestTimeout,
+			nodeHealth,
 		).Start(ctx)
 		if err != nil {
 			fmt.Println("error running mon serrvice")
@@ -260,8 +419,5 @@ loop:
 		}
 		return nil
 	})
-	// We gather errors in the error collector, so no need to check the error group error.
-	//nolint:errcheck
-	grp.Wait()
-	return ec.Error()
+	return nil
 }
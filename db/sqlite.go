@@ -18,6 +18,7 @@ import (
 	"context"
 	"database/sql"
 	"math/big"
+	"strings"
 
 	"github.com/celo-org/celo-blockchain/common"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,22 +27,49 @@ import (
 type rosettaSqlDb struct {
 	db *sql.DB
 
-	getLastBlockStmt              *sql.Stmt
-	updateLastBlockStmt           *sql.Stmt
-	getRegistryAddressStmt        *sql.Stmt
-	getGasPriceMinimumStmt        *sql.Stmt
-	getCarbonOffsetPartnerStmt    *sql.Stmt
-	insertGasPriceMinimumStmt     *sql.Stmt
-	insertRegistryAddressStmt     *sql.Stmt
-	insertCarbonOffsetPartnerStmt *sql.Stmt
+	getLastBlockStmt                  *sql.Stmt
+	updateLastBlockStmt               *sql.Stmt
+	getRegistryAddressStmt            *sql.Stmt
+	getGasPriceMinimumStmt            *sql.Stmt
+	getCurrencyGasPriceMinimumStmt    *sql.Stmt
+	getCarbonOffsetPartnerStmt        *sql.Stmt
+	insertGasPriceMinimumStmt         *sql.Stmt
+	insertCurrencyGasPriceMinimumStmt *sql.Stmt
+	insertRegistryAddressStmt         *sql.Stmt
+	insertCarbonOffsetPartnerStmt     *sql.Stmt
+	getLastIndexedBlockStmt           *sql.Stmt
+	getIndexedBlockHashStmt           *sql.Stmt
+	getTransactionOperationsStmt      *sql.Stmt
+	insertIndexedBlockStmt            *sql.Stmt
+	insertTransactionOperationsStmt   *sql.Stmt
+	insertAccountOperationStmt        *sql.Stmt
+	insertBlockEventStmt              *sql.Stmt
+	getBlockEventsStmt                *sql.Stmt
+	getMaxBlockEventSequenceStmt      *sql.Stmt
+	insertBlockHashStmt               *sql.Stmt
+	getBlockHashStmt                  *sql.Stmt
+	insertGroupVoterStmt              *sql.Stmt
+	getGroupVotersStmt                *sql.Stmt
+	getGroupVotersFromStmt            *sql.Stmt
 }
 
 func initDatabase(db *sql.DB) error {
 	schema := []string{
 		"CREATE table IF NOT EXISTS registry (contract text, fromBlock integer, fromTx integer, address blob)",
 		"CREATE table IF NOT EXISTS gasPriceMinimum (fromBlock integer, val blob)",
+		"CREATE table IF NOT EXISTS currencyGasPriceMinimum (currency blob, fromBlock integer, val blob)",
 		"CREATE table IF NOT EXISTS carbonOffsetPartner (fromBlock integer, fromTx integer, address blob)",
+		"CREATE table IF NOT EXISTS blockHashes (blockNumber integer PRIMARY KEY, blockHash blob)",
 		"CREATE table IF NOT EXISTS stats (lastBlock integer not null DEFAULT 0)",
+		"CREATE table IF NOT EXISTS indexedBlocks (blockNumber integer PRIMARY KEY, blockHash blob, version integer not null DEFAULT 0)",
+		"CREATE table IF NOT EXISTS operations (blockNumber integer, blockHash blob, txHash blob, ops blob)",
+		"CREATE INDEX IF NOT EXISTS operationsByTx ON operations (blockHash, txHash)",
+		"CREATE table IF NOT EXISTS accountOperations (blockNumber integer, blockHash blob, txHash blob, address blob, subAccount text, opType text, successful integer, currency text)",
+		"CREATE INDEX IF NOT EXISTS accountOperationsByAddress ON accountOperations (address, blockNumber)",
+		"CREATE INDEX IF NOT EXISTS accountOperationsByBlock ON accountOperations (blockNumber)",
+		"CREATE table IF NOT EXISTS blockEvents (sequence integer PRIMARY KEY, blockNumber integer, blockHash blob, type text)",
+		"CREATE table IF NOT EXISTS groupVoters (groupAddress blob, account blob, fromBlock integer, PRIMARY KEY (groupAddress, account))",
+		"CREATE table IF NOT EXISTS groupVotersCoverage (fromBlock integer not null)",
 	}
 
 	for _, sqlString := range schema {
@@ -50,6 +78,11 @@ func initDatabase(db *sql.DB) error {
 		}
 	}
 
+	// Blocks indexed before operations were versioned have version 0
+	if err := addColumnIfMissing(db, "indexedBlocks", "version", "integer not null DEFAULT 0"); err != nil {
+		return err
+	}
+
 	// Insert an initial lastBlock if none found
 	var count uint
 	if err := db.QueryRow("SELECT count(lastBlock) FROM stats").Scan(&count); err != nil {
@@ -63,9 +96,35 @@ func initDatabase(db *sql.DB) error {
 		}
 	}
 
+	// Group voters are tracked from the block after the last persisted one when the table is created,
+	// the voters of earlier blocks are backfilled by the monitor
+	if err := db.QueryRow("SELECT count(fromBlock) FROM groupVotersCoverage").Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		_, err := db.Exec(`INSERT INTO groupVotersCoverage (fromBlock) SELECT CASE WHEN lastBlock == 0 THEN 0 ELSE lastBlock + 1 END FROM stats`)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// addColumnIfMissing adds a column to a table created by a previous version
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	var count uint
+	if err := db.QueryRow("SELECT count(*) FROM pragma_table_info('"+table+"') WHERE name == ?", column).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 func NewSqliteDb(dbpath string) (*rosettaSqlDb, error) {
 	db, err := sql.Open("sqlite3", dbpath)
 	if err != nil {
@@ -86,6 +145,11 @@ func NewSqliteDb(dbpath string) (*rosettaSqlDb, error) {
 		return nil, err
 	}
 
+	insertCurrencyGasPriceMinimumStmt, err := db.Prepare("INSERT INTO currencyGasPriceMinimum (currency, fromBlock, val) VALUES (?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
 	insertRegistryAddressStmt, err := db.Prepare("INSERT INTO registry (contract, fromBlock, fromTx, address) VALUES (?, ?, ?, ?)")
 	if err != nil {
 		return nil, err
@@ -123,6 +187,17 @@ func NewSqliteDb(dbpath string) (*rosettaSqlDb, error) {
 		return nil, err
 	}
 
+	getCurrencyGasPriceMinimumStmt, err := db.Prepare(`
+		SELECT val 
+			FROM currencyGasPriceMinimum 
+			WHERE currency == $1 AND fromBlock <= $2 
+			ORDER BY fromblock DESC
+			LIMIT 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+
 	getCarbonOffsetPartnerStmt, err := db.Prepare(`
 		SELECT address 
 			FROM carbonOffsetPartner 
@@ -134,19 +209,122 @@ func NewSqliteDb(dbpath string) (*rosettaSqlDb, error) {
 		return nil, err
 	}
 
+	getLastIndexedBlockStmt, err := db.Prepare("SELECT COALESCE(MAX(blockNumber), 0) FROM indexedBlocks")
+	if err != nil {
+		return nil, err
+	}
+
+	getIndexedBlockHashStmt, err := db.Prepare("SELECT blockHash FROM indexedBlocks WHERE blockNumber == $1 AND version == $2")
+	if err != nil {
+		return nil, err
+	}
+
+	getTransactionOperationsStmt, err := db.Prepare(`
+		SELECT ops FROM operations
+			JOIN indexedBlocks ON indexedBlocks.blockNumber == operations.blockNumber AND indexedBlocks.blockHash == operations.blockHash
+			WHERE operations.blockHash == $1 AND txHash == $2 AND version == $3
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	insertIndexedBlockStmt, err := db.Prepare("INSERT OR REPLACE INTO indexedBlocks (blockNumber, blockHash, version) VALUES (?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
+	insertTransactionOperationsStmt, err := db.Prepare("INSERT INTO operations (blockNumber, blockHash, txHash, ops) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
+	insertAccountOperationStmt, err := db.Prepare("INSERT INTO accountOperations (blockNumber, blockHash, txHash, address, subAccount, opType, successful, currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
+	insertBlockEventStmt, err := db.Prepare(`
+		INSERT INTO blockEvents (sequence, blockNumber, blockHash, type)
+			SELECT COALESCE(MAX(sequence) + 1, 0), ?, ?, ? FROM blockEvents
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	getBlockEventsStmt, err := db.Prepare(`
+		SELECT sequence, blockNumber, blockHash, type
+			FROM blockEvents
+			WHERE sequence >= $1 AND sequence < $2
+			ORDER BY sequence
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	getMaxBlockEventSequenceStmt, err := db.Prepare("SELECT COALESCE(MAX(sequence), -1) FROM blockEvents")
+	if err != nil {
+		return nil, err
+	}
+
+	insertBlockHashStmt, err := db.Prepare("INSERT OR REPLACE INTO blockHashes (blockNumber, blockHash) VALUES (?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
+	getBlockHashStmt, err := db.Prepare("SELECT blockHash FROM blockHashes WHERE blockNumber == $1")
+	if err != nil {
+		return nil, err
+	}
+
+	insertGroupVoterStmt, err := db.Prepare("INSERT INTO groupVoters (groupAddress, account, fromBlock) VALUES (?, ?, ?) ON CONFLICT (groupAddress, account) DO UPDATE SET fromBlock = MIN(fromBlock, excluded.fromBlock)")
+	if err != nil {
+		return nil, err
+	}
+
+	getGroupVotersStmt, err := db.Prepare("SELECT account FROM groupVoters WHERE groupAddress == $1 AND fromBlock <= $2 ORDER BY account")
+	if err != nil {
+		return nil, err
+	}
+
+	getGroupVotersFromStmt, err := db.Prepare("SELECT fromBlock FROM groupVotersCoverage")
+	if err != nil {
+		return nil, err
+	}
+
 	return &rosettaSqlDb{
-		db:                            db,
-		getLastBlockStmt:              getLastBlockStmt,
-		updateLastBlockStmt:           updateLastBlockStmt,
-		getRegistryAddressStmt:        getRegistryAddressStmt,
-		getGasPriceMinimumStmt:        getGasPriceMinimumStmt,
-		getCarbonOffsetPartnerStmt:    getCarbonOffsetPartnerStmt,
-		insertGasPriceMinimumStmt:     insertGasPriceMinimumStmt,
-		insertRegistryAddressStmt:     insertRegistryAddressStmt,
-		insertCarbonOffsetPartnerStmt: insertCarbonOffsetPartnerStmt,
+		db:                                db,
+		getLastBlockStmt:                  getLastBlockStmt,
+		updateLastBlockStmt:               updateLastBlockStmt,
+		getRegistryAddressStmt:            getRegistryAddressStmt,
+		getGasPriceMinimumStmt:            getGasPriceMinimumStmt,
+		getCurrencyGasPriceMinimumStmt:    getCurrencyGasPriceMinimumStmt,
+		getCarbonOffsetPartnerStmt:        getCarbonOffsetPartnerStmt,
+		insertGasPriceMinimumStmt:         insertGasPriceMinimumStmt,
+		insertCurrencyGasPriceMinimumStmt: insertCurrencyGasPriceMinimumStmt,
+		insertRegistryAddressStmt:         insertRegistryAddressStmt,
+		insertCarbonOffsetPartnerStmt:     insertCarbonOffsetPartnerStmt,
+		getLastIndexedBlockStmt:           getLastIndexedBlockStmt,
+		getIndexedBlockHashStmt:           getIndexedBlockHashStmt,
+		getTransactionOperationsStmt:      getTransactionOperationsStmt,
+		insertIndexedBlockStmt:            insertIndexedBlockStmt,
+		insertTransactionOperationsStmt:   insertTransactionOperationsStmt,
+		insertAccountOperationStmt:        insertAccountOperationStmt,
+		insertBlockEventStmt:              insertBlockEventStmt,
+		getBlockEventsStmt:                getBlockEventsStmt,
+		getMaxBlockEventSequenceStmt:      getMaxBlockEventSequenceStmt,
+		insertBlockHashStmt:               insertBlockHashStmt,
+		getBlockHashStmt:                  getBlockHashStmt,
+		insertGroupVoterStmt:              insertGroupVoterStmt,
+		getGroupVotersStmt:                getGroupVotersStmt,
+		getGroupVotersFromStmt:            getGroupVotersFromStmt,
 	}, nil
 }
 
+// Close closes the database, it must not be used afterwards
+func (cs *rosettaSqlDb) Close() error {
+	return cs.db.Close()
+}
+
 func (cs *rosettaSqlDb) LastPersistedBlock(ctx context.Context) (*big.Int, error) {
 	var block int64
 
@@ -193,6 +371,26 @@ func (cs *rosettaSqlDb) GasPriceMinimumFor(ctx context.Context, block *big.Int)
 	return new(big.Int).SetBytes(gpmBytes), nil
 }
 
+// GasPriceMinimumForCurrency reads the gpm in the given fee currency that was used FOR the specified block.
+// Same as GasPriceMinimumFor, but fails with ErrGasPriceMinimumNotFound when there's no record.
+func (cs *rosettaSqlDb) GasPriceMinimumForCurrency(ctx context.Context, block *big.Int, currency common.Address) (*big.Int, error) {
+	prev := new(big.Int).Sub(block, big.NewInt(1))
+	if err := cs.CheckBlockNumber(ctx, prev); err != nil {
+		return nil, err
+	}
+
+	var gpmBytes []byte
+
+	if err := cs.getCurrencyGasPriceMinimumStmt.QueryRowContext(ctx, currency, prev.Uint64()).Scan(&gpmBytes); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrGasPriceMinimumNotFound
+		}
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(gpmBytes), nil
+}
+
 func (cs *rosettaSqlDb) RegistryAddressStartOf(ctx context.Context, block *big.Int, txIndex uint, contractName string) (common.Address, error) {
 	if err := cs.CheckBlockNumber(ctx, block); err != nil {
 		return common.ZeroAddress, err
@@ -245,6 +443,59 @@ func (cs *rosettaSqlDb) CarbonOffsetPartnerStartOf(ctx context.Context, block *b
 	return addr, nil
 }
 
+func (cs *rosettaSqlDb) GroupVoters(ctx context.Context, block *big.Int, group common.Address) ([]common.Address, error) {
+	if err := cs.CheckBlockNumber(ctx, block); err != nil {
+		return nil, err
+	}
+
+	from, err := cs.GroupVotersFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if from.Sign() > 0 {
+		return nil, ErrGroupVotersIncomplete
+	}
+
+	rows, err := cs.getGroupVotersStmt.QueryContext(ctx, group, block.Int64())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	voters := make([]common.Address, 0)
+	for rows.Next() {
+		var account common.Address
+		if err := rows.Scan(&account); err != nil {
+			return nil, err
+		}
+		voters = append(voters, account)
+	}
+	return voters, rows.Err()
+}
+
+func (cs *rosettaSqlDb) GroupVotersFrom(ctx context.Context) (*big.Int, error) {
+	var block int64
+
+	if err := cs.getGroupVotersFromStmt.QueryRowContext(ctx).Scan(&block); err != nil {
+		return nil, err
+	}
+
+	return big.NewInt(block), nil
+}
+
+func (cs *rosettaSqlDb) PersistedBlockHash(ctx context.Context, block *big.Int) (common.Hash, error) {
+	var hash common.Hash
+
+	if err := cs.getBlockHashStmt.QueryRowContext(ctx, block.Int64()).Scan(&hash); err != nil {
+		if err == sql.ErrNoRows {
+			return common.Hash{}, ErrBlockHashNotFound
+		}
+		return common.Hash{}, err
+	}
+
+	return hash, nil
+}
+
 func (cs *rosettaSqlDb) ApplyChanges(ctx context.Context, changeSet *BlockChangeSet) error {
 
 	tx, err := cs.db.BeginTx(ctx, nil)
@@ -252,6 +503,15 @@ func (cs *rosettaSqlDb) ApplyChanges(ctx context.Context, changeSet *BlockChange
 		return err
 	}
 
+	if changeSet.Reorg {
+		if err := cs.rollbackFrom(ctx, tx, changeSet.BlockNumber); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
 	_, err = tx.StmtContext(ctx, cs.updateLastBlockStmt).ExecContext(ctx, changeSet.BlockNumber.Int64())
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -280,6 +540,33 @@ func (cs *rosettaSqlDb) ApplyChanges(ctx context.Context, changeSet *BlockChange
 		}
 	}
 
+	setCurrencyGasPriceMinimumStmtPrep := tx.StmtContext(ctx, cs.insertCurrencyGasPriceMinimumStmt)
+
+	for currency, gpm := range changeSet.CurrencyGasPriceMinimums {
+		if _, err := setCurrencyGasPriceMinimumStmtPrep.ExecContext(ctx, currency, changeSet.BlockNumber.Int64(), gpm.Bytes()); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	if changeSet.BlockHash != (common.Hash{}) {
+		if _, err = tx.StmtContext(ctx, cs.insertBlockHashStmt).ExecContext(ctx, changeSet.BlockNumber.Int64(), changeSet.BlockHash); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+
+		if _, err = tx.StmtContext(ctx, cs.insertBlockEventStmt).ExecContext(ctx, changeSet.BlockNumber.Int64(), changeSet.BlockHash, BlockAdded); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
 	if changeSet.CarbonOffsetPartnerChange.Address != common.ZeroAddress {
 		if _, err = tx.StmtContext(ctx, cs.insertCarbonOffsetPartnerStmt).ExecContext(ctx, changeSet.BlockNumber.Int64(), int64(changeSet.CarbonOffsetPartnerChange.TxIndex), changeSet.CarbonOffsetPartnerChange.Address); err != nil {
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -289,9 +576,305 @@ func (cs *rosettaSqlDb) ApplyChanges(ctx context.Context, changeSet *BlockChange
 		}
 	}
 
+	insertGroupVoterStmtPrep := tx.StmtContext(ctx, cs.insertGroupVoterStmt)
+
+	for _, gv := range changeSet.GroupVoters {
+		fromBlock := changeSet.BlockNumber
+		if gv.FromBlock != nil {
+			fromBlock = gv.FromBlock
+		}
+		if _, err := insertGroupVoterStmtPrep.ExecContext(ctx, gv.Group, gv.Account, fromBlock.Int64()); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	if changeSet.GroupVotersBackfilled {
+		if _, err := tx.ExecContext(ctx, "UPDATE groupVotersCoverage SET fromBlock = 0"); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// rollbackFrom deletes every record of the blocks from block onwards, appending a BlockRemoved event
+// for each of them, most recent first
+func (cs *rosettaSqlDb) rollbackFrom(ctx context.Context, tx *sql.Tx, block *big.Int) error {
+	rows, err := tx.QueryContext(ctx, "SELECT blockNumber, blockHash FROM blockHashes WHERE blockNumber >= $1 ORDER BY blockNumber DESC", block.Int64())
+	if err != nil {
+		return err
+	}
+
+	var removed []BlockEvent
+	for rows.Next() {
+		var blockNumber int64
+		var event BlockEvent
+		if err := rows.Scan(&blockNumber, &event.BlockHash); err != nil {
+			rows.Close()
+			return err
+		}
+		event.BlockNumber = big.NewInt(blockNumber)
+		removed = append(removed, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	insertBlockEventStmtPrep := tx.StmtContext(ctx, cs.insertBlockEventStmt)
+
+	for _, event := range removed {
+		if _, err := insertBlockEventStmtPrep.ExecContext(ctx, event.BlockNumber.Int64(), event.BlockHash, BlockRemoved); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range []string{"registry", "gasPriceMinimum", "currencyGasPriceMinimum", "carbonOffsetPartner", "groupVoters"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE fromBlock >= $1", block.Int64()); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range []string{"blockHashes", "indexedBlocks", "operations", "accountOperations"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE blockNumber >= $1", block.Int64()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cs *rosettaSqlDb) LastIndexedBlock(ctx context.Context) (*big.Int, error) {
+	var block int64
+
+	if err := cs.getLastIndexedBlockStmt.QueryRowContext(ctx).Scan(&block); err != nil {
+		return nil, err
+	}
+
+	return big.NewInt(block), nil
+}
+
+func (cs *rosettaSqlDb) IndexedBlockHash(ctx context.Context, block *big.Int, version uint) (common.Hash, error) {
+	var hash common.Hash
+
+	if err := cs.getIndexedBlockHashStmt.QueryRowContext(ctx, block.Int64(), version).Scan(&hash); err != nil {
+		if err == sql.ErrNoRows {
+			return common.Hash{}, ErrOperationsNotFound
+		}
+		return common.Hash{}, err
+	}
+
+	return hash, nil
+}
+
+func (cs *rosettaSqlDb) TransactionOperations(ctx context.Context, blockHash common.Hash, txHash common.Hash, version uint) ([]byte, error) {
+	var ops []byte
+
+	if err := cs.getTransactionOperationsStmt.QueryRowContext(ctx, blockHash, txHash, version).Scan(&ops); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOperationsNotFound
+		}
+		return nil, err
+	}
+
+	return ops, nil
+}
+
+// StoreBlockOperations stores the operations of a block, replacing the ones stored for another block with the same number.
+// It fails with ErrBlockNotPersisted if the block was rolled back meanwhile.
+func (cs *rosettaSqlDb) StoreBlockOperations(ctx context.Context, blockOps *BlockOperations) error {
+	tx, err := cs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := checkPersistedBlock(ctx, tx, blockOps.BlockNumber, blockOps.BlockHash); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	for _, table := range []string{"operations", "accountOperations"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE blockNumber == $1", blockOps.BlockNumber.Int64()); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	insertTransactionOperationsStmtPrep := tx.StmtContext(ctx, cs.insertTransactionOperationsStmt)
+
+	for txHash, ops := range blockOps.Transactions {
+		if _, err := insertTransactionOperationsStmtPrep.ExecContext(ctx, blockOps.BlockNumber.Int64(), blockOps.BlockHash, txHash, ops); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	insertAccountOperationStmtPrep := tx.StmtContext(ctx, cs.insertAccountOperationStmt)
+
+	for _, ao := range blockOps.Accounts {
+		if _, err := insertAccountOperationStmtPrep.ExecContext(ctx, blockOps.BlockNumber.Int64(), blockOps.BlockHash, ao.TxHash, ao.Address, ao.SubAccount, ao.Type, ao.Successful, ao.Currency); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	if _, err := tx.StmtContext(ctx, cs.insertIndexedBlockStmt).ExecContext(ctx, blockOps.BlockNumber.Int64(), blockOps.BlockHash, blockOps.Version); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkPersistedBlock checks that blockHash is the persisted block for its number, within the tx so that
+// a concurrent rollback either happens before or deletes what the tx stores.
+// Blocks persisted before hashes were stored can't be checked.
+func checkPersistedBlock(ctx context.Context, tx *sql.Tx, blockNumber *big.Int, blockHash common.Hash) error {
+	var hash common.Hash
+	err := tx.QueryRowContext(ctx, "SELECT blockHash FROM blockHashes WHERE blockNumber == $1", blockNumber.Int64()).Scan(&hash)
+	if err == sql.ErrNoRows {
+		var firstHashed int64
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MIN(blockNumber), -1) FROM blockHashes").Scan(&firstHashed); err != nil {
+			return err
+		}
+		if firstHashed >= 0 && blockNumber.Int64() >= firstHashed {
+			return ErrBlockNotPersisted
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hash != blockHash {
+		return ErrBlockNotPersisted
+	}
+	return nil
+}
+
+func (cs *rosettaSqlDb) SearchTransactions(ctx context.Context, query *TransactionQuery) ([]TransactionRef, int64, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, conditionArgs ...interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	if query.TxHash != nil {
+		addCondition("txHash == ?", *query.TxHash)
+	}
+	switch {
+	case query.Address != nil && query.SubAccount != nil:
+		addCondition("(address == ? AND subAccount == ?)", *query.Address, *query.SubAccount)
+	case query.Address != nil:
+		addCondition("address == ?", *query.Address)
+	case query.SubAccount != nil:
+		addCondition("subAccount == ?", *query.SubAccount)
+	}
+	if query.Type != nil {
+		addCondition("opType == ?", *query.Type)
+	}
+	if query.Successful != nil {
+		addCondition("successful == ?", *query.Successful)
+	}
+	if query.Currency != nil {
+		addCondition("currency == ?", *query.Currency)
+	}
+
+	where := "blockNumber <= ?"
+	whereArgs := []interface{}{query.MaxBlock.Int64()}
+	if query.MinBlock != nil {
+		where += " AND blockNumber >= ?"
+		whereArgs = append(whereArgs, query.MinBlock.Int64())
+	}
+	if len(conditions) > 0 {
+		operator := " AND "
+		if query.Or {
+			operator = " OR "
+		}
+		where += " AND (" + strings.Join(conditions, operator) + ")"
+		whereArgs = append(whereArgs, args...)
+	}
+
+	matches := "SELECT DISTINCT blockNumber, blockHash, txHash FROM accountOperations WHERE " + where
+
+	var total int64
+	if err := cs.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+matches+")", whereArgs...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := cs.db.QueryContext(ctx, matches+" ORDER BY blockNumber DESC, txHash LIMIT ? OFFSET ?", append(whereArgs, query.Limit, query.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	refs := make([]TransactionRef, 0)
+	for rows.Next() {
+		var blockNumber int64
+		var ref TransactionRef
+		if err := rows.Scan(&blockNumber, &ref.BlockHash, &ref.TxHash); err != nil {
+			return nil, 0, err
+		}
+		ref.BlockNumber = big.NewInt(blockNumber)
+		refs = append(refs, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return refs, total, nil
+}
+
+func (cs *rosettaSqlDb) BlockEvents(ctx context.Context, offset int64, limit int64) ([]BlockEvent, error) {
+	rows, err := cs.getBlockEventsStmt.QueryContext(ctx, offset, offset+limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]BlockEvent, 0)
+	for rows.Next() {
+		var blockNumber int64
+		var event BlockEvent
+		if err := rows.Scan(&event.Sequence, &blockNumber, &event.BlockHash, &event.Type); err != nil {
+			return nil, err
+		}
+		event.BlockNumber = big.NewInt(blockNumber)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (cs *rosettaSqlDb) MaxBlockEventSequence(ctx context.Context) (int64, error) {
+	var sequence int64
+
+	if err := cs.getMaxBlockEventSequenceStmt.QueryRowContext(ctx).Scan(&sequence); err != nil {
+		return 0, err
+	}
+
+	return sequence, nil
+}
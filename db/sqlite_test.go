@@ -16,7 +16,9 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"math/big"
+	"path/filepath"
 	"testing"
 
 	"github.com/celo-org/celo-blockchain/common"
@@ -179,6 +181,77 @@ func TestGasPriceMinimum(t *testing.T) {
 
 }
 
+func TestGasPriceMinimumForCurrency(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	cUSD := common.HexToAddress("0x765d")
+	cEUR := common.HexToAddress("0xd876")
+
+	err = celoDb.ApplyChanges(ctx, &BlockChangeSet{
+		BlockNumber:     big.NewInt(10),
+		GasPriceMinimum: big.NewInt(50000),
+		CurrencyGasPriceMinimums: map[common.Address]*big.Int{
+			cUSD: big.NewInt(30000),
+			cEUR: big.NewInt(25000),
+		},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	err = celoDb.ApplyChanges(ctx, &BlockChangeSet{
+		BlockNumber: big.NewInt(15),
+		CurrencyGasPriceMinimums: map[common.Address]*big.Int{
+			cUSD: big.NewInt(60000),
+		},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	var gpm *big.Int
+
+	t.Run("Before", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, err = celoDb.GasPriceMinimumForCurrency(ctx, big.NewInt(10), cUSD)
+		Ω(err).Should(Equal(ErrGasPriceMinimumNotFound))
+	})
+
+	t.Run("Same Block", func(t *testing.T) {
+		RegisterTestingT(t)
+		gpm, err = celoDb.GasPriceMinimumForCurrency(ctx, big.NewInt(11), cUSD)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(gpm.Uint64()).Should(Equal(uint64(30000)))
+
+		gpm, err = celoDb.GasPriceMinimumForCurrency(ctx, big.NewInt(11), cEUR)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(gpm.Uint64()).Should(Equal(uint64(25000)))
+	})
+
+	t.Run("On Next Change", func(t *testing.T) {
+		RegisterTestingT(t)
+		gpm, err = celoDb.GasPriceMinimumForCurrency(ctx, big.NewInt(16), cUSD)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(gpm.Uint64()).Should(Equal(uint64(60000)))
+
+		gpm, err = celoDb.GasPriceMinimumForCurrency(ctx, big.NewInt(16), cEUR)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(gpm.Uint64()).Should(Equal(uint64(25000)))
+	})
+
+	t.Run("Unknown Currency", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, err = celoDb.GasPriceMinimumForCurrency(ctx, big.NewInt(16), common.HexToAddress("0x01"))
+		Ω(err).Should(Equal(ErrGasPriceMinimumNotFound))
+	})
+
+	t.Run("After Last Persisted Change", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, err = celoDb.GasPriceMinimumForCurrency(ctx, big.NewInt(17), cUSD)
+		Ω(err).Should(Equal(ErrFutureBlock))
+	})
+}
+
 func TestGasPriceMinimum_VeryLargeNumber(t *testing.T) {
 	RegisterTestingT(t)
 	ctx := context.Background()
@@ -276,3 +349,466 @@ func TestSetCarbonOffsetPartner(t *testing.T) {
 		Ω(err).Should(Equal(ErrFutureBlock))
 	})
 }
+
+func TestBlockOperations(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	blockHash := common.HexToHash("0xb1")
+	txHash := common.HexToHash("0x01")
+
+	lastIndexed, err := celoDb.LastIndexedBlock(ctx)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(lastIndexed.Int64()).Should(Equal(int64(0)))
+
+	err = celoDb.StoreBlockOperations(ctx, &BlockOperations{
+		BlockNumber:  big.NewInt(10),
+		BlockHash:    blockHash,
+		Version:      1,
+		Transactions: map[common.Hash][]byte{txHash: []byte("ops"), blockHash: []byte("rewards")},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	t.Run("Stored block", func(t *testing.T) {
+		RegisterTestingT(t)
+		lastIndexed, err := celoDb.LastIndexedBlock(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(lastIndexed.Int64()).Should(Equal(int64(10)))
+
+		hash, err := celoDb.IndexedBlockHash(ctx, big.NewInt(10), 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(hash).Should(Equal(blockHash))
+
+		ops, err := celoDb.TransactionOperations(ctx, blockHash, txHash, 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ops).Should(Equal([]byte("ops")))
+
+		ops, err = celoDb.TransactionOperations(ctx, blockHash, blockHash, 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ops).Should(Equal([]byte("rewards")))
+	})
+
+	t.Run("Missing records", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, err := celoDb.IndexedBlockHash(ctx, big.NewInt(9), 1)
+		Ω(err).Should(Equal(ErrOperationsNotFound))
+
+		_, err = celoDb.TransactionOperations(ctx, blockHash, common.HexToHash("0x02"), 1)
+		Ω(err).Should(Equal(ErrOperationsNotFound))
+	})
+
+	t.Run("Operations of another version", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, err := celoDb.IndexedBlockHash(ctx, big.NewInt(10), 2)
+		Ω(err).Should(Equal(ErrOperationsNotFound))
+
+		_, err = celoDb.TransactionOperations(ctx, blockHash, txHash, 2)
+		Ω(err).Should(Equal(ErrOperationsNotFound))
+	})
+
+	t.Run("Replaced block", func(t *testing.T) {
+		RegisterTestingT(t)
+		newBlockHash := common.HexToHash("0xb2")
+		err := celoDb.StoreBlockOperations(ctx, &BlockOperations{
+			BlockNumber:  big.NewInt(10),
+			BlockHash:    newBlockHash,
+			Version:      1,
+			Transactions: map[common.Hash][]byte{txHash: []byte("new ops")},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		hash, err := celoDb.IndexedBlockHash(ctx, big.NewInt(10), 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(hash).Should(Equal(newBlockHash))
+
+		_, err = celoDb.TransactionOperations(ctx, blockHash, txHash, 1)
+		Ω(err).Should(Equal(ErrOperationsNotFound))
+
+		ops, err := celoDb.TransactionOperations(ctx, newBlockHash, txHash, 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ops).Should(Equal([]byte("new ops")))
+	})
+}
+
+func TestSearchTransactions(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	alice := common.HexToAddress("0xa1")
+	bob := common.HexToAddress("0xb0")
+	tx1, tx2, tx3 := common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")
+
+	err = celoDb.StoreBlockOperations(ctx, &BlockOperations{
+		BlockNumber: big.NewInt(10),
+		BlockHash:   common.HexToHash("0xb10"),
+		Accounts: []AccountOperation{
+			{TxHash: tx1, Address: alice, Type: "transfer", Successful: true, Currency: "CELO"},
+			{TxHash: tx1, Address: bob, Type: "transfer", Successful: true, Currency: "CELO"},
+			{TxHash: tx1, Address: alice, Type: "fee", Successful: true, Currency: "CELO"},
+		},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	err = celoDb.StoreBlockOperations(ctx, &BlockOperations{
+		BlockNumber: big.NewInt(11),
+		BlockHash:   common.HexToHash("0xb11"),
+		Accounts: []AccountOperation{
+			{TxHash: tx2, Address: alice, SubAccount: "LockedGoldNonVoting", Type: "lock", Successful: true, Currency: "CELO"},
+			{TxHash: tx3, Address: bob, Type: "transfer", Successful: false, Currency: "cUSD"},
+		},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	search := func(query TransactionQuery) ([]common.Hash, int64) {
+		if query.MaxBlock == nil {
+			query.MaxBlock = big.NewInt(11)
+		}
+		if query.Limit == 0 {
+			query.Limit = 10
+		}
+		refs, total, err := celoDb.SearchTransactions(ctx, &query)
+		Ω(err).ShouldNot(HaveOccurred())
+		hashes := make([]common.Hash, len(refs))
+		for i, ref := range refs {
+			hashes[i] = ref.TxHash
+		}
+		return hashes, total
+	}
+
+	str := func(s string) *string { return &s }
+	boolean := func(b bool) *bool { return &b }
+
+	t.Run("By address", func(t *testing.T) {
+		RegisterTestingT(t)
+		hashes, total := search(TransactionQuery{Address: &alice})
+		Ω(hashes).Should(Equal([]common.Hash{tx2, tx1}))
+		Ω(total).Should(Equal(int64(2)))
+	})
+
+	t.Run("By main account", func(t *testing.T) {
+		RegisterTestingT(t)
+		hashes, _ := search(TransactionQuery{Address: &alice, SubAccount: str("")})
+		Ω(hashes).Should(Equal([]common.Hash{tx1}))
+	})
+
+	t.Run("By type, status and currency", func(t *testing.T) {
+		RegisterTestingT(t)
+		hashes, _ := search(TransactionQuery{Type: str("transfer"), Successful: boolean(false), Currency: str("cUSD")})
+		Ω(hashes).Should(Equal([]common.Hash{tx3}))
+	})
+
+	t.Run("Or operator", func(t *testing.T) {
+		RegisterTestingT(t)
+		hashes, _ := search(TransactionQuery{Or: true, Type: str("lock"), Currency: str("cUSD")})
+		Ω(hashes).Should(ConsistOf(tx2, tx3))
+	})
+
+	t.Run("Or operator with an account", func(t *testing.T) {
+		RegisterTestingT(t)
+		// carol has no operations, so carol's main account must not match the main account of others
+		carol := common.HexToAddress("0xc0")
+		hashes, _ := search(TransactionQuery{Or: true, Address: &carol, SubAccount: str(""), Type: str("lock")})
+		Ω(hashes).Should(Equal([]common.Hash{tx2}))
+
+		hashes, _ = search(TransactionQuery{Or: true, Address: &alice, SubAccount: str("LockedGoldNonVoting"), Currency: str("cUSD")})
+		Ω(hashes).Should(ConsistOf(tx2, tx3))
+	})
+
+	t.Run("Max block", func(t *testing.T) {
+		RegisterTestingT(t)
+		hashes, total := search(TransactionQuery{MaxBlock: big.NewInt(10)})
+		Ω(hashes).Should(Equal([]common.Hash{tx1}))
+		Ω(total).Should(Equal(int64(1)))
+	})
+
+	t.Run("Block range", func(t *testing.T) {
+		RegisterTestingT(t)
+		hashes, total := search(TransactionQuery{MinBlock: big.NewInt(11), Address: &bob})
+		Ω(hashes).Should(Equal([]common.Hash{tx3}))
+		Ω(total).Should(Equal(int64(1)))
+
+		hashes, _ = search(TransactionQuery{MinBlock: big.NewInt(11), MaxBlock: big.NewInt(10)})
+		Ω(hashes).Should(BeEmpty())
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		RegisterTestingT(t)
+		hashes, total := search(TransactionQuery{Limit: 2})
+		Ω(hashes).Should(Equal([]common.Hash{tx2, tx3}))
+		Ω(total).Should(Equal(int64(3)))
+
+		hashes, _ = search(TransactionQuery{Limit: 2, Offset: 2})
+		Ω(hashes).Should(Equal([]common.Hash{tx1}))
+	})
+}
+
+func TestBlockEvents(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	maxSequence, err := celoDb.MaxBlockEventSequence(ctx)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(maxSequence).Should(Equal(int64(-1)))
+
+	for i := int64(1); i <= 3; i++ {
+		err = celoDb.ApplyChanges(ctx, &BlockChangeSet{BlockNumber: big.NewInt(i), BlockHash: common.BigToHash(big.NewInt(i))})
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+	// Without hash there's no event
+	err = celoDb.ApplyChanges(ctx, &BlockChangeSet{BlockNumber: big.NewInt(4)})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	maxSequence, err = celoDb.MaxBlockEventSequence(ctx)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(maxSequence).Should(Equal(int64(2)))
+
+	events, err := celoDb.BlockEvents(ctx, 1, 10)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(events).Should(Equal([]BlockEvent{
+		{Sequence: 1, BlockNumber: big.NewInt(2), BlockHash: common.BigToHash(big.NewInt(2)), Type: BlockAdded},
+		{Sequence: 2, BlockNumber: big.NewInt(3), BlockHash: common.BigToHash(big.NewInt(3)), Type: BlockAdded},
+	}))
+
+	events, err = celoDb.BlockEvents(ctx, 0, 1)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(events).Should(HaveLen(1))
+	Ω(events[0].BlockNumber).Should(Equal(big.NewInt(1)))
+}
+
+func TestReorg(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	hashOf := func(block int64, fork byte) common.Hash {
+		return common.BytesToHash([]byte{fork, byte(block)})
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		err = celoDb.ApplyChanges(ctx, &BlockChangeSet{
+			BlockNumber:     big.NewInt(i),
+			BlockHash:       hashOf(i, 0),
+			GasPriceMinimum: big.NewInt(i),
+			RegistryChanges: []RegistryChange{
+				{TxIndex: 0, Contract: "Governance", NewAddress: common.BigToAddress(big.NewInt(i))},
+			},
+			CarbonOffsetPartnerChange: CarbonOffsetPartnerChange{TxIndex: 0, Address: common.BigToAddress(big.NewInt(i))},
+			GroupVoters:               []GroupVoterChange{{Group: common.HexToAddress("0x21"), Account: common.BigToAddress(big.NewInt(i))}},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = celoDb.StoreBlockOperations(ctx, &BlockOperations{
+			BlockNumber:  big.NewInt(i),
+			BlockHash:    hashOf(i, 0),
+			Transactions: map[common.Hash][]byte{common.BigToHash(big.NewInt(i)): []byte("[]")},
+			Accounts:     []AccountOperation{{TxHash: common.BigToHash(big.NewInt(i)), Address: common.HexToAddress("0x01")}},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	hash, err := celoDb.PersistedBlockHash(ctx, big.NewInt(3))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(hash).Should(Equal(hashOf(3, 0)))
+
+	// Block 2 is replaced by another one, without changes
+	err = celoDb.ApplyChanges(ctx, &BlockChangeSet{
+		BlockNumber: big.NewInt(2),
+		BlockHash:   hashOf(2, 1),
+		Reorg:       true,
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	lastBlock, err := celoDb.LastPersistedBlock(ctx)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(lastBlock).Should(Equal(big.NewInt(2)))
+
+	hash, err = celoDb.PersistedBlockHash(ctx, big.NewInt(2))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(hash).Should(Equal(hashOf(2, 1)))
+
+	_, err = celoDb.PersistedBlockHash(ctx, big.NewInt(3))
+	Ω(err).Should(Equal(ErrBlockHashNotFound))
+
+	t.Run("Rolls back contract state", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		gpm, err := celoDb.GasPriceMinimumFor(ctx, big.NewInt(3))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(gpm).Should(Equal(big.NewInt(1)))
+
+		addr, err := celoDb.RegistryAddressStartOf(ctx, big.NewInt(2), 1, "Governance")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(addr).Should(Equal(common.BigToAddress(big.NewInt(1))))
+
+		addr, err = celoDb.CarbonOffsetPartnerStartOf(ctx, big.NewInt(2), 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(addr).Should(Equal(common.BigToAddress(big.NewInt(1))))
+
+		voters, err := celoDb.GroupVoters(ctx, big.NewInt(2), common.HexToAddress("0x21"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(voters).Should(Equal([]common.Address{common.BigToAddress(big.NewInt(1))}))
+	})
+
+	t.Run("Rolls back operations", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		lastIndexed, err := celoDb.LastIndexedBlock(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(lastIndexed).Should(Equal(big.NewInt(1)))
+
+		_, err = celoDb.TransactionOperations(ctx, hashOf(2, 0), common.BigToHash(big.NewInt(2)), 0)
+		Ω(err).Should(Equal(ErrOperationsNotFound))
+
+		_, total, err := celoDb.SearchTransactions(ctx, &TransactionQuery{MaxBlock: big.NewInt(3), Limit: 10})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(total).Should(Equal(int64(1)))
+	})
+
+	t.Run("Rejects operations of rolled back blocks", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		// Traced before the reorg, stored after it
+		for _, block := range []int64{2, 3} {
+			err := celoDb.StoreBlockOperations(ctx, &BlockOperations{BlockNumber: big.NewInt(block), BlockHash: hashOf(block, 0)})
+			Ω(err).Should(Equal(ErrBlockNotPersisted))
+		}
+
+		lastIndexed, err := celoDb.LastIndexedBlock(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(lastIndexed).Should(Equal(big.NewInt(1)))
+
+		err = celoDb.StoreBlockOperations(ctx, &BlockOperations{BlockNumber: big.NewInt(2), BlockHash: hashOf(2, 1)})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("Appends removed events", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		events, err := celoDb.BlockEvents(ctx, 3, 10)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(events).Should(Equal([]BlockEvent{
+			{Sequence: 3, BlockNumber: big.NewInt(3), BlockHash: hashOf(3, 0), Type: BlockRemoved},
+			{Sequence: 4, BlockNumber: big.NewInt(2), BlockHash: hashOf(2, 0), Type: BlockRemoved},
+			{Sequence: 5, BlockNumber: big.NewInt(2), BlockHash: hashOf(2, 1), Type: BlockAdded},
+		}))
+	})
+}
+
+func TestGroupVoters(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+	group := common.HexToAddress("0x21")
+	voter1, voter2 := common.HexToAddress("0x31"), common.HexToAddress("0x32")
+
+	t.Run("Tracked from the start", func(t *testing.T) {
+		RegisterTestingT(t)
+		celoDb, err := NewSqliteDb(":memory:")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		from, err := celoDb.GroupVotersFrom(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(from.Sign()).Should(BeZero())
+
+		err = celoDb.ApplyChanges(ctx, &BlockChangeSet{BlockNumber: big.NewInt(1), GroupVoters: []GroupVoterChange{{Group: group, Account: voter1}}})
+		Ω(err).ShouldNot(HaveOccurred())
+		voters, err := celoDb.GroupVoters(ctx, big.NewInt(1), group)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(voters).Should(Equal([]common.Address{voter1}))
+	})
+
+	t.Run("Backfilled after an upgrade", func(t *testing.T) {
+		RegisterTestingT(t)
+		dbPath := filepath.Join(t.TempDir(), "rosetta.db")
+		celoDb, err := NewSqliteDb(dbPath)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		// A db persisted up to block 10 before voters were tracked
+		err = celoDb.ApplyChanges(ctx, &BlockChangeSet{BlockNumber: big.NewInt(10)})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = celoDb.db.Exec("DROP TABLE groupVotersCoverage")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(celoDb.Close()).Should(Succeed())
+
+		celoDb, err = NewSqliteDb(dbPath)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer celoDb.Close()
+
+		from, err := celoDb.GroupVotersFrom(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(from.Int64()).Should(Equal(int64(11)))
+		_, err = celoDb.GroupVoters(ctx, big.NewInt(10), group)
+		Ω(err).Should(MatchError(ErrGroupVotersIncomplete))
+
+		err = celoDb.ApplyChanges(ctx, &BlockChangeSet{
+			BlockNumber: big.NewInt(11),
+			GroupVoters: []GroupVoterChange{
+				{Group: group, Account: voter1, FromBlock: big.NewInt(5)},
+				{Group: group, Account: voter2, FromBlock: big.NewInt(8)},
+				{Group: group, Account: voter1},
+			},
+			GroupVotersBackfilled: true,
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		from, err = celoDb.GroupVotersFrom(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(from.Sign()).Should(BeZero())
+
+		// The earliest vote of each voter is kept
+		voters, err := celoDb.GroupVoters(ctx, big.NewInt(5), group)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(voters).Should(Equal([]common.Address{voter1}))
+		voters, err = celoDb.GroupVoters(ctx, big.NewInt(11), group)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(voters).Should(Equal([]common.Address{voter1, voter2}))
+	})
+}
+
+func TestIndexedBlocksMigration(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "rosetta.db")
+
+	// Blocks indexed before operations were versioned
+	oldDb, err := sql.Open("sqlite3", dbPath)
+	Ω(err).ShouldNot(HaveOccurred())
+	blockHash, txHash := common.HexToHash("0xb1"), common.HexToHash("0x01")
+	for _, statement := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{"CREATE table indexedBlocks (blockNumber integer PRIMARY KEY, blockHash blob)", nil},
+		{"CREATE table operations (blockNumber integer, blockHash blob, txHash blob, ops blob)", nil},
+		{"INSERT INTO indexedBlocks (blockNumber, blockHash) VALUES (10, ?)", []interface{}{blockHash}},
+		{"INSERT INTO operations (blockNumber, blockHash, txHash, ops) VALUES (10, ?, ?, 'ops')", []interface{}{blockHash, txHash}},
+	} {
+		_, err := oldDb.Exec(statement.sql, statement.args...)
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+	Ω(oldDb.Close()).Should(Succeed())
+
+	celoDb, err := NewSqliteDb(dbPath)
+	Ω(err).ShouldNot(HaveOccurred())
+	defer celoDb.Close()
+
+	lastIndexed, err := celoDb.LastIndexedBlock(ctx)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(lastIndexed.Int64()).Should(Equal(int64(10)))
+
+	_, err = celoDb.IndexedBlockHash(ctx, big.NewInt(10), 1)
+	Ω(err).Should(Equal(ErrOperationsNotFound))
+	ops, err := celoDb.TransactionOperations(ctx, blockHash, txHash, 0)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(ops).Should(Equal([]byte("ops")))
+}
@@ -23,8 +23,13 @@ import (
 )
 
 var (
-	ErrContractNotFound = errors.New("db: contract record not found")
-	ErrFutureBlock      = errors.New("db: block number is greater than last persisted block")
+	ErrContractNotFound        = errors.New("db: contract record not found")
+	ErrFutureBlock             = errors.New("db: block number is greater than last persisted block")
+	ErrGasPriceMinimumNotFound = errors.New("db: gas price minimum record not found")
+	ErrOperationsNotFound      = errors.New("db: operations record not found")
+	ErrBlockHashNotFound       = errors.New("db: block hash record not found")
+	ErrBlockNotPersisted       = errors.New("db: block is not the persisted one for its number")
+	ErrGroupVotersIncomplete   = errors.New("db: group voters of the blocks before they were tracked are not backfilled")
 )
 
 type RosettaDBReader interface {
@@ -38,6 +43,10 @@ type RosettaDBReader interface {
 	// in the following block.
 	GasPriceMinimumFor(ctx context.Context, block *big.Int) (*big.Int, error)
 
+	// GasPriceMinimumForCurrency reads the gpm in the given fee currency that was used FOR the specified block.
+	// In case there's no record for that currency it will fail with ErrGasPriceMinimumNotFound
+	GasPriceMinimumForCurrency(ctx context.Context, block *big.Int, currency common.Address) (*big.Int, error)
+
 	// RegistryAddressStartOf returns the address of the contract at the start of (block, tx)
 	// In case there's no record for that contract it will fail with ErrContractNotFound
 	RegistryAddressStartOf(ctx context.Context, block *big.Int, txIndex uint, contractName string) (common.Address, error)
@@ -49,10 +58,46 @@ type RosettaDBReader interface {
 	// CarbonOffsetPartnerStartOf returns the address of the contract at the start of (block, tx)
 	// In case of no value, will return with fallbackValue which is common.ZeroAddress
 	CarbonOffsetPartnerStartOf(ctx context.Context, block *big.Int, txIndex uint) (common.Address, error)
+
+	// PersistedBlockHash returns the hash of the persisted block with that number
+	// In case there's no record (blocks persisted before hashes were stored) it will fail with ErrBlockHashNotFound
+	PersistedBlockHash(ctx context.Context, block *big.Int) (common.Hash, error)
+
+	// GroupVoters returns the accounts that voted for the group up to the end of the block,
+	// whether or not they still have votes
+	// In case the voters of the blocks before GroupVotersFrom are not backfilled it will fail with ErrGroupVotersIncomplete
+	GroupVoters(ctx context.Context, block *big.Int, group common.Address) ([]common.Address, error)
+
+	// GroupVotersFrom returns the first block whose group voters were tracked when processed
+	// It's 0 once the voters of every block are stored
+	GroupVotersFrom(ctx context.Context) (*big.Int, error)
+
+	// LastIndexedBlock returns the highest block whose operations were stored
+	// In case of no block, it will return 0
+	LastIndexedBlock(ctx context.Context) (*big.Int, error)
+
+	// IndexedBlockHash returns the hash of the block whose operations were stored for that number
+	// In case the block was not indexed, or its operations are of another version, it will fail with ErrOperationsNotFound
+	IndexedBlockHash(ctx context.Context, block *big.Int, version uint) (common.Hash, error)
+
+	// TransactionOperations returns the encoded operations of a tx (or epoch rewards pseudo-tx) of an indexed block
+	// In case there's no record, or the operations are of another version, it will fail with ErrOperationsNotFound
+	TransactionOperations(ctx context.Context, blockHash common.Hash, txHash common.Hash, version uint) ([]byte, error)
+
+	// BlockEvents returns the block events with sequence in [offset, offset + limit)
+	BlockEvents(ctx context.Context, offset int64, limit int64) ([]BlockEvent, error)
+
+	// MaxBlockEventSequence returns the sequence of the last block event
+	// In case of no event, it will return -1
+	MaxBlockEventSequence(ctx context.Context) (int64, error)
+
+	// SearchTransactions returns the indexed txs matching the query, most recent first, and the total count of matches
+	SearchTransactions(ctx context.Context, query *TransactionQuery) ([]TransactionRef, int64, error)
 }
 
 type RosettaDBWriter interface {
 	ApplyChanges(ctx context.Context, changeSet *BlockChangeSet) error
+	StoreBlockOperations(ctx context.Context, blockOps *BlockOperations) error
 }
 
 type RosettaDB interface {
@@ -71,9 +116,77 @@ type CarbonOffsetPartnerChange struct {
 	Address common.Address
 }
 
+type GroupVoterChange struct {
+	Group     common.Address
+	Account   common.Address
+	FromBlock *big.Int // The block of the vote when backfilled, otherwise the change set's block
+}
+
 type BlockChangeSet struct {
 	BlockNumber               *big.Int
+	BlockHash                 common.Hash // If set, the hash is stored and a BlockAdded event is appended
+	Reorg                     bool        // If set, the persisted blocks from BlockNumber onwards are rolled back first
 	GasPriceMinimum           *big.Int
+	CurrencyGasPriceMinimums  map[common.Address]*big.Int
 	RegistryChanges           []RegistryChange
 	CarbonOffsetPartnerChange CarbonOffsetPartnerChange
+	GroupVoters               []GroupVoterChange // Accounts that voted for a group in the block
+	GroupVotersBackfilled     bool               // If set, GroupVoters include the votes of every block before GroupVotersFrom
+}
+
+// BlockOperations are the encoded operations of every tx of a block, by tx hash,
+// and the accounts they touch
+type BlockOperations struct {
+	BlockNumber  *big.Int
+	BlockHash    common.Hash
+	Version      uint // Version of the analyzer operations, the ones of other versions are indexed again
+	Transactions map[common.Hash][]byte
+	Accounts     []AccountOperation
+}
+
+// AccountOperation is an entry of the account index: an operation of TxHash changing the balance of an account
+type AccountOperation struct {
+	TxHash     common.Hash
+	Address    common.Address
+	SubAccount string // Empty for the main account
+	Type       string
+	Successful bool
+	Currency   string
+}
+
+// TransactionQuery filters the account index, nil fields match any value.
+// Conditions are combined with AND, or with OR if Or is set, where Address and SubAccount form a single
+// condition on the account; the block range (MinBlock, if set, to MaxBlock) always applies.
+type TransactionQuery struct {
+	Or         bool
+	MinBlock   *big.Int
+	MaxBlock   *big.Int
+	Offset     int64
+	Limit      int64
+	TxHash     *common.Hash
+	Address    *common.Address
+	SubAccount *string
+	Type       *string
+	Successful *bool
+	Currency   *string
+}
+
+type TransactionRef struct {
+	BlockNumber *big.Int
+	BlockHash   common.Hash
+	TxHash      common.Hash
+}
+
+// Types of BlockEvent
+const (
+	BlockAdded   = "block_added"
+	BlockRemoved = "block_removed"
+)
+
+// BlockEvent records a block being processed (BlockAdded) or rolled back (BlockRemoved)
+type BlockEvent struct {
+	Sequence    int64
+	BlockNumber *big.Int
+	BlockHash   common.Hash
+	Type        string
 }
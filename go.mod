@@ -13,10 +13,11 @@ require (
 	github.com/celo-org/celo-bls-go-other v0.6.4 // indirect
 	github.com/celo-org/celo-bls-go-windows v0.6.4 // indirect
 	github.com/celo-org/kliento v0.2.1-0.20230912125702-70113468d45f
-	github.com/coinbase/rosetta-sdk-go v0.5.9
+	github.com/coinbase/rosetta-sdk-go v0.6.10
 	github.com/felixge/httpsnoop v1.0.1
 	github.com/go-stack/stack v1.8.1 // indirect
 	github.com/gorilla/handlers v1.4.2
+	github.com/hashicorp/golang-lru v0.5.5-0.20210104140557-80c98217689d
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/onsi/gomega v1.10.1
 	github.com/rjeczalik/notify v0.9.2 // indirect
@@ -19,15 +19,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/celo-org/celo-blockchain/common/hexutil"
 	"github.com/celo-org/celo-blockchain/core"
 	"github.com/celo-org/celo-blockchain/log"
 	"github.com/celo-org/celo-blockchain/params"
+	"github.com/celo-org/kliento/client"
 	"github.com/celo-org/rosetta/internal/fileutils"
 	"github.com/celo-org/rosetta/service"
 )
@@ -54,23 +58,51 @@ type GethOpts struct {
 	MaxPeers    string
 }
 
+// probeInterval is the time between health probes of a running geth
+const probeInterval = 5 * time.Second
+
+// restartPolicy is the exponential wait between restarts of geth after it exits
+type restartPolicy struct {
+	// minBackoff is the wait before the first restart
+	minBackoff time.Duration
+	// maxBackoff bounds the wait between consecutive restarts
+	maxBackoff time.Duration
+	// stableRunPeriod is how long geth must run for the backoff to be reset
+	stableRunPeriod time.Duration
+}
+
+var defaultRestartPolicy = restartPolicy{
+	minBackoff:      1 * time.Second,
+	maxBackoff:      2 * time.Minute,
+	stableRunPeriod: 10 * time.Minute,
+}
+
 type gethService struct {
 	opts *GethOpts
 
 	chainParams *service.ChainParameters
 
 	cmd     *exec.Cmd
+	health  *service.NodeHealth
+	restart restartPolicy
 	running service.RunningLock
 	logger  log.Logger
 }
 
 func NewGethService(opts *GethOpts) *gethService {
 	return &gethService{
-		opts:   opts,
-		logger: log.New("service", "geth"),
+		opts:    opts,
+		health:  service.NewNodeHealth(),
+		restart: defaultRestartPolicy,
+		logger:  log.New("service", "geth"),
 	}
 }
 
+// Health is up while geth runs and answers the probes, see probe
+func (gs *gethService) Health() *service.NodeHealth {
+	return gs.health
+}
+
 func (gs *gethService) IpcFilePath() string {
 	return gs.opts.IpcFile()
 }
@@ -108,18 +140,9 @@ func (gs *gethService) Setup() error {
 		}
 	} else {
 		// Get chain params from blockchain client
-		switch gs.opts.Network {
-		case "mainnet":
-			config = params.MainnetChainConfig
-			break
-		case "alfajores":
-			config = params.AlfajoresChainConfig
-			break
-		case "baklava":
-			config = params.BaklavaChainConfig
-			break
-		default:
-			return fmt.Errorf("unknown network: %s", gs.opts.Network)
+		var err error
+		if config, err = networkChainConfig(gs.opts.Network); err != nil {
+			return err
 		}
 	}
 	gs.chainParams = service.NewChainParametersFromConfig(config)
@@ -146,20 +169,139 @@ func (gs *gethService) Start(ctx context.Context) error {
 	}
 	defer gethStderr.Close()
 
+	// Only the first start fails the service, as it's likely a configuration error
 	if err := gs.startGeth(gethStderr); err != nil {
 		return err
 	}
 
-	// Listen to stop signal, and kill the process
+	gs.supervise(ctx, func() error { return gs.startGeth(gethStderr) }, gs.waitGeth)
+	return nil
+}
+
+// supervise waits for the running geth to exit and starts it again, following the restart policy,
+// until the context is done. The node is down from the exit until the probes of the new geth succeed.
+func (gs *gethService) supervise(ctx context.Context, start func() error, wait func(context.Context) error) {
+	backoff := gs.restart.minBackoff
+	for {
+		startedAt := time.Now()
+		err := wait(ctx)
+		gs.health.SetDown()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(startedAt) > gs.restart.stableRunPeriod {
+			backoff = gs.restart.minBackoff
+		}
+		for {
+			gs.logger.Error("Geth exited, restarting", "err", err, "backoff", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = gs.restart.nextBackoff(backoff)
+
+			if err = start(); err == nil {
+				break
+			}
+		}
+	}
+}
+
+// nextBackoff doubles the backoff, up to maxBackoff
+func (p restartPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return backoff
+}
+
+// waitGeth waits for the started geth to exit, probing its health meanwhile,
+// and interrupts it when the context is done. It returns once the probes stopped,
+// so that they can't set the node up after it exited.
+func (gs *gethService) waitGeth(ctx context.Context) error {
+	cmd := gs.cmd
+	exited := make(chan struct{})
+
 	go func() {
-		<-ctx.Done()
-		if err := gs.cmd.Process.Signal(os.Interrupt); err != nil {
-			// Not much else to do. Failed to send a signal
-			fmt.Printf("Error sending close signal to geth: %v\n", err)
+		select {
+		case <-ctx.Done():
+			if err := cmd.Process.Signal(os.Interrupt); err != nil {
+				// Not much else to do. Failed to send a signal
+				fmt.Printf("Error sending close signal to geth: %v\n", err)
+			}
+		case <-exited:
 		}
 	}()
+	probed := make(chan struct{})
+	go func() {
+		defer close(probed)
+		gs.probe(ctx, exited)
+	}()
 
-	return gs.cmd.Wait()
+	err := cmd.Wait()
+	close(exited)
+	<-probed
+	return err
+}
+
+// probe sets the node health every probeInterval until geth exits: geth is up when it answers
+// eth_blockNumber and has peers (unless networking is disabled)
+func (gs *gethService) probe(ctx context.Context, exited <-chan struct{}) {
+	var cc *client.CeloClient
+	defer func() {
+		if cc != nil {
+			cc.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-exited:
+			return
+		case <-time.After(probeInterval):
+		}
+
+		if cc == nil && fileutils.FileExists(gs.IpcFilePath()) {
+			var err error
+			if cc, err = client.DialContext(ctx, gs.IpcFilePath()); err != nil {
+				gs.logger.Debug("Can't connect to geth", "err", err)
+				cc = nil
+			}
+		}
+		if cc == nil {
+			continue
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, probeInterval)
+		blockNumber, err := cc.Eth.BlockNumber(probeCtx)
+		var peerCount hexutil.Uint64
+		if err == nil {
+			err = cc.Rpc.CallContext(probeCtx, &peerCount, "net_peerCount")
+		}
+		cancel()
+
+		switch {
+		case err != nil:
+			if gs.health.Up() {
+				gs.logger.Warn("Geth is not responding", "err", err)
+			}
+			gs.health.SetDown()
+		case peerCount == 0 && gs.opts.MaxPeers != "0":
+			if gs.health.Up() {
+				gs.logger.Warn("Geth has no peers")
+			}
+			gs.health.SetDown()
+		default:
+			if !gs.health.Up() {
+				gs.logger.Info("Geth is ready", "blockNumber", blockNumber, "peers", uint64(peerCount))
+			}
+			gs.health.SetUp()
+		}
+	}
 }
 
 func (gs *gethService) gethCmd(args ...string) *exec.Cmd {
@@ -302,6 +444,41 @@ func (gopts GethOpts) StaticNodesFile() string {
 	return filepath.Join(gopts.Datadir, "/static-nodes.json")
 }
 
+func networkChainConfig(network string) (*params.ChainConfig, error) {
+	switch network {
+	case "mainnet":
+		return params.MainnetChainConfig, nil
+	case "alfajores":
+		return params.AlfajoresChainConfig, nil
+	case "baklava":
+		return params.BaklavaChainConfig, nil
+	default:
+		return nil, fmt.Errorf("unknown network: %s", network)
+	}
+}
+
+// ChainParametersFor returns the chain parameters of the genesis file if given, or else of the named network
+func ChainParametersFor(network string, genesisPath string) (*service.ChainParameters, error) {
+	if genesisPath != "" {
+		return service.NewChainParametersFromConfig(chainConfigFromGenesisFile(genesisPath)), nil
+	}
+	config, err := networkChainConfig(network)
+	if err != nil {
+		return nil, err
+	}
+	return service.NewChainParametersFromConfig(config), nil
+}
+
+// ChainParametersForChainId returns the chain parameters of the known network with the given chain id
+func ChainParametersForChainId(chainId *big.Int) (*service.ChainParameters, error) {
+	for _, config := range []*params.ChainConfig{params.MainnetChainConfig, params.AlfajoresChainConfig, params.BaklavaChainConfig} {
+		if config.ChainID.Cmp(chainId) == 0 {
+			return service.NewChainParametersFromConfig(config), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown chain id %s, a genesis file is needed", chainId)
+}
+
 func chainConfigFromGenesisFile(genesisPath string) *params.ChainConfig {
 	data, err := ioutil.ReadFile(genesisPath)
 	if err != nil {
@@ -0,0 +1,105 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/rosetta/service"
+	. "github.com/onsi/gomega"
+)
+
+func TestNextBackoff(t *testing.T) {
+	RegisterTestingT(t)
+
+	Ω(defaultRestartPolicy.nextBackoff(defaultRestartPolicy.minBackoff)).Should(Equal(2 * time.Second))
+	Ω(defaultRestartPolicy.nextBackoff(time.Minute)).Should(Equal(2 * time.Minute))
+	Ω(defaultRestartPolicy.nextBackoff(defaultRestartPolicy.maxBackoff)).Should(Equal(defaultRestartPolicy.maxBackoff))
+}
+
+func TestSupervise(t *testing.T) {
+	RegisterTestingT(t)
+
+	// The backoffs are read from the restart logs
+	backoffs := make(chan time.Duration, 100)
+	logger := log.New()
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if r.Ctx[i] == "backoff" {
+				backoffs <- r.Ctx[i+1].(time.Duration)
+			}
+		}
+		return nil
+	}))
+
+	gs := &gethService{
+		health: service.NewNodeHealth(),
+		restart: restartPolicy{
+			minBackoff:      time.Millisecond,
+			maxBackoff:      4 * time.Millisecond,
+			stableRunPeriod: 100 * time.Millisecond,
+		},
+		logger: logger,
+	}
+
+	// geth exits right away 4 times, then after a stable run, then once more before running until stopped
+	runs := []time.Duration{0, 0, 0, 0, 150 * time.Millisecond, 0}
+	var waits, starts int
+	var upAtStart []bool
+	start := func() error {
+		starts++
+		upAtStart = append(upAtStart, gs.health.Up())
+		if starts == 2 {
+			return errors.New("can't start")
+		}
+		gs.health.SetUp()
+		return nil
+	}
+	wait := func(ctx context.Context) error {
+		defer func() { waits++ }()
+		if waits < len(runs) {
+			time.Sleep(runs[waits])
+			return errors.New("exited")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	gs.health.SetUp()
+	go func() {
+		gs.supervise(ctx, start, wait)
+		close(done)
+	}()
+
+	for _, expected := range []time.Duration{1, 2, 4, 4, 4, 1, 2} {
+		Eventually(backoffs).Should(Receive(Equal(expected * time.Millisecond)))
+	}
+	Eventually(gs.health.Up).Should(BeTrue())
+	Consistently(backoffs, 50*time.Millisecond).ShouldNot(Receive())
+
+	cancel()
+	Eventually(done).Should(BeClosed())
+	Ω(gs.health.Up()).Should(BeFalse())
+	Ω(starts).Should(Equal(7))
+	// The node is down from every exit until the new geth is up
+	Ω(upAtStart).ShouldNot(ContainElement(BeTrue()))
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/celo-org/kliento/contracts"
 	"github.com/celo-org/kliento/registry"
 	"github.com/celo-org/rosetta/db"
+	"github.com/celo-org/rosetta/internal/utils"
 )
 
 //nolint:unused
@@ -35,15 +36,39 @@ type processor struct {
 	headers             <-chan *types.Header
 	changes             chan<- *db.BlockChangeSet
 	cc                  *client.CeloClient
+	db                  db.RosettaDBReader
 	registry            *contracts.Registry
 	epochRewardsAddress common.Address
+	electionAddress     common.Address
 	gpmAddress          common.Address
+	whitelistAddress    common.Address
 	reserveAddress      common.Address
 	gpm                 *big.Int
-	logger              log.Logger
+	currencyGpms        map[common.Address]*big.Int
+	// lastNumber and lastHash identify the last processed block, the next header must be its child.
+	// lastHash is empty when unknown, and then linkage is not checked.
+	lastNumber *big.Int
+	lastHash   common.Hash
+	// pending are the canonical headers to re-process after a reorg, before reading new ones
+	pending []*types.Header
+	// reorg is set when the next change set replaces already processed blocks
+	reorg bool
+	// votersBackfilled is set once the group voters of the blocks processed before they were tracked are stored
+	votersBackfilled bool
+	logger           log.Logger
 }
 
-var ErrMultipleGasPriceMinimumUpdates = errors.New("Error multiple GasPriceMinimumUpdated events emitted in same block")
+const (
+	// max numbers of blocks we walk back looking for the common ancestor of a reorg
+	maxReorgDepth = 1000
+	// groupVotersBackfillRange is the number of blocks whose votes are filtered at once by the backfill
+	groupVotersBackfillRange = 100000
+)
+
+var (
+	ErrMultipleGasPriceMinimumUpdates = errors.New("Error multiple GasPriceMinimumUpdated events emitted in same block")
+	ErrReorgTooDeep                   = errors.New("Error reorg deeper than the maximum allowed")
+)
 
 func BlockProcessor(ctx context.Context, headers <-chan *types.Header, changes chan<- *db.BlockChangeSet, cc *client.CeloClient, db_ db.RosettaDBReader, isGingerbread func(*big.Int) bool, logger log.Logger) error {
 	bp, err := newProcessor(ctx, headers, changes, cc, db_, logger)
@@ -75,6 +100,10 @@ func BlockProcessor(ctx context.Context, headers <-chan *types.Header, changes c
 			return err
 		}
 
+		if err := bp.groupVoters(bcs); err != nil {
+			return err
+		}
+
 		if err := bp.writeChanges(bcs); err != nil {
 			return err
 		}
@@ -92,35 +121,67 @@ func newProcessor(ctx context.Context, headers <-chan *types.Header, changes cha
 		return nil, err
 	}
 
-	epochRewardsAddress, err := db_.RegistryAddressStartOf(ctx, lastProcessedBlock, 0, "EpochRewards")
-	if err != nil && err != db.ErrContractNotFound {
+	bp := &processor{
+		ctx:      ctx,
+		headers:  headers,
+		changes:  changes,
+		cc:       cc,
+		db:       db_,
+		registry: registry,
+		logger:   logger.New("pipe", "processor"),
+	}
+
+	if err := bp.loadState(lastProcessedBlock); err != nil {
 		return nil, err
 	}
+	return bp, nil
+}
 
-	gpmAddress, err := db_.RegistryAddressStartOf(ctx, lastProcessedBlock, 0, "GasPriceMinimum")
+// loadState reads from the db the state of the processor at the end of lastProcessedBlock
+func (bp *processor) loadState(lastProcessedBlock *big.Int) error {
+	epochRewardsAddress, err := bp.db.RegistryAddressStartOf(bp.ctx, lastProcessedBlock, 0, "EpochRewards")
 	if err != nil && err != db.ErrContractNotFound {
-		return nil, err
+		return err
+	}
+
+	electionAddress, err := bp.db.RegistryAddressStartOf(bp.ctx, lastProcessedBlock, 0, "Election")
+	if err != nil && err != db.ErrContractNotFound {
+		return err
+	}
+
+	gpmAddress, err := bp.db.RegistryAddressStartOf(bp.ctx, lastProcessedBlock, 0, "GasPriceMinimum")
+	if err != nil && err != db.ErrContractNotFound {
+		return err
+	}
+
+	whitelistAddress, err := bp.db.RegistryAddressStartOf(bp.ctx, lastProcessedBlock, 0, "FeeCurrencyWhitelist")
+	if err != nil && err != db.ErrContractNotFound {
+		return err
 	}
 
 	// GasPriceMinimum is updated at the end of each block and applied to the following block.
 	// So, to get the gpm that was SET at the end of the lastProcessedBlock we query the gpm
 	// used FOR the next block.
-	gpm, err := db_.GasPriceMinimumFor(ctx, new(big.Int).Add(lastProcessedBlock, big.NewInt(1)))
+	gpm, err := bp.db.GasPriceMinimumFor(bp.ctx, new(big.Int).Add(lastProcessedBlock, big.NewInt(1)))
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	lastHash, err := bp.db.PersistedBlockHash(bp.ctx, lastProcessedBlock)
+	if err != nil && err != db.ErrBlockHashNotFound {
+		return err
 	}
 
-	return &processor{
-		ctx:                 ctx,
-		headers:             headers,
-		changes:             changes,
-		cc:                  cc,
-		registry:            registry,
-		epochRewardsAddress: epochRewardsAddress,
-		gpmAddress:          gpmAddress,
-		gpm:                 gpm,
-		logger:              logger.New("pipe", "processor"),
-	}, nil
+	bp.epochRewardsAddress = epochRewardsAddress
+	bp.electionAddress = electionAddress
+	bp.gpmAddress = gpmAddress
+	bp.whitelistAddress = whitelistAddress
+	bp.gpm = gpm
+	// Fee currency gpms are loaded lazily: after a restart the first update rewrites them all
+	bp.currencyGpms = make(map[common.Address]*big.Int)
+	bp.lastNumber = lastProcessedBlock
+	bp.lastHash = lastHash
+	return nil
 }
 
 func (bp *processor) writeChanges(bcs *db.BlockChangeSet) error {
@@ -133,6 +194,12 @@ func (bp *processor) writeChanges(bcs *db.BlockChangeSet) error {
 }
 
 func (bp *processor) nextHeader() (*types.Header, error) {
+	if len(bp.pending) > 0 {
+		h := bp.pending[0]
+		bp.pending = bp.pending[1:]
+		return h, nil
+	}
+
 	select {
 	case <-bp.ctx.Done():
 		return nil, bp.ctx.Err()
@@ -142,14 +209,86 @@ func (bp *processor) nextHeader() (*types.Header, error) {
 }
 
 func (bp *processor) initNextBlockChangeSet() (*db.BlockChangeSet, error) {
-	h, err := bp.nextHeader()
+	for {
+		h, err := bp.nextHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		if bp.lastHash != (common.Hash{}) && h.ParentHash != bp.lastHash {
+			if err := bp.handleReorg(h); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		bcs := &db.BlockChangeSet{
+			BlockNumber: h.Number,
+			BlockHash:   h.Hash(),
+			Reorg:       bp.reorg,
+		}
+		bp.reorg = false
+		bp.lastNumber = h.Number
+		bp.lastHash = h.Hash()
+		return bcs, nil
+	}
+}
+
+// handleReorg is called when h is not a child of the last processed block.
+// It finds the last processed block still in the node's canonical chain, restores the processor state
+// at that block, and queues the canonical headers from there up to h for processing.
+func (bp *processor) handleReorg(h *types.Header) error {
+	ancestor, err := bp.commonAncestor()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	bp.logger.Warn("Reorg detected", "block", h.Number, "parentHash", h.ParentHash.Hex(), "lastHash", bp.lastHash.Hex(), "ancestor", ancestor)
+
+	// When the last processed block is still canonical, only h is stale
+	if ancestor.Cmp(bp.lastNumber) < 0 {
+		// Blocks up to the ancestor are already persisted, as the last processed block was received by the persister
+		if err := bp.loadState(ancestor); err != nil {
+			return err
+		}
+		bp.reorg = true
+	}
+
+	pending := make([]*types.Header, 0, new(big.Int).Sub(h.Number, ancestor).Int64())
+	for curr := utils.Inc(ancestor); curr.Cmp(h.Number) <= 0; curr = utils.Inc(curr) {
+		header, err := bp.cc.Eth.HeaderByNumber(bp.ctx, curr)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, header)
 	}
+	bp.pending = append(pending, bp.pending...)
+	return nil
+}
 
-	return &db.BlockChangeSet{
-		BlockNumber: h.Number,
-	}, nil
+// commonAncestor returns the most recent processed block that is still in the node's canonical chain
+func (bp *processor) commonAncestor() (*big.Int, error) {
+	for depth, curr := 0, bp.lastNumber; depth <= maxReorgDepth && curr.Sign() >= 0; depth, curr = depth+1, utils.Dec(curr) {
+		hash := bp.lastHash
+		if depth > 0 {
+			var err error
+			hash, err = bp.db.PersistedBlockHash(bp.ctx, curr)
+			// Blocks persisted before hashes were stored can't be checked
+			if err == db.ErrBlockHashNotFound {
+				return curr, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		header, err := bp.cc.Eth.HeaderByNumber(bp.ctx, curr)
+		if err != nil {
+			return nil, err
+		}
+		if header.Hash() == hash {
+			return curr, nil
+		}
+	}
+	return nil, ErrReorgTooDeep
 }
 
 func (bp *processor) registryChanges(bcs *db.BlockChangeSet) error {
@@ -170,9 +309,15 @@ func (bp *processor) registryChanges(bcs *db.BlockChangeSet) error {
 		if iter.Event.Identifier == "GasPriceMinimum" {
 			bp.gpmAddress = iter.Event.Addr
 		}
+		if iter.Event.Identifier == "FeeCurrencyWhitelist" {
+			bp.whitelistAddress = iter.Event.Addr
+		}
 		if iter.Event.Identifier == "EpochRewards" {
 			bp.epochRewardsAddress = iter.Event.Addr
 		}
+		if iter.Event.Identifier == "Election" {
+			bp.electionAddress = iter.Event.Addr
+		}
 		registryChanges = append(registryChanges, db.RegistryChange{
 			TxIndex:    iter.Event.Raw.TxIndex,
 			Contract:   iter.Event.Identifier,
@@ -211,6 +356,7 @@ func (bp *processor) gasPriceMinimum(bcs *db.BlockChangeSet) error {
 	}
 
 	// iter should only have 1 event, as gpm can only be updated once per block.
+	updated := false
 	for multipleUpdates := false; iter.Next(); {
 		if multipleUpdates {
 			return ErrMultipleGasPriceMinimumUpdates
@@ -224,12 +370,55 @@ func (bp *processor) gasPriceMinimum(bcs *db.BlockChangeSet) error {
 			bp.gpm = new(big.Int).Set(gpmNew)
 		}
 		multipleUpdates = true
+		updated = true
 	}
 
 	if err := iter.Error(); err != nil {
 		return err
 	}
 
+	if updated {
+		return bp.currencyGasPriceMinimums(bcs, gpmContract)
+	}
+
+	return nil
+}
+
+// currencyGasPriceMinimums records the gpm of every whitelisted fee currency after a GasPriceMinimumUpdated event
+func (bp *processor) currencyGasPriceMinimums(bcs *db.BlockChangeSet, gpmContract *contracts.GasPriceMinimum) error {
+	if bp.whitelistAddress == common.ZeroAddress {
+		return nil
+	}
+
+	opts := &bind.CallOpts{BlockNumber: bcs.BlockNumber, Context: bp.ctx}
+
+	whitelist, err := contracts.NewFeeCurrencyWhitelist(bp.whitelistAddress, bp.cc.Eth)
+	if err != nil {
+		return err
+	}
+
+	feeCurrencies, err := whitelist.GetWhitelist(opts)
+	if err != nil {
+		return err
+	}
+
+	for _, currency := range feeCurrencies {
+		gpmNew, err := gpmContract.GetGasPriceMinimum(opts, currency)
+		if err != nil {
+			return err
+		}
+
+		if gpm, ok := bp.currencyGpms[currency]; ok && gpm.Cmp(gpmNew) == 0 {
+			continue
+		}
+
+		if bcs.CurrencyGasPriceMinimums == nil {
+			bcs.CurrencyGasPriceMinimums = make(map[common.Address]*big.Int)
+		}
+		bcs.CurrencyGasPriceMinimums[currency] = new(big.Int).Set(gpmNew)
+		bp.currencyGpms[currency] = new(big.Int).Set(gpmNew)
+	}
+
 	return nil
 }
 
@@ -268,3 +457,95 @@ func (bp *processor) carbonOffsetPartner(bcs *db.BlockChangeSet) error {
 
 	return nil
 }
+
+// groupVoters records the accounts that voted for a group, which share the group's epoch voter rewards once activated
+func (bp *processor) groupVoters(bcs *db.BlockChangeSet) error {
+	if !bp.votersBackfilled {
+		if err := bp.backfillGroupVoters(bcs); err != nil {
+			return err
+		}
+	}
+
+	if bp.electionAddress == common.ZeroAddress {
+		return nil
+	}
+
+	blockNumber := bcs.BlockNumber.Uint64()
+
+	election, err := contracts.NewElection(bp.electionAddress, bp.cc.Eth)
+	if err != nil {
+		return err
+	}
+
+	iter, err := election.FilterValidatorGroupVoteCast(&bind.FilterOpts{
+		End:     &blockNumber,
+		Start:   blockNumber,
+		Context: bp.ctx,
+	}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for iter.Next() {
+		bcs.GroupVoters = append(bcs.GroupVoters, db.GroupVoterChange{
+			Group:   iter.Event.Group,
+			Account: iter.Event.Account,
+		})
+	}
+
+	return iter.Error()
+}
+
+// backfillGroupVoters adds to the first change set the voters of the blocks processed before they were tracked
+// (by a db created by a previous version), so that the voters of every block are stored when it's applied
+func (bp *processor) backfillGroupVoters(bcs *db.BlockChangeSet) error {
+	from, err := bp.db.GroupVotersFrom(bp.ctx)
+	if err != nil {
+		return err
+	}
+	if from.Sign() == 0 || bp.electionAddress == common.ZeroAddress {
+		// Without Election there are no votes before
+		bcs.GroupVotersBackfilled = from.Sign() > 0
+		bp.votersBackfilled = true
+		return nil
+	}
+	bp.logger.Info("Backfilling group voters", "to", utils.Dec(from))
+
+	election, err := contracts.NewElection(bp.electionAddress, bp.cc.Eth)
+	if err != nil {
+		return err
+	}
+
+	last := from.Uint64() - 1
+	for start := uint64(0); start <= last; start += groupVotersBackfillRange {
+		end := start + groupVotersBackfillRange - 1
+		if end > last {
+			end = last
+		}
+
+		iter, err := election.FilterValidatorGroupVoteCast(&bind.FilterOpts{
+			End:     &end,
+			Start:   start,
+			Context: bp.ctx,
+		}, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		for iter.Next() {
+			bcs.GroupVoters = append(bcs.GroupVoters, db.GroupVoterChange{
+				Group:     iter.Event.Group,
+				Account:   iter.Event.Account,
+				FromBlock: new(big.Int).SetUint64(iter.Event.Raw.BlockNumber),
+			})
+		}
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+
+	bp.logger.Info("Backfilled group voters", "votes", len(bcs.GroupVoters))
+	bcs.GroupVotersBackfilled = true
+	bp.votersBackfilled = true
+	return nil
+}
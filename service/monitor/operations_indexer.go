@@ -0,0 +1,169 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/kliento/client"
+	"github.com/celo-org/rosetta/analyzer"
+	"github.com/celo-org/rosetta/db"
+	"github.com/celo-org/rosetta/internal/utils"
+	"github.com/celo-org/rosetta/service"
+)
+
+const (
+	// indexAttempts is how many times a block is indexed before it's skipped
+	indexAttempts = 5
+	// minIndexRetryBackoff is the wait before indexing a block again after the first failure
+	minIndexRetryBackoff = 1 * time.Second
+	// maxIndexRetryBackoff bounds the exponential wait between attempts
+	maxIndexRetryBackoff = 1 * time.Minute
+)
+
+// OperationsIndexer traces the blocks persisted by ProcessChanges and stores their operations.
+// When nothing was indexed yet, it starts from the first block it is notified about: history is filled in by backfill.
+// Blocks rolled back by a reorg are indexed again from the rolled back height. Blocks that keep failing
+// are skipped rather than stopping the monitor (see indexBlockWithRetries).
+func OperationsIndexer(ctx context.Context, persisted <-chan *PersistedBlocks, cc *client.CeloClient, db_ db.RosettaDB, chainParams *service.ChainParameters, traceTimeout time.Duration, logger log.Logger) error {
+	logger = logger.New("pipe", "ops_indexer")
+
+	lastIndexed, err := db_.LastIndexedBlock(ctx)
+	if err != nil {
+		return err
+	}
+	var nextBlock *big.Int
+	if lastIndexed.Sign() > 0 {
+		nextBlock = utils.Inc(lastIndexed)
+	}
+
+	var count uint
+	for {
+		var notification *PersistedBlocks
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case notification = <-persisted:
+		}
+
+		if nextBlock == nil {
+			nextBlock = new(big.Int).Set(notification.Last)
+			logger.Info("Indexing operations from block", "block", nextBlock)
+		} else if rolledBack := notification.RolledBackFrom; rolledBack != nil && rolledBack.Cmp(nextBlock) < 0 {
+			logger.Info("Indexing operations again after a reorg", "block", rolledBack)
+			nextBlock = new(big.Int).Set(rolledBack)
+		}
+
+		for ; nextBlock.Cmp(notification.Last) <= 0; nextBlock = utils.Inc(nextBlock) {
+			err := indexBlockWithRetries(ctx, cc, db_, chainParams, traceTimeout, nextBlock, logger)
+			if errors.Is(err, db.ErrBlockNotPersisted) {
+				// Replaced by a reorg meanwhile, the next notification has the rolled back height
+				break
+			}
+			if errors.Is(err, db.ErrGroupVotersIncomplete) {
+				// The voter rewards can't be computed until the voters are backfilled with the next persisted block
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			count++
+			if count == 1000 {
+				count = 0
+				logger.Info("Indexed 1000 blocks", "block", nextBlock)
+			}
+		}
+	}
+}
+
+// indexBlockWithRetries indexes the block, retrying with backoff. After indexAttempts failures the block is
+// skipped: it's left without an indexedBlocks record, so it's still traced live when served and backfill fills it in.
+// ErrBlockNotPersisted is returned without retrying, as the block was replaced by a reorg, and so is
+// ErrGroupVotersIncomplete, as the voters are backfilled along with the next persisted block.
+func indexBlockWithRetries(ctx context.Context, cc *client.CeloClient, db_ db.RosettaDB, chainParams *service.ChainParameters, traceTimeout time.Duration, blockNumber *big.Int, logger log.Logger) error {
+	backoff := minIndexRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := IndexBlockOperations(ctx, cc, db_, chainParams, traceTimeout, blockNumber)
+		if err == nil || errors.Is(err, db.ErrBlockNotPersisted) || errors.Is(err, db.ErrGroupVotersIncomplete) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if attempt == indexAttempts {
+			logger.Error("Skipping block, its operations can be indexed by backfill", "block", blockNumber, "err", err)
+			return nil
+		}
+		logger.Warn("Can't index block, retrying", "block", blockNumber, "attempt", attempt, "err", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxIndexRetryBackoff {
+			backoff = maxIndexRetryBackoff
+		}
+	}
+}
+
+// IndexBlockOperations traces the block and stores the operations of its txs, and of the epoch rewards pseudo-tx,
+// along with the account index entries
+// The block must have been persisted by the monitor, as tracing reads the registry state from the db.
+func IndexBlockOperations(ctx context.Context, cc *client.CeloClient, db_ db.RosettaDB, chainParams *service.ChainParameters, traceTimeout time.Duration, blockNumber *big.Int) error {
+	block, err := cc.Eth.BlockByNumber(ctx, blockNumber)
+	if err != nil {
+		return fmt.Errorf("can't get block %s: %w", blockNumber, err)
+	}
+
+	tracer := analyzer.NewTracer(ctx, cc, db_, traceTimeout, chainParams.IsGingerbread(blockNumber))
+	ops, err := tracer.TraceBlock(block)
+	if err != nil {
+		return fmt.Errorf("can't trace block %s: %w", blockNumber, err)
+	}
+
+	if chainParams.IsLastBlockOfEpoch(blockNumber.Uint64()) {
+		rewards, err := analyzer.ComputeEpochRewards(ctx, cc, db_, block.Header())
+		if err != nil {
+			return fmt.Errorf("can't compute epoch rewards for block %s: %w", blockNumber, err)
+		}
+		ops[block.Hash()] = rewards
+	}
+
+	blockOps := &db.BlockOperations{
+		BlockNumber:  block.Number(),
+		BlockHash:    block.Hash(),
+		Version:      analyzer.OperationsVersion,
+		Transactions: make(map[common.Hash][]byte, len(ops)),
+	}
+	for txHash, txOps := range ops {
+		encoded, err := analyzer.EncodeOperations(txOps)
+		if err != nil {
+			return err
+		}
+		blockOps.Transactions[txHash] = encoded
+		blockOps.Accounts = append(blockOps.Accounts, analyzer.AccountOperations(txHash, txOps)...)
+	}
+
+	return db_.StoreBlockOperations(ctx, blockOps)
+}
@@ -17,6 +17,7 @@ package monitor
 import (
 	"context"
 	"math/big"
+	"time"
 
 	"github.com/celo-org/celo-blockchain/core/types"
 	"github.com/celo-org/celo-blockchain/log"
@@ -32,19 +33,32 @@ type monitorService struct {
 	cc            *client.CeloClient
 	db            db.RosettaDB
 	logger        log.Logger
-	isGingerbread func(*big.Int) bool
+	chainParams   *service.ChainParameters
 	initContracts bool // Necessary for running with mycelo testnets
+	// indexOperations enables the stage that stores the operations of each new block
+	indexOperations bool
+	traceTimeout    time.Duration
+	// nodeHealth pauses the monitor while the node is down, nil if the node isn't supervised
+	nodeHealth *service.NodeHealth
 }
 
-const srvName = "celo-monitor"
+const (
+	srvName = "celo-monitor"
 
-func NewMonitorService(cc *client.CeloClient, db db.RosettaDB, isGingerbread func(*big.Int) bool, initContracts bool) *monitorService {
+	// nodeDownGracePeriod is how long to wait after a failure for the node health to report a downtime
+	nodeDownGracePeriod = 10 * time.Second
+)
+
+func NewMonitorService(cc *client.CeloClient, db db.RosettaDB, chainParams *service.ChainParameters, initContracts bool, indexOperations bool, traceTimeout time.Duration, nodeHealth *service.NodeHealth) *monitorService {
 	return &monitorService{
-		cc:            cc,
-		db:            db,
-		logger:        log.New("srv", srvName),
-		isGingerbread: isGingerbread,
-		initContracts: initContracts,
+		cc:              cc,
+		db:              db,
+		logger:          log.New("srv", srvName),
+		chainParams:     chainParams,
+		initContracts:   initContracts,
+		indexOperations: indexOperations,
+		traceTimeout:    traceTimeout,
+		nodeHealth:      nodeHealth,
 	}
 }
 
@@ -67,6 +81,53 @@ func (ms *monitorService) Start(ctx context.Context) error {
 	}
 	defer ms.running.Disable()
 
+	return ms.runWhileNodeUp(ctx, ms.run)
+}
+
+// runWhileNodeUp runs the pipeline once the node is up. If it fails because the node went down,
+// it's paused until the node is back and then resumed; any other failure is returned.
+func (ms *monitorService) runWhileNodeUp(ctx context.Context, run func(context.Context) error) error {
+	for {
+		if err := ms.nodeHealth.WaitUp(ctx); err != nil {
+			return nil
+		}
+
+		downs := ms.nodeHealth.Downs()
+		err := run(ctx)
+		if err == nil || err == context.Canceled || ctx.Err() != nil {
+			return nil
+		}
+		if !ms.nodeWentDown(ctx, downs) {
+			return err
+		}
+		ms.logger.Warn("Node is down, pausing until it's back", "err", err)
+	}
+}
+
+// nodeWentDown returns whether the node went down since the given number of downs,
+// waiting for nodeDownGracePeriod for the node health to catch up with a failure
+func (ms *monitorService) nodeWentDown(ctx context.Context, downs uint64) bool {
+	if ms.nodeHealth == nil {
+		return false
+	}
+
+	deadline := time.After(nodeDownGracePeriod)
+	for {
+		if !ms.nodeHealth.Up() || ms.nodeHealth.Downs() != downs {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline:
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// run runs the monitor pipeline from the last persisted block, until it fails
+func (ms *monitorService) run(ctx context.Context) error {
 	startBlock, err := ms.db.LastPersistedBlock(ctx)
 	if err != nil {
 		return err
@@ -90,12 +151,16 @@ func (ms *monitorService) Start(ctx context.Context) error {
 	group, ctx := errgroup.WithContext(ctx)
 	group.Go(func() error { return HeaderListener(ctx, headerCh, ms.cc, ms.logger, startBlock) })
 	group.Go(func() error {
-		return BlockProcessor(ctx, headerCh, changeSetsCh, ms.cc, ms.db, ms.isGingerbread, ms.logger)
+		return BlockProcessor(ctx, headerCh, changeSetsCh, ms.cc, ms.db, ms.chainParams.IsGingerbread, ms.logger)
 	})
-	group.Go(func() error { return ProcessChanges(ctx, changeSetsCh, ms.db, ms.logger) })
-	err = group.Wait()
-	if err == context.Canceled {
-		return nil
+
+	var persistedCh chan *PersistedBlocks
+	if ms.indexOperations {
+		persistedCh = make(chan *PersistedBlocks, 1)
+		group.Go(func() error {
+			return OperationsIndexer(ctx, persistedCh, ms.cc, ms.db, ms.chainParams, ms.traceTimeout, ms.logger)
+		})
 	}
-	return err
+	group.Go(func() error { return ProcessChanges(ctx, changeSetsCh, ms.db, persistedCh, ms.logger) })
+	return group.Wait()
 }
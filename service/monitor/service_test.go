@@ -0,0 +1,106 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/rosetta/service"
+	. "github.com/onsi/gomega"
+)
+
+func TestRunWhileNodeUp(t *testing.T) {
+	RegisterTestingT(t)
+	errNodeGone := errors.New("connection refused")
+
+	t.Run("Pauses during an outage", func(t *testing.T) {
+		RegisterTestingT(t)
+		health := service.NewNodeHealth()
+		ms := &monitorService{nodeHealth: health, logger: log.New()}
+
+		runs := make(chan int, 10)
+		var count int
+		run := func(ctx context.Context) error {
+			count++
+			runs <- count
+			if count == 1 {
+				health.SetDown()
+				return errNodeGone
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		result := make(chan error, 1)
+		health.SetUp()
+		go func() { result <- ms.runWhileNodeUp(ctx, run) }()
+
+		Eventually(runs).Should(Receive(Equal(1)))
+		Consistently(runs, 100*time.Millisecond).ShouldNot(Receive())
+
+		health.SetUp()
+		Eventually(runs).Should(Receive(Equal(2)))
+
+		cancel()
+		Eventually(result).Should(Receive(BeNil()))
+	})
+
+	t.Run("Resumes after a short outage", func(t *testing.T) {
+		RegisterTestingT(t)
+		health := service.NewNodeHealth()
+		ms := &monitorService{nodeHealth: health, logger: log.New()}
+
+		runs := make(chan int, 10)
+		var count int
+		run := func(ctx context.Context) error {
+			count++
+			runs <- count
+			if count == 1 {
+				// Back up before the failure is noticed
+				health.SetDown()
+				health.SetUp()
+				return errNodeGone
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		result := make(chan error, 1)
+		health.SetUp()
+		go func() { result <- ms.runWhileNodeUp(ctx, run) }()
+
+		Eventually(runs).Should(Receive(Equal(1)))
+		Eventually(runs).Should(Receive(Equal(2)))
+
+		cancel()
+		Eventually(result).Should(Receive(BeNil()))
+	})
+
+	t.Run("Fails without supervised node", func(t *testing.T) {
+		RegisterTestingT(t)
+		ms := &monitorService{logger: log.New()}
+
+		err := ms.runWhileNodeUp(context.Background(), func(context.Context) error { return errNodeGone })
+		Ω(err).Should(Equal(errNodeGone))
+	})
+}
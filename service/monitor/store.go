@@ -16,12 +16,22 @@ package monitor
 
 import (
 	"context"
+	"math/big"
 
 	"github.com/celo-org/celo-blockchain/log"
 	"github.com/celo-org/rosetta/db"
 )
 
-func ProcessChanges(ctx context.Context, changes <-chan *db.BlockChangeSet, dbWriter db.RosettaDBWriter, logger log.Logger) error {
+// PersistedBlocks notifies the last persisted block, and the lowest block rolled back by a reorg
+// since the previous notification, if any
+type PersistedBlocks struct {
+	Last           *big.Int
+	RolledBackFrom *big.Int
+}
+
+// ProcessChanges persists the change sets. If persisted is not nil, it's notified of the persisted blocks:
+// it should have a buffer of 1, a pending notification is replaced by the newest block.
+func ProcessChanges(ctx context.Context, changes <-chan *db.BlockChangeSet, dbWriter db.RosettaDBWriter, persisted chan *PersistedBlocks, logger log.Logger) error {
 	logger = logger.New("pipe", "persister")
 	var count uint
 	for {
@@ -33,6 +43,10 @@ func ProcessChanges(ctx context.Context, changes <-chan *db.BlockChangeSet, dbWr
 				return err
 			}
 
+			if persisted != nil {
+				notifyPersisted(persisted, changeSet)
+			}
+
 			count++
 			if count == 1000 {
 				count = 0
@@ -41,3 +55,21 @@ func ProcessChanges(ctx context.Context, changes <-chan *db.BlockChangeSet, dbWr
 		}
 	}
 }
+
+// notifyPersisted sends the block without blocking, replacing the pending notification if any
+// but keeping the lowest rolled back block. It's only safe with a single sender.
+func notifyPersisted(persisted chan *PersistedBlocks, changeSet *db.BlockChangeSet) {
+	notification := &PersistedBlocks{Last: changeSet.BlockNumber}
+	if changeSet.Reorg {
+		notification.RolledBackFrom = changeSet.BlockNumber
+	}
+
+	select {
+	case pending := <-persisted:
+		if pending.RolledBackFrom != nil && (notification.RolledBackFrom == nil || pending.RolledBackFrom.Cmp(notification.RolledBackFrom) < 0) {
+			notification.RolledBackFrom = pending.RolledBackFrom
+		}
+	default:
+	}
+	persisted <- notification
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// NodeHealth tracks whether the node is up, so that the services using it can pause while it's down.
+// A nil NodeHealth is always up, for nodes that aren't supervised.
+type NodeHealth struct {
+	mu    sync.Mutex
+	up    bool
+	downs uint64
+	upCh  chan struct{} // closed while the node is up
+}
+
+// NewNodeHealth returns a NodeHealth for a node that is down until SetUp is called
+func NewNodeHealth() *NodeHealth {
+	return &NodeHealth{upCh: make(chan struct{})}
+}
+
+// Up returns whether the node is up
+func (nh *NodeHealth) Up() bool {
+	if nh == nil {
+		return true
+	}
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+	return nh.up
+}
+
+// Downs returns how many times the node went down, so that a failure can be matched to a downtime
+func (nh *NodeHealth) Downs() uint64 {
+	if nh == nil {
+		return 0
+	}
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+	return nh.downs
+}
+
+func (nh *NodeHealth) SetUp() {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+	if !nh.up {
+		nh.up = true
+		close(nh.upCh)
+	}
+}
+
+func (nh *NodeHealth) SetDown() {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+	if nh.up {
+		nh.up = false
+		nh.downs++
+		nh.upCh = make(chan struct{})
+	}
+}
+
+// WaitUp blocks until the node is up, or the context is done
+func (nh *NodeHealth) WaitUp(ctx context.Context) error {
+	if nh == nil {
+		return nil
+	}
+	nh.mu.Lock()
+	upCh := nh.upCh
+	nh.mu.Unlock()
+
+	select {
+	case <-upCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -17,9 +17,15 @@ package rpc
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
 
 	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/celo-blockchain/rpc"
 	"github.com/celo-org/kliento/client"
+	"github.com/celo-org/rosetta/db"
 	"github.com/coinbase/rosetta-sdk-go/types"
 )
 
@@ -48,8 +54,33 @@ var (
 	ErrUnimplemented = NewErrorResponse(405, "Unimplemented rosetta endpoint")
 	ErrInternal      = NewErrorResponse(500, "Internal server error")
 	ErrCeloClient    = NewErrorResponse(502, "Celo node rpc request failed")
+
+	ErrBlockNotFound         = NewErrorResponse(404, "Block not found")
+	ErrInvalidAddress        = NewErrorResponse(422, "Invalid address")
+	ErrUnsupportedSubAccount = NewErrorResponse(423, "Unsupported sub-account")
+
+	ErrBlockNotIndexed = NewRetriableErrorResponse(425, "Block not yet indexed")
+	ErrNodeUnavailable = NewRetriableErrorResponse(503, "Celo node unavailable")
+	ErrTraceTimeout    = NewRetriableErrorResponse(504, "Transaction trace timed out")
+
+	ErrUnavailableOffline = NewErrorResponse(501, "Endpoint unavailable in offline mode")
 )
 
+// AllErrors are the error responses advertised in /network/options
+var AllErrors = []*types.Error{
+	ErrValidation,
+	ErrUnimplemented,
+	ErrInternal,
+	ErrCeloClient,
+	ErrBlockNotFound,
+	ErrInvalidAddress,
+	ErrUnsupportedSubAccount,
+	ErrBlockNotIndexed,
+	ErrNodeUnavailable,
+	ErrTraceTimeout,
+	ErrUnavailableOffline,
+}
+
 func LogErrValidation(err error) *types.Error {
 	logger.Error("ValidatorError", "err", err)
 	return LogErrDetails(ErrValidation, err)
@@ -60,16 +91,25 @@ func LogErrUnimplemented(rosettaEndpoint string) *types.Error {
 	return ErrUnimplemented
 }
 
-func LogErrDetails(rosettaErr *types.Error, err error) *types.Error {
+// LogErrDetails copies rosettaErr with err as details context, along with the extra key value pairs
+func LogErrDetails(rosettaErr *types.Error, err error, keyvals ...interface{}) *types.Error {
 	copyErr := &types.Error{}
 	*copyErr = *rosettaErr
 	copyErr.Details = map[string]interface{}{
 		"context": err.Error(),
 	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		copyErr.Details[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
 	return copyErr
 }
 
 func LogErrInternal(err error, params ...interface{}) *types.Error {
+	if rosettaErr := typedError(err); rosettaErr != nil {
+		logger.Warn(rosettaErr.Message, append([]interface{}{"err", err}, params...)...)
+		return LogErrDetails(rosettaErr, err, params...)
+	}
+
 	params = append([]interface{}{"err", err}, params...)
 	logger.Error("InternalError", params...)
 	return LogErrDetails(ErrInternal, fmt.Errorf("%w:%+v", err, params))
@@ -77,16 +117,91 @@ func LogErrInternal(err error, params ...interface{}) *types.Error {
 
 func LogErrCeloClient(rpcEndpoint string, err error) *types.Error {
 	cause := client.WrapRpcError(err)
+	if rosettaErr := typedError(err); rosettaErr != nil {
+		logger.Warn(rosettaErr.Message, "endpoint", rpcEndpoint, "err", cause)
+		return LogErrDetails(rosettaErr, cause, "endpoint", rpcEndpoint)
+	}
+
 	logger.Error("CeloClientError", "endpoint", rpcEndpoint, "err", cause)
-	return LogErrDetails(ErrCeloClient, fmt.Errorf("%w:%s@%+v", err, rpcEndpoint, cause))
+	return LogErrDetails(ErrCeloClient, fmt.Errorf("%w:%s@%+v", err, rpcEndpoint, cause), "endpoint", rpcEndpoint)
 }
 
 func LogErrFetchBlockHeader(err error) *types.Error {
 	return LogErrCeloClient("HeaderAndTxnHashesByNumber", err)
 }
 
+func LogErrUnavailableOffline(rosettaEndpoint string) *types.Error {
+	logger.Warn("UnavailableOfflineError", "endpoint", rosettaEndpoint)
+	return LogErrDetails(ErrUnavailableOffline, errors.New("the endpoint needs a celo node, use an online instance"), "endpoint", rosettaEndpoint)
+}
+
+func LogErrNodeDown(rosettaEndpoint string) *types.Error {
+	logger.Warn("NodeDownError", "endpoint", rosettaEndpoint)
+	return LogErrDetails(ErrNodeUnavailable, errors.New("the celo node is down, retry once it's back"), "endpoint", rosettaEndpoint)
+}
+
+func LogErrBlockNotFound(err error, blockIdentifier *types.PartialBlockIdentifier) *types.Error {
+	logger.Warn("BlockNotFound", "err", err)
+	rosettaErr := LogErrDetails(ErrBlockNotFound, err)
+	if blockIdentifier != nil && blockIdentifier.Index != nil {
+		rosettaErr.Details["index"] = *blockIdentifier.Index
+	}
+	if blockIdentifier != nil && blockIdentifier.Hash != nil {
+		rosettaErr.Details["hash"] = *blockIdentifier.Hash
+	}
+	return rosettaErr
+}
+
+func LogErrInvalidAddress(address string) *types.Error {
+	logger.Warn("InvalidAddress", "address", address)
+	return LogErrDetails(ErrInvalidAddress, errors.New("address must be a 20 bytes hex string"), "address", address)
+}
+
+func LogErrUnsupportedSubAccount(subAccount string, supported ...string) *types.Error {
+	logger.Warn("UnsupportedSubAccount", "subAccount", subAccount)
+	return LogErrDetails(ErrUnsupportedSubAccount, fmt.Errorf("sub-account must be one of %s", strings.Join(supported, ", ")), "sub_account", subAccount, "supported", supported)
+}
+
+// typedError returns the error response for the causes clients can act upon, or nil
+func typedError(err error) *types.Error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, db.ErrFutureBlock):
+		return ErrBlockNotIndexed
+	case isNodeUnavailable(err):
+		return ErrNodeUnavailable
+	// Set by the node tracers when running longer than the requested timeout
+	case strings.Contains(err.Error(), "execution timeout"):
+		return ErrTraceTimeout
+	}
+	return nil
+}
+
+func isNodeUnavailable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, rpc.ErrClientQuit) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
 var (
 	ErrBadBlockIdentifier = errors.New("Bad block identifier")
 	ErrFetchBlockHeader   = errors.New("Failed to fetch block header")
 	ErrMissingTxInBlock   = errors.New("Transaction doesn't belong to block")
+	ErrMissingTxInMempool = errors.New("Transaction is not in the mempool")
+
+	ErrMissingOperations    = errors.New("No operations provided")
+	ErrUnsupportedOperation = errors.New("Unsupported operations")
+	ErrUnsupportedCurrency  = errors.New("Unsupported currency")
+	ErrBadAccountIdentifier = errors.New("Bad account identifier")
+	ErrBadSignature         = errors.New("Bad signature")
+
+	ErrMissingNetworkIdentifier = errors.New("Missing network identifier")
+	ErrUnknownNetwork           = errors.New("Unknown network")
+
+	ErrUnsupportedCoins            = errors.New("Coin identifiers are not supported")
+	ErrConflictingSearchConditions = errors.New("Conflicting search conditions")
 )
@@ -16,8 +16,12 @@ package rpc
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"syscall"
 	"testing"
 
+	"github.com/celo-org/rosetta/db"
 	. "github.com/onsi/gomega"
 )
 
@@ -44,3 +48,46 @@ func TestLogErrDetails(t *testing.T) {
 		Ω(rosettaErr).Should(Equal(copyErr))
 	})
 }
+
+func TestTypedErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("Block not indexed", func(t *testing.T) {
+		RegisterTestingT(t)
+		rosettaErr := LogErrInternal(fmt.Errorf("can't get gasPriceMinimun: %w", db.ErrFutureBlock), "blockNumber", 10)
+		Ω(rosettaErr.Code).Should(Equal(ErrBlockNotIndexed.Code))
+		Ω(rosettaErr.Retriable).Should(BeTrue())
+		Ω(rosettaErr.Details).Should(HaveKeyWithValue("blockNumber", 10))
+	})
+
+	t.Run("Node unavailable", func(t *testing.T) {
+		RegisterTestingT(t)
+		connErr := &net.OpError{Op: "dial", Net: "unix", Err: syscall.ECONNREFUSED}
+		rosettaErr := LogErrCeloClient("HeaderByNumber", connErr)
+		Ω(rosettaErr.Code).Should(Equal(ErrNodeUnavailable.Code))
+		Ω(rosettaErr.Retriable).Should(BeTrue())
+		Ω(rosettaErr.Details).Should(HaveKeyWithValue("endpoint", "HeaderByNumber"))
+	})
+
+	t.Run("Trace timeout", func(t *testing.T) {
+		RegisterTestingT(t)
+		rosettaErr := LogErrCeloClient("TraceTransaction", fmt.Errorf("can't run celo-rpc tx-tracer: %w", errors.New("execution timeout")))
+		Ω(rosettaErr.Code).Should(Equal(ErrTraceTimeout.Code))
+		Ω(rosettaErr.Retriable).Should(BeTrue())
+	})
+
+	t.Run("Other node errors", func(t *testing.T) {
+		RegisterTestingT(t)
+		rosettaErr := LogErrCeloClient("TraceTransaction", errors.New("missing trie node"))
+		Ω(rosettaErr.Code).Should(Equal(ErrCeloClient.Code))
+		Ω(rosettaErr.Retriable).Should(BeFalse())
+	})
+
+	t.Run("Unsupported sub-account", func(t *testing.T) {
+		RegisterTestingT(t)
+		rosettaErr := LogErrUnsupportedSubAccount("Foo", "Bar", "Baz")
+		Ω(rosettaErr.Code).Should(Equal(ErrUnsupportedSubAccount.Code))
+		Ω(rosettaErr.Details).Should(HaveKeyWithValue("sub_account", "Foo"))
+		Ω(rosettaErr.Details).Should(HaveKeyWithValue("supported", []string{"Bar", "Baz"}))
+	})
+}
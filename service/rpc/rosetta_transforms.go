@@ -15,17 +15,23 @@
 package rpc
 
 import (
+	"errors"
+	"fmt"
 	"math/big"
 
+	"github.com/celo-org/kliento/celotokens"
 	"github.com/celo-org/kliento/client/txpool"
+	"github.com/celo-org/rosetta/airgap"
 	"github.com/celo-org/rosetta/analyzer"
 
 	rosettaTypes "github.com/coinbase/rosetta-sdk-go/types"
 
+	ethereum "github.com/celo-org/celo-blockchain"
 	"github.com/celo-org/celo-blockchain/common"
 	gethTypes "github.com/celo-org/celo-blockchain/core/types"
 	"github.com/celo-org/celo-blockchain/ethclient"
 	"github.com/celo-org/celo-blockchain/p2p"
+	celorpc "github.com/celo-org/celo-blockchain/rpc"
 )
 
 func PeersFromInfo(peersInfo []p2p.PeerInfo) []*rosettaTypes.Peer {
@@ -36,6 +42,65 @@ func PeersFromInfo(peersInfo []p2p.PeerInfo) []*rosettaTypes.Peer {
 	return peers
 }
 
+// BalanceExemptions are the sub-accounts whose balance changes are not fully covered by operations:
+// active votes are held as units, whose CELO value can differ by rounding from the operations amounts.
+// Vote operations stored before they had amounts are of a previous analyzer.OperationsVersion, so they're traced again.
+func BalanceExemptions() []*rosettaTypes.BalanceExemption {
+	subAccounts := []analyzer.SubAccountType{analyzer.AccLockedGoldVotingActive}
+	exemptions := make([]*rosettaTypes.BalanceExemption, len(subAccounts))
+	for i, subAccount := range subAccounts {
+		exemptions[i] = &rosettaTypes.BalanceExemption{
+			SubAccountAddress: rosettaTypes.String(string(subAccount)),
+			Currency:          CeloGold,
+			ExemptionType:     rosettaTypes.BalanceDynamic,
+		}
+	}
+	return exemptions
+}
+
+// Stages of the SyncStatus reported by /network/status
+const (
+	SyncStageNodeSyncing       = "node syncing"
+	SyncStageIndexerCatchingUp = "indexer catching up"
+	SyncStageSynced            = "synced"
+)
+
+// NewSyncStatus reports the sync of the node (syncProgress is nil when not syncing) if it lags behind the network,
+// otherwise the sync of the indexer towards the network head (the highest of the node head and the highest block
+// known from peers). Synced only when both lag at most threshold blocks, in total.
+func NewSyncStatus(syncProgress *ethereum.SyncProgress, nodeHead *big.Int, lastPersistedBlock *big.Int, threshold uint64) *rosettaTypes.SyncStatus {
+	if syncProgress != nil && syncProgress.HighestBlock > syncProgress.CurrentBlock+threshold {
+		return &rosettaTypes.SyncStatus{
+			CurrentIndex: rosettaTypes.Int64(int64(syncProgress.CurrentBlock)),
+			TargetIndex:  rosettaTypes.Int64(int64(syncProgress.HighestBlock)),
+			Stage:        rosettaTypes.String(SyncStageNodeSyncing),
+			Synced:       rosettaTypes.Bool(false),
+		}
+	}
+
+	target := nodeHead
+	if syncProgress != nil && new(big.Int).SetUint64(syncProgress.HighestBlock).Cmp(target) > 0 {
+		target = new(big.Int).SetUint64(syncProgress.HighestBlock)
+	}
+
+	gap := new(big.Int).Sub(target, lastPersistedBlock)
+	if gap.Cmp(new(big.Int).SetUint64(threshold)) > 0 {
+		return &rosettaTypes.SyncStatus{
+			CurrentIndex: rosettaTypes.Int64(lastPersistedBlock.Int64()),
+			TargetIndex:  rosettaTypes.Int64(target.Int64()),
+			Stage:        rosettaTypes.String(SyncStageIndexerCatchingUp),
+			Synced:       rosettaTypes.Bool(false),
+		}
+	}
+
+	return &rosettaTypes.SyncStatus{
+		CurrentIndex: rosettaTypes.Int64(lastPersistedBlock.Int64()),
+		TargetIndex:  rosettaTypes.Int64(target.Int64()),
+		Stage:        rosettaTypes.String(SyncStageSynced),
+		Synced:       rosettaTypes.Bool(true),
+	}
+}
+
 func TxIdsFromTxAccountMap(txAccountMap txpool.TxAccountMap) []*rosettaTypes.TransactionIdentifier {
 	identifiers := []*rosettaTypes.TransactionIdentifier{}
 	for _, txNonceMap := range txAccountMap {
@@ -48,6 +113,40 @@ func TxIdsFromTxAccountMap(txAccountMap txpool.TxAccountMap) []*rosettaTypes.Tra
 	return identifiers
 }
 
+// TxFromTxPoolContent looks up a transaction by hash, first among pending and then among queued txs.
+func TxFromTxPoolContent(content *txpool.TxPoolContent, txHash common.Hash) *celorpc.RPCTransaction {
+	for _, status := range []string{"pending", "queued"} {
+		for _, txNonceMap := range (*content)[status] {
+			for _, tx := range txNonceMap {
+				if tx.Hash == txHash {
+					return tx
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RPCTransactionToTransaction rebuilds the (unsigned) transaction of a txpool entry,
+// which is enough to compute fees and decode the calldata.
+func RPCTransactionToTransaction(tx *celorpc.RPCTransaction) *gethTypes.Transaction {
+	var value, gasPrice, gatewayFee *big.Int
+	if tx.Value != nil {
+		value = tx.Value.ToInt()
+	}
+	if tx.GasPrice != nil {
+		gasPrice = tx.GasPrice.ToInt()
+	}
+	if tx.GatewayFee != nil {
+		gatewayFee = tx.GatewayFee.ToInt()
+	}
+
+	if tx.To == nil {
+		return gethTypes.NewCeloContractCreation(uint64(tx.Nonce), value, uint64(tx.Gas), gasPrice, tx.FeeCurrency, tx.GatewayFeeRecipient, gatewayFee, tx.Input)
+	}
+	return gethTypes.NewCeloTransaction(uint64(tx.Nonce), *tx.To, value, uint64(tx.Gas), gasPrice, tx.FeeCurrency, tx.GatewayFeeRecipient, gatewayFee, tx.Input)
+}
+
 func HeaderContainsTx(header *ethclient.HeaderAndTxnHashes, txHash common.Hash) bool {
 	for _, tx := range header.Transactions {
 		if tx == txHash {
@@ -140,12 +239,477 @@ func OperationsFromAnalyzer(iop *analyzer.Operation, baseIndex int64) []*rosetta
 		operations[i] = &rosettaTypes.Operation{
 			OperationIdentifier: NewOperationIdentifier(opIndex),
 			Account:             AccountFromAnalyzer(change.Account),
-			Amount:              NewAmount(change.Amount, CeloGold),
-			Status:              GetOperationStatus(iop.Successful).String(),
+			Amount:              NewAmount(change.Amount, CurrencyFor(iop.Currency)),
+			Status:              rosettaTypes.String(GetOperationStatus(iop.Successful).String()),
 			Type:                string(iop.Type),
 			RelatedOperations:   relatedOps,
 		}
+		if iop.Metadata != nil {
+			// Copied since MarkOperationsAsPredicted adds to it
+			operations[i].Metadata = make(map[string]interface{}, len(iop.Metadata))
+			for k, v := range iop.Metadata {
+				operations[i].Metadata[k] = v
+			}
+		}
 		opIndex++
 	}
 	return operations
 }
+
+// TransactionOperationsFromAnalyzer converts the analyzer operations of a tx, indexing them in order
+func TransactionOperationsFromAnalyzer(ops []analyzer.Operation) []*rosettaTypes.Operation {
+	var operations []*rosettaTypes.Operation
+	for _, aop := range ops {
+		// TODO - revisit
+		// nolint:gosec
+		transferOps := OperationsFromAnalyzer(&aop, int64(len(operations)))
+		operations = append(operations, transferOps...)
+	}
+	return operations
+}
+
+// MarkOperationsAsPredicted flags operations that were not produced by executing a tx
+// (e.g. operations of mempool txs), so they are not mistaken for confirmed results.
+func MarkOperationsAsPredicted(operations []*rosettaTypes.Operation) {
+	for _, op := range operations {
+		if op.Metadata == nil {
+			op.Metadata = make(map[string]interface{})
+		}
+		op.Metadata[OperationPredictedKey] = true
+	}
+}
+
+func addressFromAccountIdentifier(account *rosettaTypes.AccountIdentifier) (common.Address, error) {
+	if account == nil || !common.IsHexAddress(account.Address) {
+		return common.ZeroAddress, ErrBadAccountIdentifier
+	}
+	return common.HexToAddress(account.Address), nil
+}
+
+func celoGoldValue(amount *rosettaTypes.Amount) (*big.Int, error) {
+	if amount == nil || amount.Currency == nil || amount.Currency.Symbol != CeloGold.Symbol || amount.Currency.Decimals != CeloGold.Decimals {
+		return nil, ErrUnsupportedCurrency
+	}
+	value, ok := new(big.Int).SetString(amount.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount value %q", amount.Value)
+	}
+	return value, nil
+}
+
+// TxArgsFromOperations builds the airgap tx arguments for a set of construction operations.
+// CELO transfers, account creation, locking, unlocking and voting calls are derived from the
+// operations themselves (e.g. a transfer from the sender's negative amount and the recipient's
+// opposite amount, a vote from the LockedGoldVotingPending change of the group).
+// Calls the operations can't describe (a pending withdrawal index, a proof of possession or a
+// ReleaseGold proxy call) require metadata with the same keys as the TxArgs options
+// (method, args and optionally to and value), where the first operation's account is the signer.
+// That method must perform operations of the requested type.
+func TxArgsFromOperations(operations []*rosettaTypes.Operation, metadata map[string]interface{}) (*airgap.TxArgs, error) {
+	if len(operations) == 0 {
+		return nil, ErrMissingOperations
+	}
+	opType := analyzer.OperationType(operations[0].Type)
+
+	if _, ok := metadata[OptionsMethodKey]; !ok {
+		return derivedTxArgs(opType, operations)
+	}
+
+	from, err := addressFromAccountIdentifier(operations[0].Account)
+	if err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		options[k] = v
+	}
+	options[OptionsFromKey] = from.Hex()
+
+	var txArgs airgap.TxArgs
+	if err := airgap.UnmarshallFromMap(options, &txArgs); err != nil {
+		return nil, err
+	}
+	if methodOpType, ok := methodOperationTypes[txArgs.Method]; !ok || methodOpType != opType {
+		return nil, fmt.Errorf("%w: method %s doesn't perform %s operations", ErrUnsupportedOperation, txArgs.Method, opType)
+	}
+	return &txArgs, nil
+}
+
+// methodOperationTypes maps the methods that can be requested through metadata to
+// the type of the operations they perform
+var methodOperationTypes = map[*airgap.CeloMethod]analyzer.OperationType{
+	airgap.CreateAccount:                         analyzer.OpCreateAccount,
+	airgap.AuthorizeVoteSigner:                   analyzer.OpAuthorizeVoteSigner,
+	airgap.AuthorizeAttestationSigner:            analyzer.OpAuthorizeAttestationSigner,
+	airgap.AuthorizeValidatorSigner:              analyzer.OpAuthorizeValidatorSigner,
+	airgap.LockGold:                              analyzer.OpLockGold,
+	airgap.UnlockGold:                            analyzer.OpUnlockGold,
+	airgap.RelockGold:                            analyzer.OpRelockGold,
+	airgap.WithdrawGold:                          analyzer.OpWithdrawGold,
+	airgap.Vote:                                  analyzer.OpVote,
+	airgap.ActivateVotes:                         analyzer.OpActiveVotes,
+	airgap.RevokePendingVotes:                    analyzer.OpRevokePendingVotes,
+	airgap.RevokeActiveVotes:                     analyzer.OpRevokeActiveVotes,
+	airgap.StableTokenTransfer:                   analyzer.OpTransfer,
+	airgap.ReleaseGoldWithdraw:                   analyzer.OpTransfer,
+	airgap.ReleaseGoldCreateAccount:              analyzer.OpCreateAccount,
+	airgap.ReleaseGoldLockGold:                   analyzer.OpLockGold,
+	airgap.ReleaseGoldUnlockGold:                 analyzer.OpUnlockGold,
+	airgap.ReleaseGoldRelockGold:                 analyzer.OpRelockGold,
+	airgap.ReleaseGoldWithdrawGold:               analyzer.OpWithdrawGold,
+	airgap.ReleaseGoldAuthorizeVoteSigner:        analyzer.OpAuthorizeVoteSigner,
+	airgap.ReleaseGoldAuthorizeAttestationSigner: analyzer.OpAuthorizeAttestationSigner,
+	airgap.ReleaseGoldAuthorizeValidatorSigner:   analyzer.OpAuthorizeValidatorSigner,
+	airgap.ReleaseGoldRevokePendingVotes:         analyzer.OpRevokePendingVotes,
+	airgap.ReleaseGoldRevokeActiveVotes:          analyzer.OpRevokeActiveVotes,
+}
+
+// derivedTxArgs builds the core contract call described by the operations of the signer
+// (the first operation's account)
+func derivedTxArgs(opType analyzer.OperationType, operations []*rosettaTypes.Operation) (*airgap.TxArgs, error) {
+	switch opType {
+	case analyzer.OpTransfer:
+		return transferTxArgs(operations)
+	case analyzer.OpRelockGold, analyzer.OpWithdrawGold:
+		return nil, fmt.Errorf("%w: %s requires the pending withdrawal index as method and args in metadata", ErrUnsupportedOperation, opType)
+	case analyzer.OpAuthorizeVoteSigner, analyzer.OpAuthorizeAttestationSigner, analyzer.OpAuthorizeValidatorSigner:
+		return nil, fmt.Errorf("%w: %s requires the proof of possession as method and args in metadata", ErrUnsupportedOperation, opType)
+	case analyzer.OpCreateAccount, analyzer.OpLockGold, analyzer.OpUnlockGold,
+		analyzer.OpVote, analyzer.OpActiveVotes, analyzer.OpRevokePendingVotes, analyzer.OpRevokeActiveVotes:
+	default:
+		return nil, fmt.Errorf("%w: %s can't be constructed", ErrUnsupportedOperation, opType)
+	}
+
+	for _, op := range operations {
+		if op.Type != string(opType) {
+			return nil, fmt.Errorf("%w: operations must all be %s", ErrUnsupportedOperation, opType)
+		}
+	}
+	signer, err := addressFromAccountIdentifier(operations[0].Account)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := airgap.NewArgBuilder()
+	switch opType {
+	case analyzer.OpCreateAccount:
+		return builder.CreateAccount(signer)
+
+	case analyzer.OpLockGold, analyzer.OpUnlockGold:
+		value, _, err := subAccountChange(operations, signer, analyzer.AccLockedGoldNonVoting)
+		if err != nil {
+			return nil, err
+		}
+		if opType == analyzer.OpLockGold && value.Sign() > 0 {
+			return builder.LockGold(signer, value)
+		}
+		if opType == analyzer.OpUnlockGold && value.Sign() < 0 {
+			return builder.UnlockGold(signer, value.Neg(value))
+		}
+
+	case analyzer.OpVote, analyzer.OpRevokePendingVotes:
+		value, group, err := subAccountChange(operations, signer, analyzer.AccLockedGoldVotingPending)
+		if err != nil {
+			return nil, err
+		}
+		if opType == analyzer.OpVote && value.Sign() > 0 {
+			return builder.Vote(signer, group, value)
+		}
+		if opType == analyzer.OpRevokePendingVotes && value.Sign() < 0 {
+			return builder.RevokePendingVotes(signer, group, value.Neg(value))
+		}
+
+	case analyzer.OpRevokeActiveVotes:
+		value, group, err := subAccountChange(operations, signer, analyzer.AccLockedGoldVotingActive)
+		if err != nil {
+			return nil, err
+		}
+		if value.Sign() < 0 {
+			return builder.RevokeActiveVotes(signer, group, value.Neg(value))
+		}
+
+	case analyzer.OpActiveVotes:
+		// The activated amount is only known once executed, so it's not required
+		_, group, err := subAccountChange(operations, signer, analyzer.AccLockedGoldVotingActive)
+		if err != nil && !errors.Is(err, ErrUnsupportedCurrency) {
+			return nil, err
+		}
+		return builder.ActivateVotes(signer, group)
+	}
+	return nil, fmt.Errorf("%w: %s has an amount with the wrong sign", ErrUnsupportedOperation, opType)
+}
+
+// subAccountChange finds the CELO amount the operations move on a sub-account of addr,
+// along with the group of voting sub-accounts
+func subAccountChange(operations []*rosettaTypes.Operation, addr common.Address, subAccount analyzer.SubAccountType) (*big.Int, common.Address, error) {
+	for _, op := range operations {
+		if op.Account == nil || op.Account.SubAccount == nil || op.Account.SubAccount.Address != string(subAccount) {
+			continue
+		}
+		if opAddr, err := addressFromAccountIdentifier(op.Account); err != nil || opAddr != addr {
+			continue
+		}
+
+		var group common.Address
+		if subAccount == analyzer.AccLockedGoldVotingPending || subAccount == analyzer.AccLockedGoldVotingActive {
+			var ok bool
+			if group, ok = groupFromMetadata(op.Account.SubAccount.Metadata); !ok {
+				return nil, common.ZeroAddress, fmt.Errorf("%w: %s requires the group in the %s metadata", ErrUnsupportedOperation, op.Type, subAccount)
+			}
+		}
+		value, err := celoGoldValue(op.Amount)
+		return value, group, err
+	}
+	return nil, common.ZeroAddress, fmt.Errorf("%w: %s requires an operation on the %s of %s", ErrUnsupportedOperation, operations[0].Type, subAccount, addr.Hex())
+}
+
+// groupFromMetadata reads the voted group of a sub-account, which is an address
+// when built by the analyzer and a hex string when read from a request
+func groupFromMetadata(metadata map[string]interface{}) (common.Address, bool) {
+	switch group := metadata["group"].(type) {
+	case common.Address:
+		return group, true
+	case string:
+		return common.HexToAddress(group), common.IsHexAddress(group)
+	default:
+		return common.ZeroAddress, false
+	}
+}
+
+// VerifyTxArgs checks that the call a tx performs is the one described by the operations.
+// Calls derived from the operations are fully compared, while for the rest only the signer
+// and the type of the operations performed by the method can be checked.
+// contractMap holds the known core contract addresses, it may be empty when working offline.
+func VerifyTxArgs(operations []*rosettaTypes.Operation, txArgs *airgap.TxArgs, contractMap map[string]common.Address) error {
+	method := txArgs.Method
+	if method != nil && txArgs.To != nil {
+		method = analyzer.ResolveReleaseGoldMethod(method, *txArgs.To, contractMap)
+	}
+
+	var expected *airgap.TxArgs
+	var err error
+	switch method {
+	case nil, airgap.CreateAccount, airgap.LockGold, airgap.UnlockGold, airgap.Vote,
+		airgap.ActivateVotes, airgap.RevokePendingVotes, airgap.RevokeActiveVotes:
+		expected, err = TxArgsFromOperations(operations, nil)
+	default:
+		metadata := map[string]interface{}{OptionsMethodKey: method.String()}
+		if txArgs.To != nil {
+			metadata[OptionsToKey] = txArgs.To.Hex()
+		}
+		expected, err = TxArgsFromOperations(operations, metadata)
+	}
+	if err != nil {
+		return err
+	}
+
+	if expected.From != txArgs.From {
+		return fmt.Errorf("tx is sent from %s, but the operations are signed by %s", txArgs.From.Hex(), expected.From.Hex())
+	}
+	if (expected.Method == nil) != (method == nil) || (method != nil && expected.Method.String() != method.String()) {
+		return fmt.Errorf("tx calls %s, but the operations require %s", method, expected.Method)
+	}
+	if txArgs.To == nil {
+		return fmt.Errorf("tx has no recipient")
+	}
+	if expected.To != nil && *expected.To != *txArgs.To {
+		return fmt.Errorf("tx is sent to %s, but the operations require %s", txArgs.To.Hex(), expected.To.Hex())
+	}
+	if method != nil {
+		if contractAddr, ok := contractMap[method.Contract]; ok && contractAddr != *txArgs.To {
+			return fmt.Errorf("tx is sent to %s, but %s is at %s", txArgs.To.Hex(), method.Contract, contractAddr.Hex())
+		}
+	}
+	if valueOrZero(expected.Value).Cmp(valueOrZero(txArgs.Value)) != 0 {
+		return fmt.Errorf("tx has value %s, but the operations require %s", valueOrZero(txArgs.Value), valueOrZero(expected.Value))
+	}
+	if expected.Args == nil {
+		return nil
+	}
+
+	expectedArgs, err := expected.Method.DeserializeArguments(expected.Args...)
+	if err != nil {
+		return err
+	}
+	// The revoke calldata doesn't include the account, and the vote and revoke calldata
+	// are followed by the neighbour groups and index, which the operations don't describe
+	if method == airgap.RevokePendingVotes || method == airgap.RevokeActiveVotes {
+		expectedArgs = expectedArgs[1:]
+	}
+	if len(txArgs.Args) < len(expectedArgs) {
+		return fmt.Errorf("tx has %d args, but the operations require %d", len(txArgs.Args), len(expectedArgs))
+	}
+	for i, arg := range expectedArgs {
+		if !equalArg(arg, txArgs.Args[i]) {
+			return fmt.Errorf("tx has %v as argument %d, but the operations require %v", txArgs.Args[i], i, arg)
+		}
+	}
+	return nil
+}
+
+func valueOrZero(value *big.Int) *big.Int {
+	if value == nil {
+		return big.NewInt(0)
+	}
+	return value
+}
+
+func equalArg(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *big.Int:
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	case common.Address:
+		b, ok := b.(common.Address)
+		return ok && a == b
+	default:
+		return false
+	}
+}
+
+// OperationsInvolve checks whether any of the operations belongs to addr
+func OperationsInvolve(operations []*rosettaTypes.Operation, addr common.Address) bool {
+	for _, op := range operations {
+		if opAddr, err := addressFromAccountIdentifier(op.Account); err == nil && opAddr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func transferTxArgs(operations []*rosettaTypes.Operation) (*airgap.TxArgs, error) {
+	if len(operations) != 2 || operations[1].Type != analyzer.OpTransfer.String() {
+		return nil, fmt.Errorf("%w: a transfer requires exactly two transfer operations", ErrUnsupportedOperation)
+	}
+
+	var addresses [2]common.Address
+	var values [2]*big.Int
+	for i, op := range operations {
+		if op.Account != nil && op.Account.SubAccount != nil {
+			return nil, fmt.Errorf("%w: transfers must use main accounts", ErrUnsupportedOperation)
+		}
+		addr, err := addressFromAccountIdentifier(op.Account)
+		if err != nil {
+			return nil, err
+		}
+		value, err := celoGoldValue(op.Amount)
+		if err != nil {
+			return nil, err
+		}
+		addresses[i], values[i] = addr, value
+	}
+
+	from, to, value := addresses[0], addresses[1], values[1]
+	if values[0].Sign() > 0 {
+		from, to, value = addresses[1], addresses[0], values[0]
+	}
+	if value.Sign() <= 0 || new(big.Int).Add(values[0], values[1]).Sign() != 0 {
+		return nil, fmt.Errorf("%w: transfer amounts must be opposite and non zero", ErrUnsupportedOperation)
+	}
+
+	return airgap.NewArgBuilder().TransferGold(from, to, value)
+}
+
+// stableTokenAt returns the stable token deployed at addr, all of them share the same ABI.
+// It's not found without the registry addresses (e.g. offline).
+func stableTokenAt(addr common.Address, contractMap map[string]common.Address) (celotokens.CeloToken, bool) {
+	for _, token := range analyzer.StableTokens {
+		registryID, err := celotokens.GetRegistryID(token)
+		if err != nil {
+			continue
+		}
+		if tokenAddr, ok := contractMap[registryID.String()]; ok && tokenAddr == addr {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// OperationsFromTxArgs describes the operations a tx will perform according to its decoded calldata,
+// in the same shape the analyzer reports them once the tx is executed.
+// Operations are returned without status, as required by the construction endpoints.
+// contractMap holds the known core contract addresses, it may be empty when working offline.
+func OperationsFromTxArgs(txArgs *airgap.TxArgs, contractMap map[string]common.Address) []*rosettaTypes.Operation {
+	var aops []analyzer.Operation
+
+	if txArgs.Method == airgap.StableTokenTransfer {
+		if len(txArgs.Args) == 2 && txArgs.To != nil {
+			recipient, okRecipient := txArgs.Args[0].(common.Address)
+			value, okValue := txArgs.Args[1].(*big.Int)
+			// The currency can't be told without the token address, so the transfer is left out
+			token, okToken := stableTokenAt(*txArgs.To, contractMap)
+			if okRecipient && okValue && okToken {
+				aops = append(aops, *analyzer.NewStableTokenTransfer(token, txArgs.From, recipient, value))
+			}
+		}
+	} else if txArgs.To != nil {
+		method := analyzer.ResolveReleaseGoldMethod(txArgs.Method, *txArgs.To, contractMap)
+		aops = analyzer.OperationsFromCall(txArgs.From, *txArgs.To, txArgs.Value, method, txArgs.Args, contractMap)
+	}
+
+	operations := make([]*rosettaTypes.Operation, 0)
+	for _, aop := range aops {
+		// TODO - revisit
+		// nolint:gosec
+		operations = append(operations, OperationsFromAnalyzer(&aop, int64(len(operations)))...)
+	}
+	for _, op := range operations {
+		op.Status = nil
+	}
+	return operations
+}
+
+func addressFromMetadata(metadata map[string]interface{}, key string) (common.Address, error) {
+	addrStr, ok := metadata[key].(string)
+	if !ok || !common.IsHexAddress(addrStr) {
+		return common.ZeroAddress, fmt.Errorf("metadata.%s must be a valid address", key)
+	}
+	return common.HexToAddress(addrStr), nil
+}
+
+// DeriveAccountIdentifier builds the account identifier controlled by addr, optionally
+// for the sub-account requested in the /construction/derive metadata
+func DeriveAccountIdentifier(addr common.Address, metadata map[string]interface{}) (*rosettaTypes.AccountIdentifier, error) {
+	subAccount, ok := metadata[DeriveSubAccountKey]
+	if !ok {
+		return &rosettaTypes.AccountIdentifier{Address: addr.Hex()}, nil
+	}
+
+	switch subAccount {
+	case string(analyzer.AccSigner):
+		account, err := addressFromMetadata(metadata, DeriveAccountKey)
+		if err != nil {
+			return nil, err
+		}
+		return &rosettaTypes.AccountIdentifier{
+			Address: addr.Hex(),
+			SubAccount: &rosettaTypes.SubAccountIdentifier{
+				Address:  string(analyzer.AccSigner),
+				// Encoded as by analyzer.NewSignerAccount, so that it equals the account of the authorize operations
+				Metadata: map[string]interface{}{"account": account},
+			},
+		}, nil
+
+	case string(analyzer.AccReleaseGoldVested), string(analyzer.AccReleaseGoldUnvestedLocked), string(analyzer.AccReleaseGoldUnvestedUnLocked):
+		releaseGold, err := addressFromMetadata(metadata, DeriveReleaseGoldKey)
+		if err != nil {
+			return nil, err
+		}
+		return &rosettaTypes.AccountIdentifier{
+			Address: releaseGold.Hex(),
+			SubAccount: &rosettaTypes.SubAccountIdentifier{
+				Address:  subAccount.(string),
+				Metadata: map[string]interface{}{"beneficiary": addr.Hex()},
+			},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("metadata.%s must be %s, %s, %s or %s", DeriveSubAccountKey,
+			string(analyzer.AccSigner),
+			string(analyzer.AccReleaseGoldVested),
+			string(analyzer.AccReleaseGoldUnvestedLocked),
+			string(analyzer.AccReleaseGoldUnvestedUnLocked),
+		)
+	}
+}
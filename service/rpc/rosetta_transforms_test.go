@@ -15,11 +15,17 @@
 package rpc
 
 import (
+	"encoding/json"
 	"math/big"
 	"strconv"
+	"strings"
 	"testing"
 
+	ethereum "github.com/celo-org/celo-blockchain"
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/kliento/celotokens"
+	"github.com/celo-org/kliento/client/txpool"
+	"github.com/celo-org/rosetta/airgap"
 	"github.com/celo-org/rosetta/analyzer"
 	"github.com/coinbase/rosetta-sdk-go/types"
 	rosettaTypes "github.com/coinbase/rosetta-sdk-go/types"
@@ -36,11 +42,20 @@ func MatchOperation(account common.Address, value int, currency *types.Currency,
 			"Currency": Equal(currency),
 			"Metadata": BeNil(),
 		})),
-		"Status": Equal(status.String()),
+		"Status": gs.PointTo(Equal(status.String())),
 		"Type":   Equal(kind.String()),
 	}))
 }
 
+func TestBalanceExemptions(t *testing.T) {
+	RegisterTestingT(t)
+
+	// Pending votes are reconciled by the vote operations amounts
+	exemptions := BalanceExemptions()
+	Ω(exemptions).Should(HaveLen(1))
+	Ω(*exemptions[0].SubAccountAddress).Should(Equal(string(analyzer.AccLockedGoldVotingActive)))
+}
+
 func TestMapTxHashesToTransaction(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -93,6 +108,25 @@ func TestGasDetailsToOperations(t *testing.T) {
 	))
 }
 
+func TestMembershipToOperations(t *testing.T) {
+	RegisterTestingT(t)
+
+	group, validator := common.HexToAddress("1"), common.HexToAddress("2")
+	aop := analyzer.NewMembership(analyzer.OpAddMember, group, validator, big.NewInt(20000))
+	operations := OperationsFromAnalyzer(aop, 0)
+
+	Ω(operations).Should(HaveLen(2))
+	for i, addr := range []common.Address{group, validator} {
+		Ω(operations[i].Account).Should(gs.PointTo(Equal(NewAccountIdentifier(addr, nil))))
+		Ω(operations[i].Amount).Should(BeNil())
+		Ω(operations[i].Type).Should(Equal(analyzer.OpAddMember.String()))
+		Ω(operations[i].Metadata).Should(Equal(map[string]interface{}{"groupLockedGoldRequirement": "20000"}))
+	}
+
+	MarkOperationsAsPredicted(operations)
+	Ω(aop.Metadata).Should(Equal(map[string]interface{}{"groupLockedGoldRequirement": "20000"}))
+}
+
 func TestOperationsFromAnalyzer_RelatedOpsCounter(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -126,3 +160,212 @@ func TestOperationsFromAnalyzer_RelatedOpsCounter(t *testing.T) {
 		),
 	)
 }
+
+func TestTxFromTxPoolContent(t *testing.T) {
+	RegisterTestingT(t)
+
+	pendingHash := common.HexToHash("1")
+	queuedHash := common.HexToHash("2")
+	content := txpool.TxPoolContent{
+		"pending": txpool.TxAccountMap{
+			"0x1111": {"0": {Hash: pendingHash}},
+		},
+		"queued": txpool.TxAccountMap{
+			"0x2222": {"5": {Hash: queuedHash}},
+		},
+	}
+
+	Ω(TxFromTxPoolContent(&content, pendingHash).Hash).Should(Equal(pendingHash))
+	Ω(TxFromTxPoolContent(&content, queuedHash).Hash).Should(Equal(queuedHash))
+	Ω(TxFromTxPoolContent(&content, common.HexToHash("3"))).Should(BeNil())
+}
+
+func TestTxArgsFromOperations_Method(t *testing.T) {
+	RegisterTestingT(t)
+
+	signer := common.HexToAddress("0x1111")
+	operations := []*rosettaTypes.Operation{{
+		OperationIdentifier: NewOperationIdentifier(0),
+		Type:                analyzer.OpLockGold.String(),
+		Account:             &rosettaTypes.AccountIdentifier{Address: signer.Hex()},
+	}}
+
+	txArgs, err := TxArgsFromOperations(operations, map[string]interface{}{
+		OptionsMethodKey: airgap.LockGold.String(),
+		OptionsValueKey:  "1000",
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(txArgs.From).Should(Equal(signer))
+	Ω(txArgs.Method).Should(Equal(airgap.LockGold))
+	Ω(txArgs.Value.Int64()).Should(Equal(int64(1000)))
+
+	_, err = TxArgsFromOperations(operations, nil)
+	Ω(err).Should(MatchError(ContainSubstring(ErrUnsupportedOperation.Error())))
+}
+
+func TestTxArgsFromOperations_Derived(t *testing.T) {
+	RegisterTestingT(t)
+
+	signer, group := common.HexToAddress("0x1111"), common.HexToAddress("0x2222")
+
+	t.Run("Vote", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewVote(signer, group, big.NewInt(1000)), 0)
+		txArgs, err := TxArgsFromOperations(operations, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(txArgs.From).Should(Equal(signer))
+		Ω(txArgs.Method).Should(Equal(airgap.Vote))
+		Ω(txArgs.Args).Should(Equal([]interface{}{group.Hex(), "1000"}))
+	})
+
+	t.Run("Revoke active votes", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewRevokeActiveVotes(signer, group, big.NewInt(1000)), 0)
+		// As read from a request
+		operations[0].Account.SubAccount.Metadata = map[string]interface{}{"group": group.Hex()}
+		txArgs, err := TxArgsFromOperations(operations, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(txArgs.Method).Should(Equal(airgap.RevokeActiveVotes))
+		Ω(txArgs.Args).Should(Equal([]interface{}{signer.Hex(), group.Hex(), "1000"}))
+	})
+
+	t.Run("Activate votes without amount", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewActiveVotes(signer, group, big.NewInt(1000)), 0)
+		for _, op := range operations {
+			op.Amount = nil
+		}
+		txArgs, err := TxArgsFromOperations(operations, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(txArgs.Method).Should(Equal(airgap.ActivateVotes))
+		Ω(txArgs.Args).Should(Equal([]interface{}{group.Hex()}))
+	})
+
+	t.Run("Lock gold", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewLockGold(signer, common.HexToAddress("0x3333"), big.NewInt(1000)), 0)
+		txArgs, err := TxArgsFromOperations(operations, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(txArgs.Method).Should(Equal(airgap.LockGold))
+		Ω(txArgs.Value.Int64()).Should(Equal(int64(1000)))
+	})
+
+	t.Run("Amount with the wrong sign", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewUnlockGold(signer, big.NewInt(1000)), 0)
+		operations[0].Type = analyzer.OpLockGold.String()
+		operations[1].Type = analyzer.OpLockGold.String()
+		_, err := TxArgsFromOperations(operations, nil)
+		Ω(err).Should(MatchError(ContainSubstring("wrong sign")))
+	})
+
+	t.Run("Missing group", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewVote(signer, group, big.NewInt(1000)), 0)
+		operations[1].Account.SubAccount.Metadata = nil
+		_, err := TxArgsFromOperations(operations, nil)
+		Ω(err).Should(MatchError(ContainSubstring("requires the group")))
+	})
+
+	t.Run("Requires metadata", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewRelockGold(signer, big.NewInt(1000)), 0)
+		_, err := TxArgsFromOperations(operations, nil)
+		Ω(err).Should(MatchError(ContainSubstring("relockGold requires the pending withdrawal index")))
+	})
+
+	t.Run("Method not matching the operations", func(t *testing.T) {
+		RegisterTestingT(t)
+		operations := OperationsFromAnalyzer(analyzer.NewVote(signer, group, big.NewInt(1000)), 0)
+		_, err := TxArgsFromOperations(operations, map[string]interface{}{
+			OptionsMethodKey: airgap.RevokePendingVotes.String(),
+			OptionsArgsKey:   []interface{}{signer.Hex(), group.Hex(), "1000"},
+		})
+		Ω(err).Should(MatchError(ContainSubstring("doesn't perform vote operations")))
+	})
+}
+
+func TestStableTokenTransferToOperations(t *testing.T) {
+	RegisterTestingT(t)
+
+	aop := analyzer.NewStableTokenTransfer(celotokens.CREAL, common.HexToAddress("1"), common.HexToAddress("2"), big.NewInt(10000))
+
+	Ω(OperationsFromAnalyzer(aop, 0)).Should(ConsistOf(
+		MatchOperation(common.HexToAddress("1"), -10000, CeloReal, OperationSuccess, analyzer.OpTransfer),
+		MatchOperation(common.HexToAddress("2"), 10000, CeloReal, OperationSuccess, analyzer.OpTransfer),
+	))
+}
+
+func TestTokenFor(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, currency := range AllCurrencies {
+		token, ok := TokenFor(&types.Currency{Symbol: currency.Symbol, Decimals: currency.Decimals})
+		Ω(ok).Should(BeTrue())
+		Ω(CurrencyFor(token)).Should(Equal(currency))
+	}
+
+	_, ok := TokenFor(&types.Currency{Symbol: "BTC", Decimals: 8})
+	Ω(ok).Should(BeFalse())
+}
+
+func TestNewSyncStatus(t *testing.T) {
+	RegisterTestingT(t)
+
+	matchStatus := func(stage string, current, target int64, synced bool) gtypes.GomegaMatcher {
+		return gs.PointTo(gs.MatchAllFields(gs.Fields{
+			"CurrentIndex": gs.PointTo(Equal(current)),
+			"TargetIndex":  gs.PointTo(Equal(target)),
+			"Stage":        gs.PointTo(Equal(stage)),
+			"Synced":       gs.PointTo(Equal(synced)),
+		}))
+	}
+
+	t.Run("Node syncing", func(t *testing.T) {
+		RegisterTestingT(t)
+		progress := &ethereum.SyncProgress{CurrentBlock: 100, HighestBlock: 200}
+		Ω(NewSyncStatus(progress, big.NewInt(100), big.NewInt(100), 5)).Should(matchStatus(SyncStageNodeSyncing, 100, 200, false))
+	})
+
+	t.Run("Indexer catching up", func(t *testing.T) {
+		RegisterTestingT(t)
+		Ω(NewSyncStatus(nil, big.NewInt(200), big.NewInt(100), 5)).Should(matchStatus(SyncStageIndexerCatchingUp, 100, 200, false))
+
+		// The node is within the threshold, but not the indexer
+		progress := &ethereum.SyncProgress{CurrentBlock: 198, HighestBlock: 200}
+		Ω(NewSyncStatus(progress, big.NewInt(198), big.NewInt(100), 5)).Should(matchStatus(SyncStageIndexerCatchingUp, 100, 200, false))
+
+		// Both are within the threshold of the next one, but the indexer isn't within the network's
+		progress = &ethereum.SyncProgress{CurrentBlock: 198, HighestBlock: 203}
+		Ω(NewSyncStatus(progress, big.NewInt(198), big.NewInt(195), 5)).Should(matchStatus(SyncStageIndexerCatchingUp, 195, 203, false))
+	})
+
+	t.Run("Synced", func(t *testing.T) {
+		RegisterTestingT(t)
+		Ω(NewSyncStatus(nil, big.NewInt(200), big.NewInt(195), 5)).Should(matchStatus(SyncStageSynced, 195, 200, true))
+
+		progress := &ethereum.SyncProgress{CurrentBlock: 198, HighestBlock: 200}
+		Ω(NewSyncStatus(progress, big.NewInt(198), big.NewInt(198), 5)).Should(matchStatus(SyncStageSynced, 198, 200, true))
+	})
+}
+
+func TestDeriveAccountIdentifier_AuthorizeSigner(t *testing.T) {
+	RegisterTestingT(t)
+	account, signer := common.HexToAddress("0xAbCd000000000000000000000000000000001111"), common.HexToAddress("0xaBcD000000000000000000000000000000002222")
+
+	traced := TransactionOperationsFromAnalyzer([]analyzer.Operation{*analyzer.NewAuthorizeSigner(account, signer, analyzer.OpAuthorizeVoteSigner)})
+	Ω(traced).Should(HaveLen(1))
+
+	derived, err := DeriveAccountIdentifier(signer, map[string]interface{}{
+		DeriveSubAccountKey: string(analyzer.AccSigner),
+		DeriveAccountKey:    strings.ToLower(account.Hex()),
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	// Clients compare the identifiers as served
+	tracedJSON, err := json.Marshal(traced[0].Account)
+	Ω(err).ShouldNot(HaveOccurred())
+	derivedJSON, err := json.Marshal(derived)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(derivedJSON).Should(MatchJSON(tracedJSON))
+}
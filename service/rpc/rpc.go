@@ -15,9 +15,13 @@
 package rpc
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/celo-org/celo-blockchain/log"
@@ -36,30 +40,69 @@ type RosettaServerConfig struct {
 	Port           uint
 	Interface      string
 	RequestTimeout time.Duration
+	// InlineBlockTransactions makes /block return traced transactions by default
+	InlineBlockTransactions bool
+	// BlockTraceWorkers bounds the concurrent transaction traces of an inlined /block
+	BlockTraceWorkers uint
+	// SyncThreshold is the max number of blocks the node and the indexer can lag behind to be reported as synced
+	SyncThreshold uint64
+	// Offline serves only the endpoints that need no node, the others fail with ErrUnavailableOffline
+	Offline bool
+	// NodeHealth makes the endpoints that need the node fail with ErrNodeUnavailable while it's down, nil if the node isn't supervised
+	NodeHealth *service.NodeHealth
 }
 
 func (hs *RosettaServerConfig) ListenAddress() string {
 	return fmt.Sprintf("%s:%d", hs.Interface, hs.Port)
 }
 
+// Network is a chain served by the rosetta server, with its own node and db
+type Network struct {
+	Client      *client.CeloClient
+	DB          db.RosettaDBReader
+	ChainParams *service.ChainParameters
+}
+
+// NetworkIdentifier is the rosetta identifier of the network, by chain id
+func (n *Network) NetworkIdentifier() *types.NetworkIdentifier {
+	return &types.NetworkIdentifier{
+		Blockchain: BlockchainName,
+		Network:    n.ChainParams.ChainId.String(),
+	}
+}
+
 type rosettaServer struct {
-	cc          *client.CeloClient
-	cfg         *RosettaServerConfig
-	chainParams *service.ChainParameters
+	networks []*Network
+	cfg      *RosettaServerConfig
 
 	running service.RunningLock
 	server  *http.Server
 }
 
 func NewRosettaServer(cc *client.CeloClient, db db.RosettaDBReader, cfg *RosettaServerConfig, chainParams *service.ChainParameters) (*rosettaServer, error) {
+	return NewMultiNetworkRosettaServer([]*Network{{Client: cc, DB: db, ChainParams: chainParams}}, cfg)
+}
+
+// NewMultiNetworkRosettaServer creates a server routing each request to its network by network_identifier
+func NewMultiNetworkRosettaServer(networks []*Network, cfg *RosettaServerConfig) (*rosettaServer, error) {
 	var mainHandler http.Handler
 	var err error
 
-	mainHandler, err = createRouter(cc, db, cfg, chainParams)
+	if len(networks) == 1 {
+		mainHandler, err = createRouter(networks[0].Client, networks[0].DB, cfg, networks[0].ChainParams)
+	} else {
+		mainHandler, err = createMultiNetworkRouter(networks, cfg)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.Offline {
+		mainHandler = offlineHandler(mainHandler)
+	} else if cfg.NodeHealth != nil {
+		mainHandler = nodeHealthHandler(mainHandler, cfg.NodeHealth)
+	}
+	mainHandler = inlineTransactionsHandler(mainHandler)
 	mainHandler = handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))(mainHandler)
 	mainHandler = requestLogHandler(mainHandler)
 	mainHandler = http.TimeoutHandler(mainHandler, cfg.RequestTimeout, "Request Timed out")
@@ -73,10 +116,9 @@ func NewRosettaServer(cc *client.CeloClient, db db.RosettaDBReader, cfg *Rosetta
 	}
 
 	return &rosettaServer{
-		cc:          cc,
-		cfg:         cfg,
-		server:      server,
-		chainParams: chainParams,
+		networks: networks,
+		cfg:      cfg,
+		server:   server,
 	}, nil
 }
 
@@ -116,6 +158,75 @@ func requestLogHandler(handler http.Handler) http.Handler {
 	})
 }
 
+// InlineTransactionsParam is the query parameter that overrides InlineBlockTransactions for a request
+const InlineTransactionsParam = "inline_transactions"
+
+type inlineTransactionsKey struct{}
+
+// inlineTransactionsHandler exposes the InlineTransactionsParam query parameter to the servicer through the request context
+func inlineTransactionsHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if param := r.URL.Query().Get(InlineTransactionsParam); param != "" {
+			inline, err := strconv.ParseBool(param)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %s", InlineTransactionsParam, param), http.StatusBadRequest)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), inlineTransactionsKey{}, inline))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// OnlineEndpoints are the endpoints that need a celo node
+var OnlineEndpoints = []string{
+	"/account/balance",
+	"/account/coins",
+	"/block",
+	"/block/transaction",
+	"/call",
+	"/construction/metadata",
+	"/construction/submit",
+	"/events/blocks",
+	"/mempool",
+	"/mempool/transaction",
+	"/network/status",
+	"/search/transactions",
+}
+
+// onlineEndpointsHandler rejects the OnlineEndpoints with the error returned by reject, if any
+func onlineEndpointsHandler(handler http.Handler, reject func(endpoint string) *types.Error) http.Handler {
+	online := make(map[string]bool, len(OnlineEndpoints))
+	for _, endpoint := range OnlineEndpoints {
+		online[endpoint] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if online[r.URL.Path] {
+			if rosettaErr := reject(r.URL.Path); rosettaErr != nil {
+				server.EncodeJSONResponse(rosettaErr, http.StatusInternalServerError, w)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// offlineHandler rejects the OnlineEndpoints with ErrUnavailableOffline
+func offlineHandler(handler http.Handler) http.Handler {
+	return onlineEndpointsHandler(handler, LogErrUnavailableOffline)
+}
+
+// nodeHealthHandler rejects the OnlineEndpoints with ErrNodeUnavailable while the node is down
+func nodeHealthHandler(handler http.Handler, health *service.NodeHealth) http.Handler {
+	return onlineEndpointsHandler(handler, func(endpoint string) *types.Error {
+		if health.Up() {
+			return nil
+		}
+		return LogErrNodeDown(endpoint)
+	})
+}
+
 func createRouter(celoClient *client.CeloClient, db db.RosettaDBReader, cfg *RosettaServerConfig, chainParams *service.ChainParameters) (http.Handler, error) {
 	servicer, err := NewServicer(celoClient, db, cfg, chainParams)
 	if err != nil {
@@ -127,7 +238,7 @@ func createRouter(celoClient *client.CeloClient, db db.RosettaDBReader, cfg *Ros
 		Network:    chainParams.ChainId.String(),
 	}
 
-	asserter, err := asserter.NewServer(analyzer.AllOperationTypesString(), true, []*types.NetworkIdentifier{network}, AllCallMethods())
+	asserter, err := asserter.NewServer(analyzer.AllOperationTypesString(), true, []*types.NetworkIdentifier{network}, AllCallMethods(), false)
 	if err != nil {
 		return nil, err
 	}
@@ -138,7 +249,102 @@ func createRouter(celoClient *client.CeloClient, db db.RosettaDBReader, cfg *Ros
 	MempoolApiController := server.NewMempoolAPIController(servicer, asserter)
 	NetworkApiController := server.NewNetworkAPIController(servicer, asserter)
 	CallApiController := server.NewCallAPIController(servicer, asserter)
+	SearchApiController := &searchAPIController{servicer: servicer, asserter: asserter}
+	EventsApiController := server.NewEventsAPIController(servicer, asserter)
 
-	router := server.NewRouter(AccountApiController, BlockApiController, ConstructionApiController, MempoolApiController, NetworkApiController, CallApiController)
+	router := server.NewRouter(AccountApiController, BlockApiController, ConstructionApiController, MempoolApiController, NetworkApiController, CallApiController, SearchApiController, EventsApiController)
 	return router, nil
 }
+
+// searchAPIController serves /search/transactions as server.SearchAPIController does,
+// with the extensions of SearchTransactionsRequest and SearchTransactionsResponse
+type searchAPIController struct {
+	servicer *Servicer
+	asserter *asserter.Asserter
+}
+
+func (c *searchAPIController) Routes() server.Routes {
+	return server.Routes{
+		{
+			Name:        "SearchTransactions",
+			Method:      http.MethodPost,
+			Pattern:     "/search/transactions",
+			HandlerFunc: c.SearchTransactions,
+		},
+	}
+}
+
+func (c *searchAPIController) SearchTransactions(w http.ResponseWriter, r *http.Request) {
+	request := &SearchTransactionsRequest{SearchTransactionsRequest: &types.SearchTransactionsRequest{}}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		server.EncodeJSONResponse(&types.Error{Message: err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	if err := c.asserter.SearchTransactionsRequest(request.SearchTransactionsRequest); err != nil {
+		server.EncodeJSONResponse(&types.Error{Message: err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	result, rosettaErr := c.servicer.SearchTransactionsInRange(r.Context(), request)
+	if rosettaErr != nil {
+		server.EncodeJSONResponse(rosettaErr, http.StatusInternalServerError, w)
+		return
+	}
+
+	server.EncodeJSONResponse(result, http.StatusOK, w)
+}
+
+// createMultiNetworkRouter creates a router per network, and dispatches each request to the router
+// of its network_identifier. /network/list is answered with all the networks.
+func createMultiNetworkRouter(networks []*Network, cfg *RosettaServerConfig) (http.Handler, error) {
+	routers := make(map[types.NetworkIdentifier]http.Handler, len(networks))
+	networkList := &types.NetworkListResponse{}
+	for _, network := range networks {
+		identifier := network.NetworkIdentifier()
+		if _, ok := routers[*identifier]; ok {
+			return nil, fmt.Errorf("network %s configured more than once", identifier.Network)
+		}
+
+		router, err := createRouter(network.Client, network.DB, cfg, network.ChainParams)
+		if err != nil {
+			return nil, err
+		}
+		routers[*identifier] = router
+		networkList.NetworkIdentifiers = append(networkList.NetworkIdentifiers, identifier)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/network/list" {
+			server.EncodeJSONResponse(networkList, http.StatusOK, w)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.EncodeJSONResponse(LogErrValidation(err), http.StatusInternalServerError, w)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var request struct {
+			NetworkIdentifier *types.NetworkIdentifier `json:"network_identifier"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			server.EncodeJSONResponse(LogErrValidation(err), http.StatusInternalServerError, w)
+			return
+		}
+		if request.NetworkIdentifier == nil {
+			server.EncodeJSONResponse(LogErrValidation(ErrMissingNetworkIdentifier), http.StatusInternalServerError, w)
+			return
+		}
+
+		// Sub-networks are not used, so they're not part of the key
+		router, ok := routers[types.NetworkIdentifier{Blockchain: request.NetworkIdentifier.Blockchain, Network: request.NetworkIdentifier.Network}]
+		if !ok {
+			server.EncodeJSONResponse(LogErrValidation(fmt.Errorf("%w: %s", ErrUnknownNetwork, request.NetworkIdentifier.Network)), http.StatusInternalServerError, w)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}), nil
+}
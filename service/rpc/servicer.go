@@ -16,14 +16,23 @@ package rpc
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"sync"
 	"time"
 
+	ethereum "github.com/celo-org/celo-blockchain"
 	"github.com/celo-org/celo-blockchain/accounts/abi/bind"
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	gethTypes "github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/ethclient"
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/kliento/celotokens"
 	"github.com/celo-org/kliento/client"
 	"github.com/celo-org/kliento/contracts"
 	"github.com/celo-org/kliento/contracts/helpers"
@@ -34,6 +43,9 @@ import (
 	"github.com/celo-org/rosetta/db"
 	"github.com/celo-org/rosetta/service"
 	"github.com/coinbase/rosetta-sdk-go/types"
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 // Servicer is a service that implents the logic for the Servicerr
@@ -44,18 +56,46 @@ type Servicer struct {
 	db          db.RosettaDBReader
 	chainParams *service.ChainParameters
 	airgap      airgap.Server
+	// airgapClient decodes calldata, it needs no connection to the node
+	airgapClient airgap.Client
 	// The timeout to use when performing transaction traces.
 	txTraceTimeout time.Duration
+	// Whether /block returns traced transactions instead of only their identifiers
+	inlineBlockTransactions bool
+	// Max number of concurrent transaction traces for an inlined /block
+	blockTraceWorkers int
+	// Recent block traces, shared by /block and /block/transaction
+	blockTransfers   *lru.Cache
+	blockTraceFlight singleflight.Group
+	// Max number of blocks the node and the indexer can lag behind to be reported as synced
+	syncThreshold uint64
 }
 
+// blockTransfersCacheSize is the number of block traces kept in memory
+const blockTransfersCacheSize = 16
+
 // NewServicer creates a default api service
 func NewServicer(celoClient *client.CeloClient, db db.RosettaDBReader, cfg *RosettaServerConfig, cp *service.ChainParameters) (*Servicer, error) {
-	srvCtx, err := server.NewServerContext(celoClient)
-	if err != nil {
-		return nil, err
+	// Without a node (offline mode) there's no airgap server, only the endpoints that need none are served
+	var airgapServer airgap.Server
+	if celoClient != nil {
+		srvCtx, err := server.NewServerContext(celoClient)
+		if err != nil {
+			return nil, err
+		}
+
+		airgapServer, err = server.NewAirgapServer(cp.ChainId, srvCtx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	blockTraceWorkers := int(cfg.BlockTraceWorkers)
+	if blockTraceWorkers < 1 {
+		blockTraceWorkers = 1
 	}
 
-	airgap, err := server.NewAirgapServer(cp.ChainId, srvCtx)
+	blockTransfers, err := lru.New(blockTransfersCacheSize)
 	if err != nil {
 		return nil, err
 	}
@@ -64,11 +104,26 @@ func NewServicer(celoClient *client.CeloClient, db db.RosettaDBReader, cfg *Rose
 		cc:             celoClient,
 		db:             db,
 		chainParams:    cp,
-		airgap:         airgap,
+		airgap:         airgapServer,
+		airgapClient:   airgap.NewClient(),
 		txTraceTimeout: cfg.RequestTimeout,
+
+		inlineBlockTransactions: cfg.InlineBlockTransactions,
+		blockTraceWorkers:       blockTraceWorkers,
+		syncThreshold:           cfg.SyncThreshold,
+		blockTransfers:          blockTransfers,
 	}, nil
 }
 
+// inlineTransactions returns whether /block should return traced transactions,
+// the request can override the server wide setting (see inlineTransactionsHandler)
+func (s *Servicer) inlineTransactions(ctx context.Context) bool {
+	if inline, ok := ctx.Value(inlineTransactionsKey{}).(bool); ok {
+		return inline
+	}
+	return s.inlineBlockTransactions
+}
+
 // Mempool - Get All Mempool Transactions
 func (s *Servicer) Mempool(ctx context.Context, request *types.NetworkRequest) (*types.MempoolResponse, *types.Error) {
 
@@ -86,8 +141,67 @@ func (s *Servicer) Mempool(ctx context.Context, request *types.NetworkRequest) (
 }
 
 // MempoolTransaction - Get a Mempool Transaction
+// Operations are predicted from the tx fields and calldata, as the tx has not been executed yet.
 func (s *Servicer) MempoolTransaction(ctx context.Context, request *types.MempoolTransactionRequest) (*types.MempoolTransactionResponse, *types.Error) {
-	return nil, LogErrUnimplemented("/mempool/transaction")
+	txHash := common.HexToHash(request.TransactionIdentifier.Hash)
+
+	content, err := s.cc.TxPool.Content(ctx)
+	if err != nil {
+		return nil, LogErrCeloClient("TxPoolContent", err)
+	}
+
+	rpcTx := TxFromTxPoolContent(content, txHash)
+	if rpcTx == nil {
+		return nil, LogErrValidation(ErrMissingTxInMempool)
+	}
+	tx := RPCTransactionToTransaction(rpcTx)
+
+	lastPersistedBlock, err := s.db.LastPersistedBlock(ctx)
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	lastHeader, err := s.cc.Eth.HeaderByNumber(ctx, lastPersistedBlock)
+	if err != nil {
+		return nil, LogErrCeloClient("HeaderByNumber", err)
+	}
+
+	// Unknown calldata is not an error, we just can't predict more than the value transfer
+	method, args, err := s.airgapClient.ParseMethodAndArgs(tx.Data())
+	if err != nil {
+		method, args = nil, nil
+	}
+
+	tracer := analyzer.NewTracer(
+		ctx,
+		s.cc,
+		s.db,
+		s.txTraceTimeout,
+		s.chainParams.IsGingerbread(new(big.Int).Add(lastHeader.Number, big.NewInt(1))),
+	)
+
+	ops, err := tracer.PredictTransaction(lastHeader, tx, rpcTx.From, method, args)
+	if err != nil {
+		return nil, LogErrInternal(err, "txHash", txHash.Hex())
+	}
+
+	var operations []*types.Operation
+	for _, aop := range ops {
+		// TODO - revisit
+		// nolint:gosec
+		operations = append(operations, OperationsFromAnalyzer(&aop, int64(len(operations)))...)
+	}
+	MarkOperationsAsPredicted(operations)
+
+	return &types.MempoolTransactionResponse{
+		Transaction: &types.Transaction{
+			TransactionIdentifier: &types.TransactionIdentifier{Hash: txHash.Hex()},
+			Operations:            operations,
+		},
+		Metadata: map[string]interface{}{
+			OperationPredictedKey: true,
+		},
+	}, nil
 }
 
 func (s *Servicer) NetworkList(ctx context.Context, request *types.MetadataRequest) (*types.NetworkListResponse, *types.Error) {
@@ -107,19 +221,21 @@ func (s *Servicer) NetworkOptions(ctx context.Context, request *types.NetworkReq
 			RosettaVersion:    RosettaVersion,
 			NodeVersion:       NodeVersion,
 			MiddlewareVersion: &MiddlewareVersion,
+			// Allow has no field for it, so the supported currencies are advertised here
+			Metadata: map[string]interface{}{
+				"currencies": AllCurrencies,
+			},
 		},
 		Allow: &types.Allow{
 			OperationStatuses: []*types.OperationStatus{
 				OperationFailed.ToOperationStatus(),
 				OperationSuccess.ToOperationStatus(),
 			},
-			OperationTypes: analyzer.AllOperationTypesString(),
-			Errors: []*types.Error{
-				ErrValidation,
-				ErrUnimplemented,
-				ErrInternal,
-				ErrCeloClient,
-			},
+			OperationTypes:          analyzer.AllOperationTypesString(),
+			Errors:                  AllErrors,
+			HistoricalBalanceLookup: true,
+			CallMethods:             AllCallMethods(),
+			BalanceExemptions:       BalanceExemptions(),
 		},
 	}
 
@@ -149,18 +265,37 @@ func (s *Servicer) NetworkStatus(ctx context.Context, request *types.NetworkRequ
 		return nil, LogErrCeloClient("AdminPeers", err)
 	}
 
+	syncProgress, err := s.cc.Eth.SyncProgress(ctx)
+	if err != nil {
+		return nil, LogErrCeloClient("SyncProgress", err)
+	}
+
+	nodeHeader, err := s.cc.Eth.HeaderByNumber(ctx, nil) // nil == latest
+	if err != nil {
+		return nil, LogErrCeloClient("HeaderByNumber", err)
+	}
+
 	response := types.NetworkStatusResponse{
 		CurrentBlockIdentifier: HeaderToBlockIdentifier(latestHeader),
 		CurrentBlockTimestamp:  int64(latestHeader.Time * 1000),
 		GenesisBlockIdentifier: HeaderToBlockIdentifier(genesisHeader),
+		SyncStatus:             NewSyncStatus(syncProgress, nodeHeader.Number, lastPersitedBlock, s.syncThreshold),
 		Peers:                  PeersFromInfo(peersInfo),
 	}
 	return &response, nil
 }
 
+// AccountCoins - Get an Account's Unspent Coins
+func (s *Servicer) AccountCoins(ctx context.Context, request *types.AccountCoinsRequest) (*types.AccountCoinsResponse, *types.Error) {
+	return nil, LogErrUnimplemented("/account/coins")
+}
+
 // AccountBalance - Get an Account Balance
 func (s *Servicer) AccountBalance(ctx context.Context, request *types.AccountBalanceRequest) (*types.AccountBalanceResponse, *types.Error) {
 
+	if !common.IsHexAddress(request.AccountIdentifier.Address) {
+		return nil, LogErrInvalidAddress(request.AccountIdentifier.Address)
+	}
 	accountAddr := common.HexToAddress(request.AccountIdentifier.Address)
 
 	blockHeader, errRsp := s.blockHeader(ctx, request.BlockIdentifier)
@@ -186,12 +321,21 @@ func (s *Servicer) AccountBalance(ctx context.Context, request *types.AccountBal
 	subAccount := request.AccountIdentifier.SubAccount
 
 	if subAccount == nil || subAccount.Address == string(analyzer.AccSigner) {
-		// Main Account or Signer => just cGLD
-		goldAmt, err := s.cc.Eth.BalanceAt(ctx, accountAddr, blockHeader.Number)
-		if err != nil {
-			return nil, LogErrCeloClient("BalanceAt", err)
+		// Main Account or Signer => cGLD and the requested stable tokens
+		balances, errRsp := s.tokenBalances(ctx, accountAddr, requestedBlockOpts, request.Currencies)
+		if errRsp != nil {
+			return nil, errRsp
+		}
+		return &types.AccountBalanceResponse{
+			BlockIdentifier: HeaderToBlockIdentifier(&blockHeader.Header),
+			Balances:        balances,
+		}, nil
+	}
+
+	for _, currency := range request.Currencies {
+		if token, _ := TokenFor(currency); token != celotokens.CELO {
+			return nil, LogErrValidation(fmt.Errorf("%w: sub-accounts only hold %s", ErrUnsupportedCurrency, CeloGold.Symbol))
 		}
-		return createResponse(NewAmount(goldAmt, CeloGold)), nil
 	}
 
 	lenRg := len("ReleaseGold")
@@ -224,11 +368,11 @@ func (s *Servicer) AccountBalance(ctx context.Context, request *types.AccountBal
 		case string(analyzer.AccReleaseGoldUnvestedLocked):
 			celoGoldAmount, err = releaseGold.GetRemainingLockedBalance(requestedBlockOpts)
 		default:
-			return nil, LogErrValidation(fmt.Errorf("Subaccount must be %s, %s, or %s",
+			return nil, LogErrUnsupportedSubAccount(subAccount.Address,
 				string(analyzer.AccReleaseGoldVested),
 				string(analyzer.AccReleaseGoldUnvestedLocked),
 				string(analyzer.AccReleaseGoldUnvestedUnLocked),
-			))
+			)
 		}
 		if err != nil {
 			return nil, LogErrCeloClient(subAccount.Address, err)
@@ -280,6 +424,22 @@ func (s *Servicer) AccountBalance(ctx context.Context, request *types.AccountBal
 		return createResponse(NewAmount(totalPending, CeloGold)), nil
 	}
 
+	if subAccount.Address == string(analyzer.AccGovernanceRefundedDeposit) {
+		governance, err := registry.GetGovernanceContract(ctx, nil)
+		if err == client.ErrContractNotDeployed {
+			return emptyResponse, nil
+		} else if err != nil {
+			return nil, LogErrCeloClient("NewGovernance", err)
+		}
+
+		refundedDeposits, err := governance.RefundedDeposits(requestedBlockOpts, accountAddr)
+		if err != nil {
+			return nil, LogErrCeloClient("RefundedDeposits", err)
+		}
+
+		return createResponse(NewAmount(refundedDeposits, CeloGold)), nil
+	}
+
 	// If we are here need to be election based
 
 	// Fetch Election (Votes) Balances
@@ -335,7 +495,17 @@ func (s *Servicer) AccountBalance(ctx context.Context, request *types.AccountBal
 			voteBalance = sumVotes(votes)
 		}
 	} else {
-		return nil, LogErrCeloClient("InvalidAccountIdentifier", nil)
+		return nil, LogErrUnsupportedSubAccount(subAccount.Address,
+			string(analyzer.AccSigner),
+			string(analyzer.AccLockedGoldNonVoting),
+			string(analyzer.AccLockedGoldPending),
+			string(analyzer.AccLockedGoldVotingPending),
+			string(analyzer.AccLockedGoldVotingActive),
+			string(analyzer.AccReleaseGoldVested),
+			string(analyzer.AccReleaseGoldUnvestedLocked),
+			string(analyzer.AccReleaseGoldUnvestedUnLocked),
+			string(analyzer.AccGovernanceRefundedDeposit),
+		)
 	}
 
 	return createResponse(NewAmount(voteBalance, CeloGold)), nil
@@ -356,14 +526,24 @@ func (s *Servicer) Block(ctx context.Context, request *types.BlockRequest) (*typ
 		transactions = append(transactions, &types.TransactionIdentifier{Hash: blockHeader.Hash().Hex()})
 	}
 
-	return &types.BlockResponse{
+	response := &types.BlockResponse{
 		Block: &types.Block{
 			BlockIdentifier:       HeaderToBlockIdentifier(&blockHeader.Header),
 			ParentBlockIdentifier: HeaderToParentBlockIdentifier(&blockHeader.Header),
 			Timestamp:             int64(blockHeader.Time * 1000), // TODO unsafe casting from uint to int 64
 		},
-		OtherTransactions: transactions,
-	}, nil
+	}
+
+	if !s.inlineTransactions(ctx) {
+		response.OtherTransactions = transactions
+		return response, nil
+	}
+
+	response.Block.Transactions, err = s.blockTransactions(ctx, blockHeader, transactions)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 
 }
 
@@ -375,17 +555,70 @@ func (S *Servicer) BlockTransaction(ctx context.Context, request *types.BlockTra
 		return nil, err
 	}
 
-	txHash := common.HexToHash(request.TransactionIdentifier.Hash)
+	transaction, err := S.blockTransaction(ctx, blockHeader, common.HexToHash(request.TransactionIdentifier.Hash), nil)
+	if err != nil {
+		return nil, err
+	}
 
-	var operations []*types.Operation
+	return &types.BlockTransactionResponse{
+		Transaction: transaction,
+	}, nil
+}
+
+// blockTransactions traces the block's transactions with at most blockTraceWorkers concurrent traces.
+// Transactions are returned in the same order as txIds.
+func (s *Servicer) blockTransactions(ctx context.Context, blockHeader *ethclient.HeaderAndTxnHashes, txIds []*types.TransactionIdentifier) ([]*types.Transaction, *types.Error) {
+	transactions := make([]*types.Transaction, len(txIds))
+
+	var firstErr *types.Error
+	var errOnce sync.Once
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(s.blockTraceWorkers)
+	var tracer *analyzer.Tracer
+	if !s.blockIndexed(ctx, blockHeader) {
+		tracer = s.blockTracer(groupCtx, blockHeader)
+	}
+	for i, txId := range txIds {
+		i, txHash := i, common.HexToHash(txId.Hash)
+		group.Go(func() error {
+			transaction, err := s.blockTransaction(groupCtx, blockHeader, txHash, tracer)
+			if err != nil {
+				errOnce.Do(func() { firstErr = err })
+				return errors.New(err.Message)
+			}
+			transactions[i] = transaction
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, firstErr
+	}
+	return transactions, nil
+}
+
+// blockTransaction computes the operations of a tx in the block, or of the epoch rewards
+// pseudo-transaction when txHash is the hash of the last block of an epoch.
+// Operations stored by the monitor are used when available.
+// If tracer is nil, a block tracer is created for the tx.
+func (S *Servicer) blockTransaction(ctx context.Context, blockHeader *ethclient.HeaderAndTxnHashes, txHash common.Hash, tracer *analyzer.Tracer) (*types.Transaction, *types.Error) {
+	ops, found, rosettaErr := S.storedOperations(ctx, blockHeader, txHash)
+	if rosettaErr != nil {
+		return nil, rosettaErr
+	}
+
+	switch {
+	case found:
+		// Operations were computed when indexing the block
 	// Check If it's block transaction (imaginary transaction)
-	if S.chainParams.IsLastBlockOfEpoch(blockHeader.Number.Uint64()) && txHash == blockHeader.Hash() {
+	case S.chainParams.IsLastBlockOfEpoch(blockHeader.Number.Uint64()) && txHash == blockHeader.Hash():
 		rewards, err := analyzer.ComputeEpochRewards(ctx, S.cc, S.db, &blockHeader.Header)
 		if err != nil {
 			return nil, LogErrCeloClient("ComputeEpochRewards", err)
 		}
-		operations = OperationsFromAnalyzer(rewards, 0)
-	} else {
+		ops = rewards
+	default:
 		// Normal transaction
 
 		if !HeaderContainsTx(blockHeader, txHash) {
@@ -402,35 +635,325 @@ func (S *Servicer) BlockTransaction(ctx context.Context, request *types.BlockTra
 			return nil, LogErrCeloClient("TransactionReceipt", err)
 		}
 
-		tracer := analyzer.NewTracer(
-			ctx,
-			S.cc,
-			S.db,
-			S.txTraceTimeout,
-			S.chainParams.IsGingerbread(blockHeader.Number),
-		)
+		if tracer == nil {
+			tracer = S.blockTracer(ctx, blockHeader)
+		}
 
-		ops, err := tracer.TraceTransaction(&blockHeader.Header, tx, receipt)
+		ops, err = tracer.TraceTransaction(&blockHeader.Header, tx, receipt)
 		if err != nil {
 			return nil, LogErrCeloClient("TraceTransaction", err)
 		}
+	}
+
+	return &types.Transaction{
+		TransactionIdentifier: &types.TransactionIdentifier{Hash: txHash.Hex()},
+		Operations:            TransactionOperationsFromAnalyzer(ops),
+	}, nil
+}
+
+// storedOperations reads the operations of a tx from the store populated by the monitor
+func (s *Servicer) storedOperations(ctx context.Context, blockHeader *ethclient.HeaderAndTxnHashes, txHash common.Hash) ([]analyzer.Operation, bool, *types.Error) {
+	if s.db == nil {
+		return nil, false, nil
+	}
+
+	data, err := s.db.TransactionOperations(ctx, blockHeader.Hash(), txHash, analyzer.OperationsVersion)
+	if err == db.ErrOperationsNotFound {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, LogErrInternal(err, "blockNumber", blockHeader.Number, "txHash", txHash.Hex())
+	}
+
+	ops, err := analyzer.DecodeOperations(data)
+	if err != nil {
+		return nil, false, LogErrInternal(err, "blockNumber", blockHeader.Number, "txHash", txHash.Hex())
+	}
+	return ops, true, nil
+}
+
+// blockIndexed returns whether the operations of the block were stored by the monitor
+func (s *Servicer) blockIndexed(ctx context.Context, blockHeader *ethclient.HeaderAndTxnHashes) bool {
+	if s.db == nil {
+		return false
+	}
+	hash, err := s.db.IndexedBlockHash(ctx, blockHeader.Number, analyzer.OperationsVersion)
+	return err == nil && hash == blockHeader.Hash()
+}
+
+// Limits of /events/blocks results per call
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+// EventsBlocks - Get the blocks added and removed by the monitor, in order
+func (s *Servicer) EventsBlocks(ctx context.Context, request *types.EventsBlocksRequest) (*types.EventsBlocksResponse, *types.Error) {
+	if s.db == nil {
+		return nil, LogErrUnimplemented("/events/blocks")
+	}
+
+	maxSequence, err := s.db.MaxBlockEventSequence(ctx)
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	limit := int64(defaultEventsLimit)
+	if request.Limit != nil && *request.Limit > 0 {
+		limit = *request.Limit
+	}
+	if limit > maxEventsLimit {
+		limit = maxEventsLimit
+	}
+
+	// Without offset, return the last events up to the tip
+	offset := maxSequence - limit + 1
+	if request.Offset != nil {
+		offset = *request.Offset
+	} else if offset < 0 {
+		offset = 0
+	}
+
+	events, err := s.db.BlockEvents(ctx, offset, limit)
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	response := &types.EventsBlocksResponse{
+		Events: make([]*types.BlockEvent, len(events)),
+	}
+	// No events yet is reported as -1 by the db, but rosetta requires a non negative value
+	if maxSequence >= 0 {
+		response.MaxSequence = maxSequence
+	}
+	for i, event := range events {
+		response.Events[i] = &types.BlockEvent{
+			Sequence:        event.Sequence,
+			BlockIdentifier: &types.BlockIdentifier{Index: event.BlockNumber.Int64(), Hash: event.BlockHash.Hex()},
+			Type:            types.BlockEventType(event.Type),
+		}
+	}
+	return response, nil
+}
+
+// Limits of /search/transactions results per call
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// SearchTransactionsRequest extends the /search/transactions request with the lowest block to search
+type SearchTransactionsRequest struct {
+	*types.SearchTransactionsRequest
+	MinBlock *int64 `json:"min_block,omitempty"`
+}
+
+// SearchTransactionsResponse extends the /search/transactions response with the highest block searched.
+// It must be sent as max_block when fetching the next pages, so blocks indexed meanwhile don't shift the offsets.
+type SearchTransactionsResponse struct {
+	*types.SearchTransactionsResponse
+	MaxBlock int64 `json:"max_block"`
+}
+
+// SearchTransactions - Search the transactions whose operations were stored by the monitor
+func (s *Servicer) SearchTransactions(ctx context.Context, request *types.SearchTransactionsRequest) (*types.SearchTransactionsResponse, *types.Error) {
+	response, rosettaErr := s.SearchTransactionsInRange(ctx, &SearchTransactionsRequest{SearchTransactionsRequest: request})
+	if rosettaErr != nil {
+		return nil, rosettaErr
+	}
+	return response.SearchTransactionsResponse, nil
+}
+
+// SearchTransactionsInRange - Search the transactions whose operations were stored by the monitor, optionally
+// from a min block, reporting the max block searched
+func (s *Servicer) SearchTransactionsInRange(ctx context.Context, request *SearchTransactionsRequest) (*SearchTransactionsResponse, *types.Error) {
+	if s.db == nil {
+		return nil, LogErrUnimplemented("/search/transactions")
+	}
+
+	query, rosettaErr := s.transactionQuery(ctx, request)
+	if rosettaErr != nil {
+		return nil, rosettaErr
+	}
+
+	refs, total, err := s.db.SearchTransactions(ctx, query)
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	transactions := make([]*types.BlockTransaction, len(refs))
+	for i, ref := range refs {
+		transaction, rosettaErr := s.searchedTransaction(ctx, ref)
+		if rosettaErr != nil {
+			return nil, rosettaErr
+		}
 
-		for _, aop := range ops {
-			// TODO - revisit
-			// nolint:gosec
-			transferOps := OperationsFromAnalyzer(&aop, int64(len(operations)))
-			operations = append(operations, transferOps...)
+		transactions[i] = &types.BlockTransaction{
+			BlockIdentifier: &types.BlockIdentifier{Index: ref.BlockNumber.Int64(), Hash: ref.BlockHash.Hex()},
+			Transaction:     transaction,
 		}
 	}
 
-	return &types.BlockTransactionResponse{
-		Transaction: &types.Transaction{
-			TransactionIdentifier: &types.TransactionIdentifier{Hash: txHash.Hex()},
-			Operations:            operations,
-		},
+	response := &types.SearchTransactionsResponse{
+		Transactions: transactions,
+		TotalCount:   total,
+	}
+	if nextOffset := query.Offset + int64(len(refs)); nextOffset < total {
+		response.NextOffset = &nextOffset
+	}
+	return &SearchTransactionsResponse{SearchTransactionsResponse: response, MaxBlock: query.MaxBlock.Int64()}, nil
+}
+
+// searchedTransaction returns the stored operations of a tx found in the account index, or traces it
+// when they were stored by another version of the analyzer and the block is yet to be indexed again
+func (s *Servicer) searchedTransaction(ctx context.Context, ref db.TransactionRef) (*types.Transaction, *types.Error) {
+	data, err := s.db.TransactionOperations(ctx, ref.BlockHash, ref.TxHash, analyzer.OperationsVersion)
+	if err == db.ErrOperationsNotFound {
+		blockHeader, err := s.cc.Eth.HeaderAndTxnHashesByHash(ctx, ref.BlockHash)
+		if err != nil {
+			return nil, LogErrCeloClient("HeaderAndTxnHashesByHash", err)
+		}
+		return s.blockTransaction(ctx, blockHeader, ref.TxHash, nil)
+	} else if err != nil {
+		return nil, LogErrInternal(err, "blockNumber", ref.BlockNumber, "txHash", ref.TxHash.Hex())
+	}
+
+	ops, err := analyzer.DecodeOperations(data)
+	if err != nil {
+		return nil, LogErrInternal(err, "blockNumber", ref.BlockNumber, "txHash", ref.TxHash.Hex())
+	}
+	return &types.Transaction{
+		TransactionIdentifier: &types.TransactionIdentifier{Hash: ref.TxHash.Hex()},
+		Operations:            TransactionOperationsFromAnalyzer(ops),
 	}, nil
 }
 
+// transactionQuery maps the search request to a query of the account index
+func (s *Servicer) transactionQuery(ctx context.Context, request *SearchTransactionsRequest) (*db.TransactionQuery, *types.Error) {
+	if request.CoinIdentifier != nil {
+		return nil, LogErrValidation(ErrUnsupportedCoins)
+	}
+
+	query := &db.TransactionQuery{
+		Or:    request.Operator != nil && *request.Operator == types.OR,
+		Limit: defaultSearchLimit,
+		Type:  request.Type,
+	}
+	if request.Offset != nil {
+		query.Offset = *request.Offset
+	}
+	if request.Limit != nil && *request.Limit > 0 {
+		query.Limit = *request.Limit
+		if query.Limit > maxSearchLimit {
+			query.Limit = maxSearchLimit
+		}
+	}
+
+	if request.MaxBlock != nil {
+		query.MaxBlock = big.NewInt(*request.MaxBlock)
+	} else {
+		lastIndexed, err := s.db.LastIndexedBlock(ctx)
+		if err != nil {
+			return nil, LogErrInternal(err)
+		}
+		query.MaxBlock = lastIndexed
+	}
+	if request.MinBlock != nil {
+		if *request.MinBlock < 0 {
+			return nil, LogErrValidation(fmt.Errorf("min_block must not be negative"))
+		}
+		query.MinBlock = big.NewInt(*request.MinBlock)
+	}
+
+	if request.TransactionIdentifier != nil {
+		txHash := common.HexToHash(request.TransactionIdentifier.Hash)
+		query.TxHash = &txHash
+	}
+
+	if request.AccountIdentifier != nil {
+		addr, err := addressFromAccountIdentifier(request.AccountIdentifier)
+		if err != nil {
+			return nil, LogErrInvalidAddress(request.AccountIdentifier.Address)
+		}
+		subAccount := ""
+		if request.AccountIdentifier.SubAccount != nil {
+			subAccount = request.AccountIdentifier.SubAccount.Address
+		}
+		query.Address = &addr
+		query.SubAccount = &subAccount
+	}
+	if request.Address != nil {
+		if !common.IsHexAddress(*request.Address) {
+			return nil, LogErrInvalidAddress(*request.Address)
+		}
+		addr := common.HexToAddress(*request.Address)
+		if query.Address != nil && *query.Address != addr {
+			return nil, LogErrValidation(ErrConflictingSearchConditions)
+		}
+		query.Address = &addr
+	}
+
+	if request.Status != nil {
+		var successful bool
+		switch OperationResult(*request.Status) {
+		case OperationSuccess:
+			successful = true
+		case OperationFailed:
+			successful = false
+		default:
+			return nil, LogErrValidation(fmt.Errorf("unknown operation status: %s", *request.Status))
+		}
+		query.Successful = &successful
+	}
+	if request.Success != nil {
+		if query.Successful != nil && *query.Successful != *request.Success {
+			return nil, LogErrValidation(ErrConflictingSearchConditions)
+		}
+		query.Successful = request.Success
+	}
+
+	if request.Currency != nil {
+		token, ok := TokenFor(request.Currency)
+		if !ok {
+			return nil, LogErrValidation(ErrUnsupportedCurrency)
+		}
+		currency := string(token)
+		query.Currency = &currency
+	}
+
+	return query, nil
+}
+
+// blockTracer creates a tracer that uses a single trace of the whole block for the transfers of its txs.
+// If the block trace fails, the tracer falls back to tracing each tx.
+func (s *Servicer) blockTracer(ctx context.Context, blockHeader *ethclient.HeaderAndTxnHashes) *analyzer.Tracer {
+	tracer := analyzer.NewTracer(
+		ctx,
+		s.cc,
+		s.db,
+		s.txTraceTimeout,
+		s.chainParams.IsGingerbread(blockHeader.Number),
+	)
+
+	blockHash := blockHeader.Hash()
+	if cached, ok := s.blockTransfers.Get(blockHash); ok {
+		return tracer.WithBlockTransfers(cached.(analyzer.BlockTransfers))
+	}
+
+	transfers, err, _ := s.blockTraceFlight.Do(blockHash.Hex(), func() (interface{}, error) {
+		transfers, err := tracer.TraceBlockTransfers(blockHash, blockHeader.Number, blockHeader.Transactions)
+		if err != nil {
+			return nil, err
+		}
+		s.blockTransfers.Add(blockHash, transfers)
+		return transfers, nil
+	})
+	if err != nil {
+		log.Warn("Block trace failed, tracing transactions individually", "blockNumber", blockHeader.Number, "err", err)
+		return tracer
+	}
+	return tracer.WithBlockTransfers(transfers.(analyzer.BlockTransfers))
+}
+
 type CallMethod string
 
 const CeloCall CallMethod = "celo_call"
@@ -510,46 +1033,181 @@ func (s *Servicer) Call(ctx context.Context, request *types.CallRequest) (*types
 	return nil, LogErrValidation(fmt.Errorf("unsupported method '%s'", request.Method))
 }
 
-func (s *Servicer) ConstructionCombine(
-	context.Context,
-	*types.ConstructionCombineRequest,
-) (*types.ConstructionCombineResponse, *types.Error) {
-	return nil, LogErrUnimplemented("ConstructionCombine")
+// ConstructionCombine - Attach the signature to an unsigned transaction
+func (s *Servicer) ConstructionCombine(ctx context.Context, request *types.ConstructionCombineRequest) (*types.ConstructionCombineResponse, *types.Error) {
+	var tx airgap.Transaction
+	if err := json.Unmarshal([]byte(request.UnsignedTransaction), &tx); err != nil {
+		return nil, LogErrValidation(err)
+	}
+	if tx.TxMetadata == nil {
+		return nil, LogErrValidation(fmt.Errorf("unsigned transaction has no metadata"))
+	}
+
+	if len(request.Signatures) != 1 {
+		return nil, LogErrValidation(fmt.Errorf("%w: expected 1 signature, got %d", ErrBadSignature, len(request.Signatures)))
+	}
+	signature := request.Signatures[0]
+	if signature.SignatureType != types.EcdsaRecovery || len(signature.Bytes) != crypto.SignatureLength {
+		return nil, LogErrValidation(fmt.Errorf("%w: expected a %d bytes %s signature", ErrBadSignature, crypto.SignatureLength, types.EcdsaRecovery))
+	}
+	tx.Signature = signature.Bytes
+
+	// Make sure the signature belongs to the sender, otherwise the node would attribute the tx to another account
+	gethTx, err := tx.AsGethTransaction()
+	if err != nil {
+		return nil, LogErrValidation(fmt.Errorf("%w: %s", ErrBadSignature, err))
+	}
+	sender, err := gethTypes.Sender(gethTypes.NewEIP155Signer(tx.ChainId), gethTx)
+	if err != nil || sender != tx.From {
+		return nil, LogErrValidation(fmt.Errorf("%w: signer doesn't match sender %s", ErrBadSignature, tx.From.Hex()))
+	}
+
+	signedTx, err := tx.Serialize()
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	return &types.ConstructionCombineResponse{
+		SignedTransaction: hexutil.Encode(signedTx),
+	}, nil
 }
 
-func (s *Servicer) ConstructionDerive(
-	context.Context,
-	*types.ConstructionDeriveRequest,
-) (*types.ConstructionDeriveResponse, *types.Error) {
-	return nil, LogErrUnimplemented("ConstructionDerive")
+// ConstructionDerive - Derive the account identifier of a secp256k1 public key
+// metadata.sub_account selects a sub-account the key controls:
+//   - AccountsAuthorizedSigner: the key is a signer of metadata.account
+//   - ReleaseGold*: the key is the beneficiary of the metadata.release_gold instance
+func (s *Servicer) ConstructionDerive(ctx context.Context, request *types.ConstructionDeriveRequest) (*types.ConstructionDeriveResponse, *types.Error) {
+	if request.PublicKey == nil || request.PublicKey.CurveType != types.Secp256k1 {
+		return nil, LogErrValidation(fmt.Errorf("Public key must use the %s curve", types.Secp256k1))
+	}
+
+	addr, err := s.airgapClient.DeriveAddress(request.PublicKey.Bytes)
+	if err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	accountIdentifier, err := DeriveAccountIdentifier(*addr, request.Metadata)
+	if err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	return &types.ConstructionDeriveResponse{
+		AccountIdentifier: accountIdentifier,
+	}, nil
 }
 
-func (s *Servicer) ConstructionHash(
-	context.Context,
-	*types.ConstructionHashRequest,
-) (*types.TransactionIdentifierResponse, *types.Error) {
-	return nil, LogErrUnimplemented("ConstructionHash")
+// ConstructionHash - Get the hash of a signed transaction
+func (s *Servicer) ConstructionHash(ctx context.Context, request *types.ConstructionHashRequest) (*types.TransactionIdentifierResponse, *types.Error) {
+	var tx airgap.Transaction
+	if err := tx.Deserialize(common.FromHex(request.SignedTransaction), s.chainParams.ChainId); err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	txHash, err := tx.Hash()
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	return &types.TransactionIdentifierResponse{
+		TransactionIdentifier: &types.TransactionIdentifier{
+			Hash: txHash.Hex(),
+		},
+	}, nil
 }
 
-func (s *Servicer) ConstructionParse(
-	context.Context,
-	*types.ConstructionParseRequest,
-) (*types.ConstructionParseResponse, *types.Error) {
-	return nil, LogErrUnimplemented("ConstructionParse")
+// ConstructionParse - Describe the operations of an unsigned or signed transaction
+// Calldata is decoded offline, so selectors shared between core contracts and ReleaseGold
+// resolve to the core contract.
+func (s *Servicer) ConstructionParse(ctx context.Context, request *types.ConstructionParseRequest) (*types.ConstructionParseResponse, *types.Error) {
+	var tx airgap.Transaction
+	if request.Signed {
+		if err := tx.Deserialize(common.FromHex(request.Transaction), s.chainParams.ChainId); err != nil {
+			return nil, LogErrValidation(err)
+		}
+	} else {
+		if err := json.Unmarshal([]byte(request.Transaction), &tx); err != nil {
+			return nil, LogErrValidation(err)
+		}
+		if tx.TxMetadata == nil {
+			return nil, LogErrValidation(fmt.Errorf("unsigned transaction has no metadata"))
+		}
+	}
+
+	txArgs, err := s.airgapClient.ParseTxArgs(tx.TxMetadata)
+	if err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	response := types.ConstructionParseResponse{
+		Operations: OperationsFromTxArgs(txArgs, s.coreContractAddresses(ctx)),
+	}
+	if request.Signed {
+		response.AccountIdentifierSigners = []*types.AccountIdentifier{{Address: tx.From.Hex()}}
+	}
+	return &response, nil
 }
 
-func (s *Servicer) ConstructionPayloads(
-	context.Context,
-	*types.ConstructionPayloadsRequest,
-) (*types.ConstructionPayloadsResponse, *types.Error) {
-	return nil, LogErrUnimplemented("ConstructionPayloads")
+// ConstructionPayloads - Build the unsigned transaction and the payload the sender must sign
+// The unsigned transaction is the json representation of airgap.Transaction.
+func (s *Servicer) ConstructionPayloads(ctx context.Context, request *types.ConstructionPayloadsRequest) (*types.ConstructionPayloadsResponse, *types.Error) {
+	var txMetadata airgap.TxMetadata
+	if err := airgap.UnmarshallFromMap(request.Metadata, &txMetadata); err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	if !OperationsInvolve(request.Operations, txMetadata.From) {
+		return nil, LogErrValidation(fmt.Errorf("%w: sender %s is not part of the operations", ErrUnsupportedOperation, txMetadata.From.Hex()))
+	}
+
+	// The signed tx must perform the requested operations and nothing else
+	txArgs, err := s.airgapClient.ParseTxArgs(&txMetadata)
+	if err != nil {
+		return nil, LogErrValidation(err)
+	}
+	if err := VerifyTxArgs(request.Operations, txArgs, s.coreContractAddresses(ctx)); err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	tx, err := s.airgapClient.ConstructTxFromMetadata(&txMetadata)
+	if err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	signingHash, err := tx.SigningHash()
+	if err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	unsignedTx, err := json.Marshal(tx)
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	return &types.ConstructionPayloadsResponse{
+		UnsignedTransaction: string(unsignedTx),
+		Payloads: []*types.SigningPayload{{
+			AccountIdentifier: &types.AccountIdentifier{Address: txMetadata.From.Hex()},
+			Bytes:             signingHash.Bytes(),
+			SignatureType:     types.EcdsaRecovery,
+		}},
+	}, nil
 }
 
-func (s *Servicer) ConstructionPreprocess(
-	context.Context,
-	*types.ConstructionPreprocessRequest,
-) (*types.ConstructionPreprocessResponse, *types.Error) {
-	return nil, LogErrUnimplemented("ConstructionPreprocess")
+// ConstructionPreprocess - Translate operations into the options for /construction/metadata
+func (s *Servicer) ConstructionPreprocess(ctx context.Context, request *types.ConstructionPreprocessRequest) (*types.ConstructionPreprocessResponse, *types.Error) {
+	txArgs, err := TxArgsFromOperations(request.Operations, request.Metadata)
+	if err != nil {
+		return nil, LogErrValidation(err)
+	}
+
+	options, err := airgap.MarshallToMap(txArgs)
+	if err != nil {
+		return nil, LogErrInternal(err)
+	}
+
+	return &types.ConstructionPreprocessResponse{
+		Options: options,
+	}, nil
 }
 
 func (s *Servicer) ConstructionMetadata(ctx context.Context, request *types.ConstructionMetadataRequest) (*types.ConstructionMetadataResponse, *types.Error) {
@@ -576,7 +1234,7 @@ func (s *Servicer) ConstructionMetadata(ctx context.Context, request *types.Cons
 }
 
 func (s *Servicer) ConstructionSubmit(ctx context.Context, request *types.ConstructionSubmitRequest) (*types.TransactionIdentifierResponse, *types.Error) {
-	rawTx := common.Hex2Bytes(request.SignedTransaction)
+	rawTx := common.FromHex(request.SignedTransaction)
 
 	txhash, err := s.airgap.SubmitTx(ctx, rawTx)
 	if err != nil {
@@ -595,6 +1253,85 @@ func (s *Servicer) ConstructionSubmit(ctx context.Context, request *types.Constr
 // Private Functions
 // ----------------------------------------------------------------------------------------
 
+// tokenBalances returns the balance of each requested currency (cGLD if none is requested).
+// Stable tokens that are not deployed yet have a zero balance.
+func (s *Servicer) tokenBalances(ctx context.Context, accountAddr common.Address, opts *bind.CallOpts, currencies []*types.Currency) ([]*types.Amount, *types.Error) {
+	if len(currencies) == 0 {
+		currencies = []*types.Currency{CeloGold}
+	}
+
+	var tokens *celotokens.CeloTokens
+	balances := make([]*types.Amount, 0, len(currencies))
+	for _, currency := range currencies {
+		token, ok := TokenFor(currency)
+		if !ok {
+			return nil, LogErrValidation(fmt.Errorf("%w: %s", ErrUnsupportedCurrency, currency.Symbol))
+		}
+
+		if token == celotokens.CELO {
+			goldAmt, err := s.cc.Eth.BalanceAt(ctx, accountAddr, opts.BlockNumber)
+			if err != nil {
+				return nil, LogErrCeloClient("BalanceAt", err)
+			}
+			balances = append(balances, NewAmount(goldAmt, CeloGold))
+			continue
+		}
+
+		if tokens == nil {
+			reg, err := registry.New(s.cc)
+			if err != nil {
+				return nil, LogErrCeloClient("NewRegistry", err)
+			}
+			tokens = celotokens.New(reg)
+		}
+
+		stableToken, err := tokens.GetStableTokenContract(ctx, token, opts.BlockNumber)
+		if err != nil {
+			if registry.IsExpectedBeforeContractsDeployed(err) {
+				balances = append(balances, NewAmount(big.NewInt(0), currency))
+				continue
+			}
+			return nil, LogErrCeloClient("GetStableTokenContract", err)
+		}
+
+		balance, err := stableToken.BalanceOf(opts, accountAddr)
+		if err != nil {
+			return nil, LogErrCeloClient(fmt.Sprintf("%s.balanceOf", token), err)
+		}
+		balances = append(balances, NewAmount(balance, CurrencyFor(token)))
+	}
+	return balances, nil
+}
+
+// coreContractAddresses returns the latest known addresses of the core contracts involved in staking
+// and of the stable tokens.
+// Parsing must work without them, so failures are logged and an empty map is returned.
+func (s *Servicer) coreContractAddresses(ctx context.Context) map[string]common.Address {
+	if s.db == nil {
+		return nil
+	}
+
+	lastPersistedBlock, err := s.db.LastPersistedBlock(ctx)
+	if err != nil {
+		logger.Warn("Can't fetch last persisted block", "err", err)
+		return nil
+	}
+
+	contractNames := []string{registry.LockedGoldContractID.String(), registry.ElectionContractID.String(), registry.AccountsContractID.String()}
+	for _, token := range analyzer.StableTokens {
+		if registryID, err := celotokens.GetRegistryID(token); err == nil {
+			contractNames = append(contractNames, registryID.String())
+		}
+	}
+
+	contractMap, err := s.db.RegistryAddressesStartOf(ctx, lastPersistedBlock, math.MaxInt32, contractNames...)
+	if err != nil {
+		logger.Warn("Can't fetch core contract addresses", "err", err)
+		return nil
+	}
+	return contractMap
+}
+
 func (s *Servicer) blockHeader(ctx context.Context, blockIdentifier *types.PartialBlockIdentifier) (*ethclient.HeaderAndTxnHashes, *types.Error) {
 	if blockIdentifier == nil || blockIdentifier.Hash == nil {
 		var number *big.Int
@@ -603,7 +1340,9 @@ func (s *Servicer) blockHeader(ctx context.Context, blockIdentifier *types.Parti
 		}
 
 		blockHeader, err := s.cc.Eth.HeaderAndTxnHashesByNumber(ctx, number)
-		if err != nil {
+		if err == ethereum.NotFound {
+			return nil, LogErrBlockNotFound(err, blockIdentifier)
+		} else if err != nil {
 			return nil, LogErrCeloClient("HeaderAndTxnHashesByNumber", err)
 		}
 		return blockHeader, nil
@@ -611,7 +1350,9 @@ func (s *Servicer) blockHeader(ctx context.Context, blockIdentifier *types.Parti
 
 	hash := common.HexToHash(*blockIdentifier.Hash)
 	blockHeader, err := s.cc.Eth.HeaderAndTxnHashesByHash(ctx, hash)
-	if err != nil {
+	if err == ethereum.NotFound {
+		return nil, LogErrBlockNotFound(err, blockIdentifier)
+	} else if err != nil {
 		return nil, LogErrCeloClient("HeaderAndTxnHashesByHash", err)
 	}
 	// If both Index and Hash were specified check the result matches
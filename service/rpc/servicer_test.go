@@ -0,0 +1,858 @@
+// Copyright 2020 Celo Org
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	gethTypes "github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/ethclient"
+	"github.com/celo-org/kliento/celotokens"
+	"github.com/celo-org/kliento/contracts"
+	"github.com/celo-org/rosetta/airgap"
+	"github.com/celo-org/rosetta/analyzer"
+	"github.com/celo-org/rosetta/db"
+	"github.com/celo-org/rosetta/service"
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	"github.com/coinbase/rosetta-sdk-go/types"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+	gtypes "github.com/onsi/gomega/types"
+)
+
+func newOfflineServicer() *Servicer {
+	return &Servicer{
+		chainParams:  &service.ChainParameters{ChainId: big.NewInt(44787)},
+		airgapClient: airgap.NewClient(),
+	}
+}
+
+func transferOperations(from, to common.Address, value int64) []*types.Operation {
+	return []*types.Operation{
+		{
+			OperationIdentifier: NewOperationIdentifier(0),
+			Type:                analyzer.OpTransfer.String(),
+			Account:             &types.AccountIdentifier{Address: from.Hex()},
+			Amount:              NewAmount(big.NewInt(-value), CeloGold),
+		},
+		{
+			OperationIdentifier: NewOperationIdentifier(1),
+			Type:                analyzer.OpTransfer.String(),
+			Account:             &types.AccountIdentifier{Address: to.Hex()},
+			Amount:              NewAmount(big.NewInt(value), CeloGold),
+		},
+	}
+}
+
+func MatchConstructionOperation(account common.Address, value int, currency *types.Currency, kind analyzer.OperationType) gtypes.GomegaMatcher {
+	return gs.PointTo(gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+		"Account": gs.PointTo(Equal(NewAccountIdentifier(account, nil))),
+		"Amount":  Equal(NewAmount(big.NewInt(int64(value)), currency)),
+		"Status":  BeNil(),
+		"Type":    Equal(kind.String()),
+	}))
+}
+
+func TestConstructionFlow(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+	s := newOfflineServicer()
+
+	privateKey, err := crypto.GenerateKey()
+	Ω(err).ShouldNot(HaveOccurred())
+	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+	to := common.HexToAddress("0x2222")
+	operations := transferOperations(from, to, 1000)
+
+	preprocessRsp, rosettaErr := s.ConstructionPreprocess(ctx, &types.ConstructionPreprocessRequest{Operations: operations})
+	Ω(rosettaErr).Should(BeNil())
+
+	var txArgs airgap.TxArgs
+	Ω(airgap.UnmarshallFromMap(preprocessRsp.Options, &txArgs)).Should(Succeed())
+	Ω(txArgs.From).Should(Equal(from))
+	Ω(*txArgs.To).Should(Equal(to))
+	Ω(txArgs.Value.Int64()).Should(Equal(int64(1000)))
+
+	// Normally returned by /construction/metadata
+	txMetadata := &airgap.TxMetadata{
+		From:     from,
+		Nonce:    3,
+		GasPrice: big.NewInt(5000000000),
+		To:       to,
+		Data:     []byte{},
+		Value:    txArgs.Value,
+		Gas:      21000,
+		ChainId:  s.chainParams.ChainId,
+	}
+	metadata, err := airgap.MarshallToMap(txMetadata)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	payloadsRsp, rosettaErr := s.ConstructionPayloads(ctx, &types.ConstructionPayloadsRequest{
+		Operations: operations,
+		Metadata:   metadata,
+	})
+	Ω(rosettaErr).Should(BeNil())
+	Ω(payloadsRsp.Payloads).Should(HaveLen(1))
+	Ω(payloadsRsp.Payloads[0].AccountIdentifier.Address).Should(Equal(from.Hex()))
+
+	unsignedParseRsp, rosettaErr := s.ConstructionParse(ctx, &types.ConstructionParseRequest{
+		Signed:      false,
+		Transaction: payloadsRsp.UnsignedTransaction,
+	})
+	Ω(rosettaErr).Should(BeNil())
+	Ω(unsignedParseRsp.AccountIdentifierSigners).Should(BeEmpty())
+	Ω(unsignedParseRsp.Operations).Should(ConsistOf(
+		MatchConstructionOperation(from, -1000, CeloGold, analyzer.OpTransfer),
+		MatchConstructionOperation(to, 1000, CeloGold, analyzer.OpTransfer),
+	))
+
+	signature, err := crypto.Sign(payloadsRsp.Payloads[0].Bytes, privateKey)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	combineRsp, rosettaErr := s.ConstructionCombine(ctx, &types.ConstructionCombineRequest{
+		UnsignedTransaction: payloadsRsp.UnsignedTransaction,
+		Signatures: []*types.Signature{{
+			SigningPayload: payloadsRsp.Payloads[0],
+			SignatureType:  types.EcdsaRecovery,
+			Bytes:          signature,
+		}},
+	})
+	Ω(rosettaErr).Should(BeNil())
+
+	signedParseRsp, rosettaErr := s.ConstructionParse(ctx, &types.ConstructionParseRequest{
+		Signed:      true,
+		Transaction: combineRsp.SignedTransaction,
+	})
+	Ω(rosettaErr).Should(BeNil())
+	Ω(signedParseRsp.AccountIdentifierSigners).Should(Equal([]*types.AccountIdentifier{{Address: from.Hex()}}))
+	Ω(signedParseRsp.Operations).Should(Equal(unsignedParseRsp.Operations))
+
+	hashRsp, rosettaErr := s.ConstructionHash(ctx, &types.ConstructionHashRequest{
+		SignedTransaction: combineRsp.SignedTransaction,
+	})
+	Ω(rosettaErr).Should(BeNil())
+
+	expectedTx, err := airgap.NewClient().SignTx(&airgap.Transaction{TxMetadata: txMetadata}, privateKey)
+	Ω(err).ShouldNot(HaveOccurred())
+	expectedHash, err := expectedTx.Hash()
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(hashRsp.TransactionIdentifier.Hash).Should(Equal(expectedHash.Hex()))
+
+	t.Run("Signature from another key", func(t *testing.T) {
+		RegisterTestingT(t)
+		otherKey, err := crypto.GenerateKey()
+		Ω(err).ShouldNot(HaveOccurred())
+		otherSignature, err := crypto.Sign(payloadsRsp.Payloads[0].Bytes, otherKey)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, rosettaErr := s.ConstructionCombine(ctx, &types.ConstructionCombineRequest{
+			UnsignedTransaction: payloadsRsp.UnsignedTransaction,
+			Signatures: []*types.Signature{{
+				SignatureType: types.EcdsaRecovery,
+				Bytes:         otherSignature,
+			}},
+		})
+		Ω(rosettaErr).ShouldNot(BeNil())
+		Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code))
+	})
+}
+
+func TestConstructionPreprocess_InvalidTransfer(t *testing.T) {
+	RegisterTestingT(t)
+	s := newOfflineServicer()
+
+	operations := transferOperations(common.HexToAddress("0x1111"), common.HexToAddress("0x2222"), 1000)
+	operations[1].Amount = NewAmount(big.NewInt(999), CeloGold)
+
+	_, rosettaErr := s.ConstructionPreprocess(context.Background(), &types.ConstructionPreprocessRequest{Operations: operations})
+	Ω(rosettaErr).ShouldNot(BeNil())
+	Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code))
+}
+
+func TestConstructionPayloads_OperationsMismatch(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+	s := newOfflineServicer()
+	from, to := common.HexToAddress("0x1111"), common.HexToAddress("0x2222")
+	group, electionAddr := common.HexToAddress("0x3333"), common.HexToAddress("0x4444")
+
+	electionAbi, err := contracts.ParseElectionABI()
+	Ω(err).ShouldNot(HaveOccurred())
+	voteData := func(group common.Address, value int64) []byte {
+		data, err := electionAbi.Pack("vote", group, big.NewInt(value), common.ZeroAddress, common.ZeroAddress)
+		Ω(err).ShouldNot(HaveOccurred())
+		return data
+	}
+
+	payloads := func(operations []*types.Operation, to common.Address, value int64, data []byte) *types.Error {
+		metadata, err := airgap.MarshallToMap(&airgap.TxMetadata{
+			From:     from,
+			GasPrice: big.NewInt(5000000000),
+			To:       to,
+			Data:     data,
+			Value:    big.NewInt(value),
+			Gas:      100000,
+			ChainId:  s.chainParams.ChainId,
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, rosettaErr := s.ConstructionPayloads(ctx, &types.ConstructionPayloadsRequest{
+			Operations: operations,
+			Metadata:   metadata,
+		})
+		return rosettaErr
+	}
+
+	transfer := transferOperations(from, to, 1000)
+	vote := OperationsFromAnalyzer(analyzer.NewVote(from, group, big.NewInt(1000)), 0)
+
+	Ω(payloads(transfer, to, 1000, []byte{})).Should(BeNil())
+	Ω(payloads(vote, electionAddr, 0, voteData(group, 1000))).Should(BeNil())
+
+	for name, rosettaErr := range map[string]*types.Error{
+		"Other recipient":  payloads(transfer, common.HexToAddress("0x5555"), 1000, []byte{}),
+		"Other value":      payloads(transfer, to, 999, []byte{}),
+		"Other method":     payloads(transfer, to, 1000, voteData(group, 1000)),
+		"Other group":      payloads(vote, electionAddr, 0, voteData(common.HexToAddress("0x5555"), 1000)),
+		"Other vote value": payloads(vote, electionAddr, 0, voteData(group, 999)),
+		"Value on a vote":  payloads(vote, electionAddr, 1000, voteData(group, 1000)),
+	} {
+		Ω(rosettaErr).ShouldNot(BeNil(), name)
+		Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code), name)
+	}
+}
+
+func TestConstructionCombine_MissingMetadata(t *testing.T) {
+	RegisterTestingT(t)
+	s := newOfflineServicer()
+
+	_, rosettaErr := s.ConstructionCombine(context.Background(), &types.ConstructionCombineRequest{
+		UnsignedTransaction: "{}",
+		Signatures: []*types.Signature{{
+			SignatureType: types.EcdsaRecovery,
+			Bytes:         make([]byte, crypto.SignatureLength),
+		}},
+	})
+	Ω(rosettaErr).ShouldNot(BeNil())
+	Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code))
+}
+
+func TestConstructionParse_InvalidTransactions(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+	s := newOfflineServicer()
+
+	_, rosettaErr := s.ConstructionParse(ctx, &types.ConstructionParseRequest{Signed: false, Transaction: "{}"})
+	Ω(rosettaErr).ShouldNot(BeNil())
+	Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code))
+
+	privateKey, err := crypto.GenerateKey()
+	Ω(err).ShouldNot(HaveOccurred())
+	gethTx, err := gethTypes.SignTx(
+		gethTypes.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(5000000000), []byte{1, 2, 3, 4}),
+		gethTypes.NewEIP155Signer(s.chainParams.ChainId),
+		privateKey,
+	)
+	Ω(err).ShouldNot(HaveOccurred())
+	var rawTx bytes.Buffer
+	Ω(gethTx.EncodeRLP(&rawTx)).Should(Succeed())
+
+	_, rosettaErr = s.ConstructionParse(ctx, &types.ConstructionParseRequest{Signed: true, Transaction: hexutil.Encode(rawTx.Bytes())})
+	Ω(rosettaErr).ShouldNot(BeNil())
+	Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code))
+
+	_, rosettaErr = s.ConstructionHash(ctx, &types.ConstructionHashRequest{SignedTransaction: hexutil.Encode(rawTx.Bytes())})
+	Ω(rosettaErr).ShouldNot(BeNil())
+	Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code))
+}
+
+func TestConstructionParse_StableTokenTransfer(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+	s := newOfflineServicer()
+	tokenAddr := common.HexToAddress("0xd8763cba276a3738e6de85b4b3bf5fded6d6ca73")
+
+	privateKey, err := crypto.GenerateKey()
+	Ω(err).ShouldNot(HaveOccurred())
+	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+	recipient := common.HexToAddress("0x2222")
+
+	stableTokenAbi, err := contracts.ParseStableTokenABI()
+	Ω(err).ShouldNot(HaveOccurred())
+	data, err := stableTokenAbi.Pack("transfer", recipient, big.NewInt(500))
+	Ω(err).ShouldNot(HaveOccurred())
+
+	tx, err := airgap.NewClient().SignTx(&airgap.Transaction{TxMetadata: &airgap.TxMetadata{
+		From:     from,
+		GasPrice: big.NewInt(5000000000),
+		To:       tokenAddr,
+		Data:     data,
+		Gas:      50000,
+		ChainId:  s.chainParams.ChainId,
+	}}, privateKey)
+	Ω(err).ShouldNot(HaveOccurred())
+	rawTx, err := tx.Serialize()
+	Ω(err).ShouldNot(HaveOccurred())
+
+	parse := func() *types.ConstructionParseResponse {
+		parseRsp, rosettaErr := s.ConstructionParse(ctx, &types.ConstructionParseRequest{
+			Signed:      true,
+			Transaction: hexutil.Encode(rawTx),
+		})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(parseRsp.AccountIdentifierSigners).Should(Equal([]*types.AccountIdentifier{{Address: from.Hex()}}))
+		return parseRsp
+	}
+
+	t.Run("Unknown token address", func(t *testing.T) {
+		RegisterTestingT(t)
+		// Offline the token, and so the currency, can't be resolved
+		Ω(parse().Operations).Should(BeEmpty())
+	})
+
+	t.Run("Known token address", func(t *testing.T) {
+		RegisterTestingT(t)
+		celoDb, err := db.NewSqliteDb(":memory:")
+		Ω(err).ShouldNot(HaveOccurred())
+		registryID, err := celotokens.GetRegistryID(celotokens.CEUR)
+		Ω(err).ShouldNot(HaveOccurred())
+		err = celoDb.ApplyChanges(ctx, &db.BlockChangeSet{
+			BlockNumber:     big.NewInt(1),
+			RegistryChanges: []db.RegistryChange{{Contract: registryID.String(), NewAddress: tokenAddr}},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		s.db = celoDb
+
+		Ω(parse().Operations).Should(ConsistOf(
+			MatchConstructionOperation(from, -500, CeloEuro, analyzer.OpTransfer),
+			MatchConstructionOperation(recipient, 500, CeloEuro, analyzer.OpTransfer),
+		))
+	})
+}
+
+func TestConstructionDerive(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+	s := newOfflineServicer()
+
+	privateKey, err := crypto.GenerateKey()
+	Ω(err).ShouldNot(HaveOccurred())
+	addr := crypto.PubkeyToAddress(privateKey.PublicKey)
+	compressed := &types.PublicKey{Bytes: crypto.CompressPubkey(&privateKey.PublicKey), CurveType: types.Secp256k1}
+	uncompressed := &types.PublicKey{Bytes: crypto.FromECDSAPub(&privateKey.PublicKey), CurveType: types.Secp256k1}
+
+	t.Run("Main account", func(t *testing.T) {
+		RegisterTestingT(t)
+		for _, publicKey := range []*types.PublicKey{compressed, uncompressed} {
+			rsp, rosettaErr := s.ConstructionDerive(ctx, &types.ConstructionDeriveRequest{PublicKey: publicKey})
+			Ω(rosettaErr).Should(BeNil())
+			Ω(rsp.AccountIdentifier).Should(Equal(&types.AccountIdentifier{Address: addr.Hex()}))
+		}
+	})
+
+	t.Run("Signer", func(t *testing.T) {
+		RegisterTestingT(t)
+		rsp, rosettaErr := s.ConstructionDerive(ctx, &types.ConstructionDeriveRequest{
+			PublicKey: compressed,
+			Metadata: map[string]interface{}{
+				DeriveSubAccountKey: string(analyzer.AccSigner),
+				DeriveAccountKey:    "0x1111111111111111111111111111111111111111",
+			},
+		})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(rsp.AccountIdentifier.Address).Should(Equal(addr.Hex()))
+		Ω(rsp.AccountIdentifier.SubAccount.Address).Should(Equal(string(analyzer.AccSigner)))
+		Ω(rsp.AccountIdentifier.SubAccount.Metadata).Should(HaveKeyWithValue("account", common.HexToAddress("0x1111111111111111111111111111111111111111")))
+	})
+
+	t.Run("ReleaseGold", func(t *testing.T) {
+		RegisterTestingT(t)
+		releaseGold := common.HexToAddress("0x3333")
+		rsp, rosettaErr := s.ConstructionDerive(ctx, &types.ConstructionDeriveRequest{
+			PublicKey: compressed,
+			Metadata: map[string]interface{}{
+				DeriveSubAccountKey:  string(analyzer.AccReleaseGoldVested),
+				DeriveReleaseGoldKey: releaseGold.Hex(),
+			},
+		})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(rsp.AccountIdentifier.Address).Should(Equal(releaseGold.Hex()))
+		Ω(rsp.AccountIdentifier.SubAccount.Address).Should(Equal(string(analyzer.AccReleaseGoldVested)))
+		Ω(rsp.AccountIdentifier.SubAccount.Metadata).Should(HaveKeyWithValue("beneficiary", addr.Hex()))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, rosettaErr := s.ConstructionDerive(ctx, &types.ConstructionDeriveRequest{
+			PublicKey: &types.PublicKey{Bytes: compressed.Bytes, CurveType: types.Edwards25519},
+		})
+		Ω(rosettaErr).ShouldNot(BeNil())
+
+		_, rosettaErr = s.ConstructionDerive(ctx, &types.ConstructionDeriveRequest{
+			PublicKey: compressed,
+			Metadata:  map[string]interface{}{DeriveSubAccountKey: string(analyzer.AccSigner)},
+		})
+		Ω(rosettaErr).ShouldNot(BeNil())
+	})
+}
+
+func TestInlineTransactions(t *testing.T) {
+	RegisterTestingT(t)
+
+	servicer := newOfflineServicer()
+	var inline bool
+	handler := inlineTransactionsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inline = servicer.inlineTransactions(r.Context())
+	}))
+
+	serve := func(url string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, url, nil))
+		return recorder.Code
+	}
+
+	t.Run("Server default", func(t *testing.T) {
+		RegisterTestingT(t)
+		Ω(serve("/block")).Should(Equal(http.StatusOK))
+		Ω(inline).Should(BeFalse())
+
+		servicer.inlineBlockTransactions = true
+		Ω(serve("/block")).Should(Equal(http.StatusOK))
+		Ω(inline).Should(BeTrue())
+	})
+
+	t.Run("Request override", func(t *testing.T) {
+		RegisterTestingT(t)
+		servicer.inlineBlockTransactions = true
+		Ω(serve("/block?inline_transactions=false")).Should(Equal(http.StatusOK))
+		Ω(inline).Should(BeFalse())
+
+		servicer.inlineBlockTransactions = false
+		Ω(serve("/block?inline_transactions=true")).Should(Equal(http.StatusOK))
+		Ω(inline).Should(BeTrue())
+	})
+
+	t.Run("Invalid value", func(t *testing.T) {
+		RegisterTestingT(t)
+		Ω(serve("/block?inline_transactions=maybe")).Should(Equal(http.StatusBadRequest))
+	})
+}
+
+func TestOfflineMode(t *testing.T) {
+	RegisterTestingT(t)
+
+	chainParams := &service.ChainParameters{ChainId: big.NewInt(44787)}
+	router, err := createRouter(nil, nil, &RosettaServerConfig{Offline: true}, chainParams)
+	Ω(err).ShouldNot(HaveOccurred())
+	handler := offlineHandler(router)
+
+	network := &types.NetworkIdentifier{Blockchain: BlockchainName, Network: chainParams.ChainId.String()}
+	serve := func(url string, request interface{}) *httptest.ResponseRecorder {
+		body, err := json.Marshal(request)
+		Ω(err).ShouldNot(HaveOccurred())
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body)))
+		return recorder
+	}
+
+	t.Run("Offline endpoints", func(t *testing.T) {
+		RegisterTestingT(t)
+		Ω(serve("/network/list", &types.MetadataRequest{}).Code).Should(Equal(http.StatusOK))
+		Ω(serve("/network/options", &types.NetworkRequest{NetworkIdentifier: network}).Code).Should(Equal(http.StatusOK))
+
+		privateKey, err := crypto.GenerateKey()
+		Ω(err).ShouldNot(HaveOccurred())
+		recorder := serve("/construction/derive", &types.ConstructionDeriveRequest{
+			NetworkIdentifier: network,
+			PublicKey:         &types.PublicKey{Bytes: crypto.CompressPubkey(&privateKey.PublicKey), CurveType: types.Secp256k1},
+		})
+		Ω(recorder.Code).Should(Equal(http.StatusOK))
+	})
+
+	t.Run("Online endpoints", func(t *testing.T) {
+		RegisterTestingT(t)
+		recorder := serve("/network/status", &types.NetworkRequest{NetworkIdentifier: network})
+		Ω(recorder.Code).Should(Equal(http.StatusInternalServerError))
+
+		var rosettaErr types.Error
+		Ω(json.Unmarshal(recorder.Body.Bytes(), &rosettaErr)).Should(Succeed())
+		Ω(rosettaErr.Code).Should(Equal(ErrUnavailableOffline.Code))
+		Ω(rosettaErr.Details).Should(HaveKeyWithValue("endpoint", "/network/status"))
+	})
+}
+
+func TestNodeHealthHandler(t *testing.T) {
+	RegisterTestingT(t)
+
+	health := service.NewNodeHealth()
+	handler := nodeHealthHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), health)
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, url, nil))
+		return recorder
+	}
+
+	t.Run("Node down", func(t *testing.T) {
+		RegisterTestingT(t)
+		Ω(serve("/construction/derive").Code).Should(Equal(http.StatusOK))
+
+		recorder := serve("/block")
+		Ω(recorder.Code).Should(Equal(http.StatusInternalServerError))
+
+		var rosettaErr types.Error
+		Ω(json.Unmarshal(recorder.Body.Bytes(), &rosettaErr)).Should(Succeed())
+		Ω(rosettaErr.Code).Should(Equal(ErrNodeUnavailable.Code))
+		Ω(rosettaErr.Retriable).Should(BeTrue())
+		Ω(rosettaErr.Details).Should(HaveKeyWithValue("endpoint", "/block"))
+	})
+
+	t.Run("Node up", func(t *testing.T) {
+		RegisterTestingT(t)
+		health.SetUp()
+		Ω(serve("/block").Code).Should(Equal(http.StatusOK))
+
+		health.SetDown()
+		Ω(serve("/block").Code).Should(Equal(http.StatusInternalServerError))
+		Ω(health.Downs()).Should(Equal(uint64(1)))
+	})
+}
+
+func TestMultiNetworkRouter(t *testing.T) {
+	RegisterTestingT(t)
+
+	networks := []*Network{
+		{ChainParams: &service.ChainParameters{ChainId: big.NewInt(42220)}},
+		{ChainParams: &service.ChainParameters{ChainId: big.NewInt(44787)}},
+	}
+	handler, err := createMultiNetworkRouter(networks, &RosettaServerConfig{})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	serve := func(url string, request interface{}) *httptest.ResponseRecorder {
+		body, err := json.Marshal(request)
+		Ω(err).ShouldNot(HaveOccurred())
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body)))
+		return recorder
+	}
+
+	t.Run("Lists all networks", func(t *testing.T) {
+		RegisterTestingT(t)
+		recorder := serve("/network/list", &types.MetadataRequest{})
+		Ω(recorder.Code).Should(Equal(http.StatusOK))
+
+		var response types.NetworkListResponse
+		Ω(json.Unmarshal(recorder.Body.Bytes(), &response)).Should(Succeed())
+		Ω(response.NetworkIdentifiers).Should(ConsistOf(networks[0].NetworkIdentifier(), networks[1].NetworkIdentifier()))
+	})
+
+	t.Run("Dispatches by network", func(t *testing.T) {
+		RegisterTestingT(t)
+		for _, network := range networks {
+			recorder := serve("/network/options", &types.NetworkRequest{NetworkIdentifier: network.NetworkIdentifier()})
+			Ω(recorder.Code).Should(Equal(http.StatusOK))
+		}
+	})
+
+	t.Run("Unknown network", func(t *testing.T) {
+		RegisterTestingT(t)
+		unknown := &types.NetworkIdentifier{Blockchain: BlockchainName, Network: "62320"}
+		recorder := serve("/network/options", &types.NetworkRequest{NetworkIdentifier: unknown})
+		Ω(recorder.Code).Should(Equal(http.StatusInternalServerError))
+
+		var rosettaErr types.Error
+		Ω(json.Unmarshal(recorder.Body.Bytes(), &rosettaErr)).Should(Succeed())
+		Ω(rosettaErr.Code).Should(Equal(ErrValidation.Code))
+	})
+
+	t.Run("Duplicated network", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, err := createMultiNetworkRouter(append(networks, networks[0]), &RosettaServerConfig{})
+		Ω(err).Should(HaveOccurred())
+	})
+}
+
+func TestBlockTransactionFromStore(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := db.NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	blockHeader := &ethclient.HeaderAndTxnHashes{Header: gethTypes.Header{Number: big.NewInt(10)}}
+	blockHeader.Transactions = []common.Hash{common.HexToHash("0x01")}
+	txHash := blockHeader.Transactions[0]
+	from, to := common.HexToAddress("0x1111"), common.HexToAddress("0x2222")
+
+	encoded, err := analyzer.EncodeOperations([]analyzer.Operation{*analyzer.NewTransfer(from, to, big.NewInt(5), true)})
+	Ω(err).ShouldNot(HaveOccurred())
+	err = celoDb.StoreBlockOperations(ctx, &db.BlockOperations{
+		BlockNumber:  blockHeader.Number,
+		BlockHash:    blockHeader.Hash(),
+		Version:      analyzer.OperationsVersion,
+		Transactions: map[common.Hash][]byte{txHash: encoded},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	servicer := newOfflineServicer()
+	servicer.db = celoDb
+	servicer.chainParams.EpochSize = 17280
+
+	Ω(servicer.blockIndexed(ctx, blockHeader)).Should(BeTrue())
+
+	// Served from the store: there's no node to trace with
+	transaction, rosettaErr := servicer.blockTransaction(ctx, blockHeader, txHash, nil)
+	Ω(rosettaErr).Should(BeNil())
+	Ω(transaction.TransactionIdentifier.Hash).Should(Equal(txHash.Hex()))
+	Ω(transaction.Operations).Should(HaveLen(2))
+	Ω(transaction.Operations[0].Account.Address).Should(Equal(from.Hex()))
+	Ω(transaction.Operations[1].Account.Address).Should(Equal(to.Hex()))
+
+	// Operations stored by a previous version are traced again
+	err = celoDb.StoreBlockOperations(ctx, &db.BlockOperations{
+		BlockNumber:  blockHeader.Number,
+		BlockHash:    blockHeader.Hash(),
+		Version:      analyzer.OperationsVersion - 1,
+		Transactions: map[common.Hash][]byte{txHash: encoded},
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(servicer.blockIndexed(ctx, blockHeader)).Should(BeFalse())
+	_, found, rosettaErr := servicer.storedOperations(ctx, blockHeader, txHash)
+	Ω(rosettaErr).Should(BeNil())
+	Ω(found).Should(BeFalse())
+}
+
+func TestSearchTransactions(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := db.NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	alice, bob := common.HexToAddress("0x1111"), common.HexToAddress("0x2222")
+	storeTx := func(block int64, txHash common.Hash, ops ...analyzer.Operation) {
+		encoded, err := analyzer.EncodeOperations(ops)
+		Ω(err).ShouldNot(HaveOccurred())
+		err = celoDb.StoreBlockOperations(ctx, &db.BlockOperations{
+			BlockNumber:  big.NewInt(block),
+			BlockHash:    common.BigToHash(big.NewInt(block)),
+			Version:      analyzer.OperationsVersion,
+			Transactions: map[common.Hash][]byte{txHash: encoded},
+			Accounts:     analyzer.AccountOperations(txHash, ops),
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+	celoTx, cusdTx := common.HexToHash("0x01"), common.HexToHash("0x02")
+	storeTx(10, celoTx, *analyzer.NewTransfer(alice, bob, big.NewInt(5), true))
+	storeTx(11, cusdTx, *analyzer.NewStableTokenTransfer(celotokens.CUSD, bob, alice, big.NewInt(7)))
+
+	servicer := newOfflineServicer()
+	servicer.db = celoDb
+
+	t.Run("By account", func(t *testing.T) {
+		RegisterTestingT(t)
+		response, rosettaErr := servicer.SearchTransactions(ctx, &types.SearchTransactionsRequest{
+			AccountIdentifier: &types.AccountIdentifier{Address: alice.Hex()},
+		})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(response.TotalCount).Should(Equal(int64(2)))
+		Ω(response.NextOffset).Should(BeNil())
+		Ω(response.Transactions).Should(HaveLen(2))
+		Ω(response.Transactions[0].BlockIdentifier.Index).Should(Equal(int64(11)))
+		Ω(response.Transactions[0].Transaction.TransactionIdentifier.Hash).Should(Equal(cusdTx.Hex()))
+		Ω(response.Transactions[0].Transaction.Operations[0].Amount.Currency).Should(Equal(CeloDollar))
+		Ω(response.Transactions[1].Transaction.TransactionIdentifier.Hash).Should(Equal(celoTx.Hex()))
+	})
+
+	t.Run("By currency with pagination", func(t *testing.T) {
+		RegisterTestingT(t)
+		limit := int64(1)
+		response, rosettaErr := servicer.SearchTransactions(ctx, &types.SearchTransactionsRequest{
+			Address: types.String(bob.Hex()),
+			Limit:   &limit,
+		})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(response.Transactions).Should(HaveLen(1))
+		Ω(*response.NextOffset).Should(Equal(int64(1)))
+
+		response, rosettaErr = servicer.SearchTransactions(ctx, &types.SearchTransactionsRequest{
+			Currency: CeloGold,
+		})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(response.Transactions).Should(HaveLen(1))
+		Ω(response.Transactions[0].Transaction.TransactionIdentifier.Hash).Should(Equal(celoTx.Hex()))
+	})
+
+	t.Run("Block range", func(t *testing.T) {
+		RegisterTestingT(t)
+		var request SearchTransactionsRequest
+		Ω(json.Unmarshal([]byte(`{"address": "`+alice.Hex()+`", "min_block": 11}`), &request)).Should(Succeed())
+
+		response, rosettaErr := servicer.SearchTransactionsInRange(ctx, &request)
+		Ω(rosettaErr).Should(BeNil())
+		Ω(response.Transactions).Should(HaveLen(1))
+		Ω(response.Transactions[0].Transaction.TransactionIdentifier.Hash).Should(Equal(cusdTx.Hex()))
+		// Pinned to the last indexed block, to be sent as max_block for the next pages
+		Ω(response.MaxBlock).Should(Equal(int64(11)))
+
+		encoded, err := json.Marshal(response)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(encoded)).Should(And(ContainSubstring(`"max_block":11`), ContainSubstring(`"total_count":1`)))
+
+		request.MaxBlock = types.Int64(10)
+		response, rosettaErr = servicer.SearchTransactionsInRange(ctx, &request)
+		Ω(rosettaErr).Should(BeNil())
+		Ω(response.Transactions).Should(BeEmpty())
+		Ω(response.MaxBlock).Should(Equal(int64(10)))
+	})
+
+	t.Run("Invalid conditions", func(t *testing.T) {
+		RegisterTestingT(t)
+		_, rosettaErr := servicer.SearchTransactions(ctx, &types.SearchTransactionsRequest{Status: types.String("pending")})
+		Ω(rosettaErr).ShouldNot(BeNil())
+
+		_, rosettaErr = servicer.SearchTransactions(ctx, &types.SearchTransactionsRequest{
+			Status:  types.String(OperationSuccess.String()),
+			Success: types.Bool(false),
+		})
+		Ω(rosettaErr).ShouldNot(BeNil())
+	})
+}
+
+func TestEventsBlocks(t *testing.T) {
+	RegisterTestingT(t)
+	ctx := context.Background()
+
+	celoDb, err := db.NewSqliteDb(":memory:")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	servicer := newOfflineServicer()
+	servicer.db = celoDb
+
+	response, rosettaErr := servicer.EventsBlocks(ctx, &types.EventsBlocksRequest{})
+	Ω(rosettaErr).Should(BeNil())
+	Ω(response.MaxSequence).Should(Equal(int64(0)))
+	Ω(response.Events).Should(BeEmpty())
+
+	for i := int64(1); i <= 5; i++ {
+		err = celoDb.ApplyChanges(ctx, &db.BlockChangeSet{BlockNumber: big.NewInt(i), BlockHash: common.BigToHash(big.NewInt(i))})
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	sequences := func(events []*types.BlockEvent) []int64 {
+		result := make([]int64, len(events))
+		for i, event := range events {
+			result[i] = event.Sequence
+		}
+		return result
+	}
+
+	t.Run("From offset", func(t *testing.T) {
+		RegisterTestingT(t)
+		offset, limit := int64(1), int64(2)
+		response, rosettaErr := servicer.EventsBlocks(ctx, &types.EventsBlocksRequest{Offset: &offset, Limit: &limit})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(response.MaxSequence).Should(Equal(int64(4)))
+		Ω(sequences(response.Events)).Should(Equal([]int64{1, 2}))
+		Ω(response.Events[0].BlockIdentifier).Should(Equal(&types.BlockIdentifier{Index: 2, Hash: common.BigToHash(big.NewInt(2)).Hex()}))
+		Ω(response.Events[0].Type).Should(Equal(types.ADDED))
+	})
+
+	t.Run("From tip", func(t *testing.T) {
+		RegisterTestingT(t)
+		limit := int64(2)
+		response, rosettaErr := servicer.EventsBlocks(ctx, &types.EventsBlocksRequest{Limit: &limit})
+		Ω(rosettaErr).Should(BeNil())
+		Ω(sequences(response.Events)).Should(Equal([]int64{3, 4}))
+	})
+}
+
+func TestNetworkOptions(t *testing.T) {
+	RegisterTestingT(t)
+
+	response, rosettaErr := newOfflineServicer().NetworkOptions(context.Background(), &types.NetworkRequest{})
+	Ω(rosettaErr).Should(BeNil())
+	Ω(asserter.NetworkOptionsResponse(response)).Should(Succeed())
+	Ω(response.Allow.HistoricalBalanceLookup).Should(BeTrue())
+
+	// Look for the declared errors and call methods in the package sources,
+	// so that forgetting to advertise a new one fails here
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	type declaredError struct {
+		Code    int32
+		Message string
+	}
+	var declaredErrors []declaredError
+	var declaredCallMethods []string
+
+	for _, file := range pkgs["rpc"].Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			spec, ok := node.(*ast.ValueSpec)
+			if !ok {
+				return true
+			}
+			for _, value := range spec.Values {
+				if call, ok := value.(*ast.CallExpr); ok {
+					if fn, ok := call.Fun.(*ast.Ident); ok && (fn.Name == "NewErrorResponse" || fn.Name == "NewRetriableErrorResponse") {
+						code, err := strconv.ParseInt(call.Args[0].(*ast.BasicLit).Value, 10, 32)
+						Ω(err).ShouldNot(HaveOccurred())
+						message, err := strconv.Unquote(call.Args[1].(*ast.BasicLit).Value)
+						Ω(err).ShouldNot(HaveOccurred())
+						declaredErrors = append(declaredErrors, declaredError{int32(code), message})
+					}
+				}
+			}
+			if typ, ok := spec.Type.(*ast.Ident); ok && typ.Name == "CallMethod" {
+				for _, value := range spec.Values {
+					method, err := strconv.Unquote(value.(*ast.BasicLit).Value)
+					Ω(err).ShouldNot(HaveOccurred())
+					declaredCallMethods = append(declaredCallMethods, method)
+				}
+			}
+			return true
+		})
+	}
+
+	advertisedErrors := make([]declaredError, len(response.Allow.Errors))
+	for i, rosettaErr := range response.Allow.Errors {
+		advertisedErrors[i] = declaredError{rosettaErr.Code, rosettaErr.Message}
+	}
+
+	Ω(declaredErrors).ShouldNot(BeEmpty())
+	Ω(advertisedErrors).Should(ConsistOf(declaredErrors))
+	Ω(declaredCallMethods).ShouldNot(BeEmpty())
+	Ω(response.Allow.CallMethods).Should(ConsistOf(declaredCallMethods))
+}
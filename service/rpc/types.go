@@ -16,6 +16,7 @@ package rpc
 
 import (
 	gethTypes "github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/kliento/celotokens"
 	"github.com/coinbase/rosetta-sdk-go/types"
 )
 
@@ -28,8 +29,44 @@ var (
 		Symbol:   "cUSD",
 		Decimals: 18,
 	}
+	CeloEuro = &types.Currency{
+		Symbol:   "cEUR",
+		Decimals: 18,
+	}
+	CeloReal = &types.Currency{
+		Symbol:   "cREAL",
+		Decimals: 18,
+	}
 )
 
+// AllCurrencies are the currencies whose balances and transfers are reported
+var AllCurrencies = []*types.Currency{CeloGold, CeloDollar, CeloEuro, CeloReal}
+
+var currenciesByToken = map[celotokens.CeloToken]*types.Currency{
+	celotokens.CELO:  CeloGold,
+	celotokens.CUSD:  CeloDollar,
+	celotokens.CEUR:  CeloEuro,
+	celotokens.CREAL: CeloReal,
+}
+
+// CurrencyFor returns the currency of a celo token, the empty token is CELO
+func CurrencyFor(token celotokens.CeloToken) *types.Currency {
+	if currency, ok := currenciesByToken[token]; ok {
+		return currency
+	}
+	return CeloGold
+}
+
+// TokenFor returns the celo token of a currency, if it's supported
+func TokenFor(currency *types.Currency) (celotokens.CeloToken, bool) {
+	for token, c := range currenciesByToken {
+		if currency != nil && c.Symbol == currency.Symbol && c.Decimals == currency.Decimals {
+			return token, true
+		}
+	}
+	return "", false
+}
+
 type CallResult struct {
 	Raw             []byte                 `json:"raw"`
 	BlockIdentifier *types.BlockIdentifier `json:"block_identifier"`
@@ -46,6 +83,9 @@ const (
 	OperationFailed  OperationResult = "failed"
 )
 
+// OperationPredictedKey is set in the metadata of operations that were predicted instead of traced
+const OperationPredictedKey = "predicted"
+
 const (
 	OptionsFromKey   = "from"
 	OptionsToKey     = "to"
@@ -54,6 +94,13 @@ const (
 	OptionsArgsKey   = "args"
 )
 
+// Metadata keys accepted by /construction/derive
+const (
+	DeriveSubAccountKey  = "sub_account"
+	DeriveAccountKey     = "account"
+	DeriveReleaseGoldKey = "release_gold"
+)
+
 func (or OperationResult) String() string { return string(or) }
 
 func (or OperationResult) ToOperationStatus() *types.OperationStatus {